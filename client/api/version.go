@@ -0,0 +1,6 @@
+package api
+
+// Version of the CLI, which is reported to the server. It is set when building a release:
+//
+//	go build -ldflags "-X wh/cli/api.Version=1.0.0"
+var Version = "dev"
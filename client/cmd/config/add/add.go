@@ -1,12 +1,12 @@
 package add
 
 import (
-	"fmt"
 	"net/url"
 	"os"
 	"strings"
 
 	"wh/cli/config"
+	"wh/cli/console"
 
 	"github.com/spf13/cobra"
 )
@@ -18,7 +18,7 @@ var AddCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		server, err := url.Parse(args[0])
 		if err != nil {
-			fmt.Printf("Error: Server is not a valid URL.\n")
+			console.Logf("Error: Server is not a valid URL.\n")
 			os.Exit(1)
 			return
 		}
@@ -35,14 +35,14 @@ var AddCmd = &cobra.Command{
 
 		cfg, err := config.GetConfiguration()
 		if err != nil {
-			fmt.Printf("Error: Failed to retrieve configuration. %v\n", err)
+			console.Logf("Error: Failed to retrieve configuration. %v\n", err)
 			os.Exit(1)
 			return
 		}
 
 		for _, server := range cfg.Servers {
 			if server.Name == name {
-				fmt.Printf("Error: A configuration with this name already exist.\n")
+				console.Logf("Error: A configuration with this name already exist.\n")
 				os.Exit(1)
 				return
 			}
@@ -57,12 +57,12 @@ var AddCmd = &cobra.Command{
 
 		err = config.StoreConfiguration(cfg)
 		if err != nil {
-			fmt.Printf("Error: Failed to store configuration. %v\n", err)
+			console.Logf("Error: Failed to store configuration. %v\n", err)
 			os.Exit(1)
 			return
 		}
 
-		fmt.Printf("Added configuration using the name '%s'\n", name)
+		console.Logf("Added configuration using the name '%s'\n", name)
 	},
 }
 
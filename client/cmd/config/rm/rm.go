@@ -1,11 +1,11 @@
 package rm
 
 import (
-	"fmt"
 	"os"
 	"slices"
 
 	"wh/cli/config"
+	"wh/cli/console"
 
 	"github.com/spf13/cobra"
 )
@@ -19,7 +19,7 @@ var RmCmd = &cobra.Command{
 
 		cfg, err := config.GetConfiguration()
 		if err != nil {
-			fmt.Printf("Error: Failed to retrieve configuration. %v\n", err)
+			console.Logf("Error: Failed to retrieve configuration. %v\n", err)
 			os.Exit(1)
 			return
 		}
@@ -34,19 +34,19 @@ var RmCmd = &cobra.Command{
 		}
 
 		if !hasConfig {
-			fmt.Printf("Error: Config with this name '%s' does not exist.\n", name)
+			console.Logf("Error: Config with this name '%s' does not exist.\n", name)
 			os.Exit(1)
 			return
 		}
 
 		err = config.StoreConfiguration(cfg)
 		if err != nil {
-			fmt.Printf("Error: Failed to store configuration. %v\n", err)
+			console.Logf("Error: Failed to store configuration. %v\n", err)
 			os.Exit(1)
 			return
 		}
 
-		fmt.Printf("Configuration %s removed.\n", name)
+		console.Logf("Configuration %s removed.\n", name)
 	},
 }
 
@@ -1,10 +1,10 @@
 package use
 
 import (
-	"fmt"
 	"os"
 
 	"wh/cli/config"
+	"wh/cli/console"
 
 	"github.com/spf13/cobra"
 )
@@ -18,7 +18,7 @@ var UseCmd = &cobra.Command{
 
 		cfg, err := config.GetConfiguration()
 		if err != nil {
-			fmt.Printf("Error: Failed to retrieve configuration. %v\n", err)
+			console.Logf("Error: Failed to retrieve configuration. %v\n", err)
 			os.Exit(1)
 			return
 		}
@@ -32,7 +32,7 @@ var UseCmd = &cobra.Command{
 		}
 
 		if hasConfig {
-			fmt.Printf("Error: Config with this name does not exist.\n")
+			console.Logf("Error: Config with this name does not exist.\n")
 			os.Exit(1)
 			return
 		}
@@ -41,12 +41,12 @@ var UseCmd = &cobra.Command{
 
 		err = config.StoreConfiguration(cfg)
 		if err != nil {
-			fmt.Printf("Error: Failed to store configuration. %v\n", err)
+			console.Logf("Error: Failed to store configuration. %v\n", err)
 			os.Exit(1)
 			return
 		}
 
-		fmt.Printf("Configuration %s set active.\n", name)
+		console.Logf("Configuration %s set active.\n", name)
 	},
 }
 
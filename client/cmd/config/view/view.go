@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"wh/cli/config"
+	"wh/cli/console"
 
 	"github.com/alexeyco/simpletable"
 	"github.com/spf13/cobra"
@@ -16,11 +17,16 @@ var ViewCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		cfg, err := config.GetConfiguration()
 		if err != nil {
-			fmt.Printf("Error: Failed to retrieve configuration. %v\n", err)
+			console.Logf("Error: Failed to retrieve configuration. %v\n", err)
 			os.Exit(1)
 			return
 		}
 
+		if console.IsJSON() {
+			writeJSON(cfg)
+			return
+		}
+
 		table := simpletable.New()
 
 		table.Header = &simpletable.Header{
@@ -55,3 +61,32 @@ var ViewCmd = &cobra.Command{
 		fmt.Println(table.String())
 	},
 }
+
+type configurationView struct {
+	Server  string       `json:"server"`
+	Servers []serverView `json:"servers"`
+}
+
+type serverView struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	Used     bool   `json:"used"`
+}
+
+// The API keys are not written, because the output might end up in logs of a CI job.
+func writeJSON(cfg *config.Configuration) {
+	view := configurationView{
+		Server:  cfg.Server,
+		Servers: make([]serverView, 0, len(cfg.Servers)),
+	}
+
+	for _, server := range cfg.Servers {
+		view.Servers = append(view.Servers, serverView{
+			Name:     server.Name,
+			Endpoint: server.Endpoint,
+			Used:     server.Name == cfg.Server,
+		})
+	}
+
+	console.WriteJSON(view)
+}
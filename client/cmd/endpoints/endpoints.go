@@ -0,0 +1,18 @@
+package endpoints
+
+import (
+	"wh/cli/cmd/endpoints/reserve"
+
+	"github.com/spf13/cobra"
+)
+
+var EndpointsCmd = &cobra.Command{
+	Use:   "endpoints",
+	Short: "Manages endpoints",
+	Long: `Reserve an endpoint for the API key of the current configuration:
+	endpoints reserve <ENDPOINT>`,
+}
+
+func init() {
+	EndpointsCmd.AddCommand(reserve.ReserveCmd)
+}
@@ -0,0 +1,52 @@
+package reserve
+
+import (
+	"os"
+
+	"wh/cli/api"
+	"wh/cli/api/tunnel"
+	"wh/cli/console"
+
+	"github.com/spf13/cobra"
+)
+
+var ReserveCmd = &cobra.Command{
+	Use:   "reserve <ENDPOINT>",
+	Short: "Reserves an endpoint for the current API key.",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Run: func(cmd *cobra.Command, args []string) {
+		endpoint := args[0]
+
+		client, ctx, err := api.GetClient()
+		if err != nil {
+			console.Logf("Error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		defer func() {
+			// There is very little we can do right now.
+			_ = client.Connection.Close()
+		}()
+
+		response, err := client.Service.Reserve(ctx, &tunnel.ReserveRequest{Endpoint: &endpoint})
+		if err != nil {
+			console.Logf("Error: Failed to reserve endpoint. Endpoint is probably reserved by another API key. %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		if console.IsJSON() {
+			console.WriteJSON(reserveView{Endpoint: response.GetEndpoint()})
+			return
+		}
+
+		console.Logf("Endpoint %s reserved.\n", response.GetEndpoint())
+	},
+}
+
+type reserveView struct {
+	Endpoint string `json:"endpoint"`
+}
+
+func init() {}
@@ -3,22 +3,36 @@ package cmd
 import (
 	"os"
 
+	"wh/cli/api"
 	"wh/cli/cmd/config"
+	"wh/cli/cmd/endpoints"
 	"wh/cli/cmd/tunnel"
+	"wh/cli/console"
 
 	"github.com/spf13/cobra"
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "cli",
-	Short: "Command line interface to work with the wh API",
+	Use:     "cli",
+	Short:   "Command line interface to work with the wh API",
+	Version: api.Version,
 	Long: `This CLI is responsible to establish the tunnel.
 
 Add the configuration using the URL as config name:
 	config add <URL> <APIKEY>
 
 Create a tunnel from an endpoint to a local server:
-	tunnel <endpoint> <local_server>.`,
+	tunnel <endpoint> <local_server>.
+
+Reserve an endpoint for your API key:
+	endpoints reserve <endpoint>
+
+Write the data as JSON for scripts, the messages are written to stderr:
+	--output json <command>`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("output")
+		return console.SetFormat(format)
+	},
 }
 
 func Execute() {
@@ -29,6 +43,9 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringP("output", "o", console.FormatText, "Output format: text or json")
+
 	rootCmd.AddCommand(config.ConfigCmd)
+	rootCmd.AddCommand(endpoints.EndpointsCmd)
 	rootCmd.AddCommand(tunnel.TunnelCmd)
 }
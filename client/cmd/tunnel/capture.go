@@ -0,0 +1,181 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// A copy of a request and its response, for example to show it in the inspector.
+type Exchange struct {
+	Completed         bool
+	Duration          time.Duration
+	Error             string
+	Method            string
+	Path              string
+	Replayed          bool
+	RequestBody       []byte
+	RequestComplete   bool
+	RequestHeaders    http.Header
+	RequestId         string
+	RequestTruncated  bool
+	ResponseBody      []byte
+	ResponseHeaders   http.Header
+	ResponseTruncated bool
+	Size              int
+	Started           time.Time
+	Status            int32
+	Url               string
+}
+
+// Capture keeps a copy of the request and the response until the body limit is reached.
+// It has to be called before the request is started.
+func (r *TunneledRequest) Capture(maxBodySize int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.captured = &Exchange{
+		Method:         r.Method,
+		Path:           formatPath(r.Path),
+		RequestHeaders: r.Headers,
+		RequestId:      r.RequestId,
+		Started:        time.Now(),
+		Url:            r.Url,
+	}
+
+	r.maxCaptureSize = maxBodySize
+
+	r.OnResponseStart(func(msg HttpResponseStart) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		r.captured.ResponseHeaders = msg.Headers
+		r.captured.Status = msg.Status
+	})
+
+	r.OnResponseData(func(msg HttpResponseData) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		r.captured.ResponseBody, r.captured.ResponseTruncated = appendCapture(r.captured.ResponseBody, msg.Data, r.captured.ResponseTruncated, r.maxCaptureSize)
+		r.captured.Size += len(msg.Data)
+
+		if msg.Completed {
+			r.captured.Completed = true
+			r.captured.Duration = time.Since(r.captured.Started)
+		}
+	})
+
+	r.OnError(func(msg HttpError) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		r.captured.Completed = true
+		r.captured.Duration = time.Since(r.captured.Started)
+
+		if msg.Error != nil {
+			r.captured.Error = msg.Error.Error()
+		} else if msg.Timeout {
+			r.captured.Error = "Failed with client timeout"
+		}
+	})
+}
+
+// Snapshot returns a copy of the captured exchange, the request might still be running.
+func (r *TunneledRequest) Snapshot() (Exchange, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.captured == nil {
+		return Exchange{}, false
+	}
+
+	return *r.captured, true
+}
+
+func (r *TunneledRequest) captureRequestData(data []byte, completed bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.captured == nil {
+		return
+	}
+
+	r.captured.RequestBody, r.captured.RequestTruncated = appendCapture(r.captured.RequestBody, data, r.captured.RequestTruncated, r.maxCaptureSize)
+	r.captured.RequestComplete = completed
+}
+
+// CanReplay indicates whether the complete request body is known.
+func (e Exchange) CanReplay() bool {
+	return e.RequestComplete && !e.RequestTruncated
+}
+
+// Replay sends the request to the local server again and captures the response as a new exchange.
+func (e Exchange) Replay(ctx context.Context, requestId string, maxBodySize int) (result Exchange) {
+	result = Exchange{
+		Method:          e.Method,
+		Path:            e.Path,
+		Replayed:        true,
+		RequestBody:     e.RequestBody,
+		RequestComplete: true,
+		RequestHeaders:  e.RequestHeaders,
+		RequestId:       requestId,
+		Started:         time.Now(),
+		Url:             e.Url,
+	}
+
+	defer func() {
+		result.Completed = true
+		result.Duration = time.Since(result.Started)
+	}()
+
+	request, err := http.NewRequestWithContext(ctx, e.Method, e.Url, bytes.NewReader(e.RequestBody))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	request.Header = e.RequestHeaders.Clone()
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	defer response.Body.Close()
+
+	result.ResponseHeaders = response.Header
+	result.Status = int32(response.StatusCode)
+
+	// Only keep the captured part in memory, the rest of the body is just counted.
+	body, err := io.ReadAll(io.LimitReader(response.Body, int64(maxBodySize)+1))
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(io.Discard, response.Body)
+		result.Size = int(rest)
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	result.Size += len(body)
+	result.ResponseBody, result.ResponseTruncated = appendCapture(nil, body, false, maxBodySize)
+
+	return result
+}
+
+func appendCapture(body []byte, data []byte, truncated bool, maxBodySize int) ([]byte, bool) {
+	if truncated {
+		return body, true
+	}
+
+	if len(body)+len(data) > maxBodySize {
+		return append(body, data[:maxBodySize-len(body)]...), true
+	}
+
+	return append(body, data...), false
+}
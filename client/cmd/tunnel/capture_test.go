@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestExchange(url string) Exchange {
+	return Exchange{
+		Method:          http.MethodPost,
+		Path:            "/",
+		RequestBody:     []byte("{}"),
+		RequestComplete: true,
+		RequestHeaders:  http.Header{},
+		Url:             url,
+	}
+}
+
+func TestExchangeReplayTruncatesResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 100))
+	}))
+
+	defer server.Close()
+
+	result := newTestExchange(server.URL).Replay(context.Background(), "replay-1", 10)
+
+	if result.Error != "" || !result.Completed || result.Status != http.StatusOK {
+		t.Fatalf("expected the completed replay, got %+v", result)
+	}
+
+	// Only the captured part is kept, but the full size is reported.
+	if len(result.ResponseBody) != 10 || !result.ResponseTruncated || result.Size != 100 {
+		t.Errorf("expected 10 of 100 bytes, got %d of %d", len(result.ResponseBody), result.Size)
+	}
+}
+
+func TestExchangeReplayStopsWithContext(t *testing.T) {
+	release := make(chan bool)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	result := newTestExchange(server.URL).Replay(ctx, "replay-1", 1024)
+
+	if result.Error == "" || !result.Completed {
+		t.Fatalf("expected the replay to fail, got %+v", result)
+	}
+
+	if string(result.ResponseBody) != "partial" || result.Size != len("partial") {
+		t.Errorf("expected the partial response, got %q", result.ResponseBody)
+	}
+}
@@ -0,0 +1,367 @@
+package tunnel
+
+import (
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Larger bodies are truncated, the inspector keeps everything in memory.
+	inspectorMaxBodySize = 1024 * 1024
+
+	// Old requests are removed to limit the memory usage of long running tunnels.
+	inspectorMaxEntries = 500
+
+	// The browser waits for the result of a replay, therefore the local server has to answer in time.
+	inspectorReplayTimeout = 1 * time.Minute
+)
+
+//go:embed inspector.html
+var inspectorPage []byte
+
+// Serves a web UI on the machine of the developer that shows the requests handled by the client.
+type inspector struct {
+	entries []inspectorEntry
+	hosts   map[string]bool
+	lock    sync.Mutex
+	replays int
+	server  *http.Server
+	url     string
+}
+
+// Either a tunneled request that might still be running or a replayed request.
+type inspectorEntry struct {
+	id       string
+	replayed *Exchange
+	request  *TunneledRequest
+}
+
+type inspectorSummary struct {
+	Completed  bool      `json:"completed"`
+	DurationMs int64     `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+	Id         string    `json:"id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Replayed   bool      `json:"replayed"`
+	Size       int       `json:"size"`
+	Started    time.Time `json:"started"`
+	Status     int32     `json:"status"`
+}
+
+type inspectorDetail struct {
+	inspectorSummary
+
+	CanReplay bool              `json:"canReplay"`
+	Request   inspectorMessage  `json:"request"`
+	Response  *inspectorMessage `json:"response,omitempty"`
+	Url       string            `json:"url"`
+}
+
+type inspectorMessage struct {
+	// The body is base64 encoded when it is not valid UTF-8.
+	Binary    bool        `json:"binary"`
+	Body      string      `json:"body"`
+	Headers   http.Header `json:"headers"`
+	Truncated bool        `json:"truncated"`
+}
+
+// An address without a host only listens on localhost, because the inspector shows the bodies of the requests.
+func newInspector(address string) (*inspector, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inspector address '%s': %v", address, err)
+	}
+
+	if host == "" {
+		host = "localhost"
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the inspector: %v", err)
+	}
+
+	i := &inspector{
+		entries: make([]inspectorEntry, 0),
+		hosts:   getInspectorHosts(host),
+		url:     "http://" + listener.Addr().String(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", i.index)
+	mux.HandleFunc("/api/requests", i.list)
+	mux.HandleFunc("/api/requests/", i.detail)
+
+	i.server = &http.Server{
+		Handler:           i.checkHost(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		// The error is always returned when the server is closed.
+		_ = i.server.Serve(listener)
+	}()
+
+	return i, nil
+}
+
+// Url returns the address of the UI.
+func (i *inspector) Url() string {
+	return i.url
+}
+
+// Add starts to capture the request. It has to be called before the request is started.
+func (i *inspector) Add(request *TunneledRequest) {
+	request.Capture(inspectorMaxBodySize)
+
+	i.add(inspectorEntry{id: request.RequestId, request: request})
+}
+
+func (i *inspector) Close() {
+	_ = i.server.Close()
+}
+
+func (i *inspector) add(entry inspectorEntry) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.entries = append(i.entries, entry)
+	if len(i.entries) > inspectorMaxEntries {
+		i.entries = i.entries[1:]
+	}
+}
+
+func (i *inspector) find(id string) (Exchange, bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, e := range i.entries {
+		if e.id == id {
+			return e.snapshot()
+		}
+	}
+
+	return Exchange{}, false
+}
+
+// GET /
+func (i *inspector) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(inspectorPage)
+}
+
+// GET /api/requests
+func (i *inspector) list(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	i.lock.Lock()
+	entries := make([]inspectorEntry, len(i.entries))
+	copy(entries, i.entries)
+	i.lock.Unlock()
+
+	// The latest request first.
+	result := make([]inspectorSummary, 0, len(entries))
+	for j := len(entries) - 1; j >= 0; j-- {
+		if exchange, ok := entries[j].snapshot(); ok {
+			result = append(result, toInspectorSummary(exchange))
+		}
+	}
+
+	writeInspectorJSON(w, http.StatusOK, result)
+}
+
+// GET /api/requests/<ID>, POST /api/requests/<ID>/replay
+func (i *inspector) detail(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/requests/")
+
+	if id, ok := strings.CutSuffix(path, "/replay"); ok {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Other websites can send simple requests to localhost, but only same-origin JavaScript can set the content type without a preflight.
+		if !isSameOrigin(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		i.replay(w, r, id)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	exchange, ok := i.find(path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeInspectorJSON(w, http.StatusOK, toInspectorDetail(exchange))
+}
+
+func (i *inspector) replay(w http.ResponseWriter, r *http.Request, id string) {
+	exchange, ok := i.find(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !exchange.CanReplay() {
+		http.Error(w, "The request cannot be replayed, because the body has not been captured completely.", http.StatusConflict)
+		return
+	}
+
+	i.lock.Lock()
+	i.replays++
+	replayId := fmt.Sprintf("replay-%d", i.replays)
+	i.lock.Unlock()
+
+	// The replay is canceled when the browser does not wait for it anymore.
+	ctx, cancel := context.WithTimeout(r.Context(), inspectorReplayTimeout)
+	defer cancel()
+
+	result := exchange.Replay(ctx, replayId, inspectorMaxBodySize)
+
+	i.add(inspectorEntry{id: replayId, replayed: &result})
+
+	writeInspectorJSON(w, http.StatusCreated, toInspectorDetail(result))
+}
+
+// Rejects requests for other host names, otherwise a website could read the captured requests with DNS rebinding.
+func (i *inspector) checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if !i.hosts[strings.ToLower(strings.Trim(host, "[]"))] {
+			http.Error(w, "Invalid host", http.StatusMisdirectedRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The inspector can be opened with the host of the listen address or with localhost.
+func getInspectorHosts(host string) map[string]bool {
+	result := map[string]bool{
+		"localhost": true,
+		"127.0.0.1": true,
+		"::1":       true,
+	}
+
+	// The unspecified address is never used as host by the browser.
+	if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+		result[strings.ToLower(host)] = true
+	}
+
+	return result
+}
+
+// Browsers always send the origin for POST requests, other clients do not send it at all.
+func isSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return r.Header.Get("Sec-Fetch-Site") == ""
+	}
+
+	return origin == "http://"+r.Host
+}
+
+func (e inspectorEntry) snapshot() (Exchange, bool) {
+	if e.replayed != nil {
+		return *e.replayed, true
+	}
+
+	return e.request.Snapshot()
+}
+
+func toInspectorSummary(exchange Exchange) inspectorSummary {
+	duration := exchange.Duration
+	if !exchange.Completed {
+		duration = time.Since(exchange.Started)
+	}
+
+	return inspectorSummary{
+		Completed:  exchange.Completed,
+		DurationMs: duration.Milliseconds(),
+		Error:      exchange.Error,
+		Id:         exchange.RequestId,
+		Method:     exchange.Method,
+		Path:       exchange.Path,
+		Replayed:   exchange.Replayed,
+		Size:       exchange.Size,
+		Started:    exchange.Started,
+		Status:     exchange.Status,
+	}
+}
+
+func toInspectorDetail(exchange Exchange) inspectorDetail {
+	result := inspectorDetail{
+		inspectorSummary: toInspectorSummary(exchange),
+		CanReplay:        exchange.CanReplay(),
+		Request:          toInspectorMessage(exchange.RequestHeaders, exchange.RequestBody, exchange.RequestTruncated),
+		Url:              exchange.Url,
+	}
+
+	if exchange.ResponseHeaders != nil {
+		response := toInspectorMessage(exchange.ResponseHeaders, exchange.ResponseBody, exchange.ResponseTruncated)
+		result.Response = &response
+	}
+
+	return result
+}
+
+func toInspectorMessage(headers http.Header, body []byte, truncated bool) inspectorMessage {
+	result := inspectorMessage{
+		Headers:   headers,
+		Truncated: truncated,
+	}
+
+	if utf8.Valid(body) {
+		result.Body = string(body)
+	} else {
+		result.Binary = true
+		result.Body = base64.StdEncoding.EncodeToString(body)
+	}
+
+	return result
+}
+
+func writeInspectorJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(value)
+}
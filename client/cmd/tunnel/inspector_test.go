@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestInspector(t *testing.T, address string) *inspector {
+	i, err := newInspector(address)
+	if err != nil {
+		t.Fatalf("failed to start inspector: %v", err)
+	}
+
+	t.Cleanup(i.Close)
+	return i
+}
+
+func (i *inspector) call(request *http.Request) int {
+	response := httptest.NewRecorder()
+	i.server.Handler.ServeHTTP(response, request)
+
+	return response.Code
+}
+
+func TestInspectorHost(t *testing.T) {
+	tests := []struct {
+		address  string
+		host     string
+		expected int
+	}{
+		{address: "127.0.0.1:0", host: "localhost:4040", expected: http.StatusOK},
+		{address: "127.0.0.1:0", host: "127.0.0.1:4040", expected: http.StatusOK},
+		{address: "127.0.0.1:0", host: "[::1]:4040", expected: http.StatusOK},
+		{address: "127.0.0.1:0", host: "LOCALHOST", expected: http.StatusOK},
+		{address: "127.0.0.1:0", host: "attacker.example:4040", expected: http.StatusMisdirectedRequest},
+		{address: "127.0.0.1:0", host: "", expected: http.StatusMisdirectedRequest},
+		{address: "0.0.0.0:0", host: "0.0.0.0:4040", expected: http.StatusMisdirectedRequest},
+		{address: "0.0.0.0:0", host: "localhost:4040", expected: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address+" "+test.host, func(t *testing.T) {
+			i := newTestInspector(t, test.address)
+
+			request := httptest.NewRequest(http.MethodGet, "/api/requests", nil)
+			request.Host = test.host
+
+			if actual := i.call(request); actual != test.expected {
+				t.Errorf("expected status %d, got %d", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestInspectorReplayChecks(t *testing.T) {
+	i := newTestInspector(t, "127.0.0.1:0")
+
+	tests := []struct {
+		name        string
+		contentType string
+		headers     map[string]string
+		expected    int
+	}{
+		// Unknown requests are only reported when the checks have passed.
+		{name: "same origin", contentType: "application/json", headers: map[string]string{"Origin": "http://localhost:4040"}, expected: http.StatusNotFound},
+		{name: "charset", contentType: "application/json; charset=utf-8", headers: map[string]string{"Origin": "http://localhost:4040"}, expected: http.StatusNotFound},
+		{name: "without browser", contentType: "application/json", expected: http.StatusNotFound},
+		{name: "other origin", contentType: "application/json", headers: map[string]string{"Origin": "http://attacker.example"}, expected: http.StatusForbidden},
+		{name: "other port", contentType: "application/json", headers: map[string]string{"Origin": "http://localhost:8080"}, expected: http.StatusForbidden},
+		{name: "opaque origin", contentType: "application/json", headers: map[string]string{"Origin": "null"}, expected: http.StatusForbidden},
+		{name: "cross site without origin", contentType: "application/json", headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, expected: http.StatusForbidden},
+		{name: "form", contentType: "application/x-www-form-urlencoded", headers: map[string]string{"Origin": "http://localhost:4040"}, expected: http.StatusUnsupportedMediaType},
+		{name: "text", contentType: "text/plain", expected: http.StatusUnsupportedMediaType},
+		{name: "no content type", expected: http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/requests/unknown/replay", nil)
+			request.Host = "localhost:4040"
+
+			if test.contentType != "" {
+				request.Header.Set("Content-Type", test.contentType)
+			}
+
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+
+			if actual := i.call(request); actual != test.expected {
+				t.Errorf("expected status %d, got %d", test.expected, actual)
+			}
+		})
+	}
+}
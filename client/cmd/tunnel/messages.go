@@ -1,6 +1,14 @@
 package tunnel
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
+
+type HttpRequestResume struct {
+	// The actual request
+	Request *TunneledRequest
+}
 
 type HttpResponseStart struct {
 	// The actual request
@@ -11,6 +19,9 @@ type HttpResponseStart struct {
 
 	// The status code.
 	Status int32
+
+	// The time until the response has been received from the local server.
+	Duration time.Duration
 }
 
 type HttpResponseData struct {
@@ -0,0 +1,341 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"wh/cli/console"
+
+	"github.com/mattn/go-isatty"
+)
+
+// Shows the progress of the tunnel to the user. The methods might be called from multiple goroutines.
+type output interface {
+	// Subscribed is called once the server has confirmed the subscription.
+	Subscribed(publicUrl string, localBase string)
+
+	// RequestStarted is called when the server forwards a request to the client.
+	RequestStarted(request *TunneledRequest)
+
+	// RequestData is called for every chunk of the request body.
+	RequestData(request *TunneledRequest, data []byte, completed bool)
+
+	// ResponseStarted is called when the local server has sent the status and the headers.
+	ResponseStarted(msg HttpResponseStart)
+
+	// ResponseData is called for every chunk of the response body.
+	ResponseData(msg HttpResponseData)
+
+	// RequestFailed is called when the request has not been completed, for example when it has been rejected or aborted.
+	RequestFailed(request *TunneledRequest, err error)
+
+	// Notice shows a message about the state of the tunnel.
+	Notice(format string, a ...any)
+
+	// Closed is called once when the tunnel has been closed. The error is nil when the client has closed the tunnel.
+	Closed(err error, session *session)
+}
+
+// Uses the full screen UI only when it has been requested and the client runs in a terminal.
+func newOutput(tui bool, interrupt func()) output {
+	if console.IsJSON() {
+		return newJsonOutput()
+	}
+
+	if tui && isTerminal(os.Stdout) && isTerminal(os.Stdin) {
+		return newTuiOutput(interrupt)
+	}
+
+	return newLineOutput()
+}
+
+func isTerminal(file *os.File) bool {
+	return isatty.IsTerminal(file.Fd()) || isatty.IsCygwinTerminal(file.Fd())
+}
+
+// Prints one line per step of a request.
+type lineOutput struct {
+	tracker *requestTracker
+}
+
+func newLineOutput() output {
+	return &lineOutput{tracker: newRequestTracker()}
+}
+
+func (o *lineOutput) Subscribed(publicUrl string, localBase string) {
+	fmt.Println()
+	fmt.Printf("WEBHOOK TUNNEL")
+	fmt.Println()
+	fmt.Println()
+	fmt.Printf("Forwarding from:  %s\n", publicUrl)
+	fmt.Printf("Forwarding to:    %s\n", localBase)
+	fmt.Println()
+	fmt.Println("HTTP Requests")
+	fmt.Println("-------------")
+	fmt.Println()
+}
+
+func (o *lineOutput) RequestStarted(request *TunneledRequest) {
+	o.tracker.Started(request)
+
+	printStatus(request, "Started")
+}
+
+func (o *lineOutput) RequestData(request *TunneledRequest, data []byte, completed bool) {
+}
+
+func (o *lineOutput) ResponseStarted(msg HttpResponseStart) {
+	o.tracker.ResponseStarted(msg)
+}
+
+func (o *lineOutput) ResponseData(msg HttpResponseData) {
+	if stats, ok := o.tracker.ResponseData(msg); ok {
+		printStatus(msg.Request, "%s in %s, %s",
+			formatStatus(stats.status),
+			formatDuration(stats.duration),
+			formatSize(stats.size))
+	}
+}
+
+func (o *lineOutput) RequestFailed(request *TunneledRequest, err error) {
+	o.tracker.Failed(request)
+
+	if errors.Is(err, ErrAborted) {
+		printStatus(request, "Aborted")
+	} else {
+		printStatus(request, "Error: %v", err)
+	}
+}
+
+func (o *lineOutput) Notice(format string, a ...any) {
+	fmt.Println()
+	fmt.Printf(format+"\n", a...)
+}
+
+func (o *lineOutput) Closed(err error, session *session) {
+	if err != nil {
+		fmt.Printf("Error: Connection closed by server: %v.\n", err)
+	} else {
+		fmt.Println("Tunnel closed.")
+	}
+
+	session.Print()
+}
+
+// Writes one JSON document per step of a request, so that scripts can process the output line by line.
+type jsonOutput struct {
+	tracker *requestTracker
+}
+
+type jsonEvent struct {
+	// The step, for example subscribed or response.
+	Event string `json:"event"`
+
+	// The time of the step.
+	Time time.Time `json:"time"`
+
+	RequestId  string          `json:"requestId,omitempty"`
+	Method     string          `json:"method,omitempty"`
+	Path       string          `json:"path,omitempty"`
+	Status     int32           `json:"status,omitempty"`
+	DurationMs *int64          `json:"durationMs,omitempty"`
+	Size       *int            `json:"size,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Aborted    bool            `json:"aborted,omitempty"`
+	PublicUrl  string          `json:"publicUrl,omitempty"`
+	LocalUrl   string          `json:"localUrl,omitempty"`
+	Session    *sessionSummary `json:"session,omitempty"`
+}
+
+func newJsonOutput() output {
+	return &jsonOutput{tracker: newRequestTracker()}
+}
+
+func (o *jsonOutput) Subscribed(publicUrl string, localBase string) {
+	console.WriteJSON(jsonEvent{Event: "subscribed", Time: time.Now(), PublicUrl: publicUrl, LocalUrl: localBase})
+}
+
+func (o *jsonOutput) RequestStarted(request *TunneledRequest) {
+	o.tracker.Started(request)
+
+	console.WriteJSON(jsonEvent{
+		Event:     "requestStarted",
+		Time:      time.Now(),
+		RequestId: request.RequestId,
+		Method:    request.Method,
+		Path:      formatPath(request.Path),
+	})
+}
+
+func (o *jsonOutput) RequestData(request *TunneledRequest, data []byte, completed bool) {
+}
+
+func (o *jsonOutput) ResponseStarted(msg HttpResponseStart) {
+	o.tracker.ResponseStarted(msg)
+}
+
+func (o *jsonOutput) ResponseData(msg HttpResponseData) {
+	if stats, ok := o.tracker.ResponseData(msg); ok {
+		duration := stats.duration.Milliseconds()
+
+		console.WriteJSON(jsonEvent{
+			Event:      "response",
+			Time:       time.Now(),
+			RequestId:  msg.Request.RequestId,
+			Method:     msg.Request.Method,
+			Path:       formatPath(msg.Request.Path),
+			Status:     stats.status,
+			DurationMs: &duration,
+			Size:       &stats.size,
+		})
+	}
+}
+
+func (o *jsonOutput) RequestFailed(request *TunneledRequest, err error) {
+	o.tracker.Failed(request)
+
+	console.WriteJSON(jsonEvent{
+		Event:     "error",
+		Time:      time.Now(),
+		RequestId: request.RequestId,
+		Method:    request.Method,
+		Path:      formatPath(request.Path),
+		Error:     err.Error(),
+		Aborted:   errors.Is(err, ErrAborted),
+	})
+}
+
+func (o *jsonOutput) Notice(format string, a ...any) {
+	console.Logf(format+"\n", a...)
+}
+
+func (o *jsonOutput) Closed(err error, session *session) {
+	event := jsonEvent{Event: "disconnected", Time: time.Now()}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	summary := session.Summary()
+	event.Session = &summary
+
+	console.WriteJSON(event)
+}
+
+type requestStats struct {
+	duration time.Duration
+	size     int
+	status   int32
+}
+
+// Collects the status, the size and the duration of the requests until they have been completed.
+type requestTracker struct {
+	lock    sync.Mutex
+	sizes   map[string]int
+	started map[string]time.Time
+	status  map[string]int32
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{
+		sizes:   make(map[string]int),
+		started: make(map[string]time.Time),
+		status:  make(map[string]int32),
+	}
+}
+
+func (t *requestTracker) Started(request *TunneledRequest) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.started[request.RequestId] = time.Now()
+}
+
+func (t *requestTracker) ResponseStarted(msg HttpResponseStart) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.status[msg.Request.RequestId] = msg.Status
+}
+
+// ResponseData returns the stats once the response has been completed.
+func (t *requestTracker) ResponseData(msg HttpResponseData) (requestStats, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	id := msg.Request.RequestId
+	t.sizes[id] += len(msg.Data)
+
+	if !msg.Completed {
+		return requestStats{}, false
+	}
+
+	stats := requestStats{
+		duration: time.Since(t.started[id]),
+		size:     t.sizes[id],
+		status:   t.status[id],
+	}
+
+	t.forget(id)
+	return stats, true
+}
+
+func (t *requestTracker) Failed(request *TunneledRequest) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.forget(request.RequestId)
+}
+
+// There are no weak refs in golang, therefore remove the completed request.
+func (t *requestTracker) forget(id string) {
+	delete(t.sizes, id)
+	delete(t.started, id)
+	delete(t.status, id)
+}
+
+func printStatus(request *TunneledRequest, format string, a ...any) {
+	prefix := fmt.Sprintf(" - %s %s ",
+		formatCell(request.Method, 10),
+		formatCell(formatPath(request.Path), 30))
+
+	fmt.Println(prefix + fmt.Sprintf(format, a...))
+}
+
+func formatCell(source string, max int) string {
+	length := len(source)
+	if length > max {
+		return source[0:(max-3)] + "..."
+	} else {
+		for i := length; i < max; i++ {
+			source += " "
+		}
+
+		return source
+	}
+}
+
+func formatStatus(status int32) string {
+	return fmt.Sprintf("%d %s", status, http.StatusText(int(status)))
+}
+
+func formatDuration(duration time.Duration) string {
+	if duration < time.Second {
+		return fmt.Sprintf("%dms", duration.Milliseconds())
+	}
+
+	return fmt.Sprintf("%.2fs", duration.Seconds())
+}
+
+func formatSize(size int) string {
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%d B", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(size)/1024/1024)
+	}
+}
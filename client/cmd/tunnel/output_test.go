@@ -0,0 +1,126 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"wh/cli/console"
+)
+
+// Redirects stdout and stderr while the action runs and returns what has been written to them.
+func captureOutput(t *testing.T, action func()) (string, string) {
+	t.Helper()
+
+	read := func(target **os.File) func() string {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+
+		original := *target
+		*target = writer
+
+		result := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(reader)
+			result <- string(data)
+		}()
+
+		return func() string {
+			*target = original
+			_ = writer.Close()
+			return <-result
+		}
+	}
+
+	stdout := read(&os.Stdout)
+	stderr := read(&os.Stderr)
+
+	action()
+
+	return stdout(), stderr()
+}
+
+func TestJsonOutput(t *testing.T) {
+	if err := console.SetFormat(console.FormatJSON); err != nil {
+		t.Fatalf("failed to set format: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = console.SetFormat(console.FormatText)
+	})
+
+	completed := NewTunneledRequest("http://localhost:8080", "request-1", http.MethodPost, "/hooks", http.Header{}, nil)
+	aborted := NewTunneledRequest("http://localhost:8080", "request-2", http.MethodGet, "", http.Header{}, nil)
+
+	stdout, stderr := captureOutput(t, func() {
+		// Scripts get JSON, even when the full screen UI has been requested.
+		out := newOutput(true, func() {})
+
+		out.Subscribed("https://users.example.com", "http://localhost:8080")
+
+		out.RequestStarted(completed)
+		out.RequestData(completed, []byte("{}"), true)
+		out.ResponseStarted(HttpResponseStart{Request: completed, Headers: http.Header{}, Status: http.StatusCreated})
+		out.ResponseData(HttpResponseData{Request: completed, Data: []byte("ok")})
+		out.ResponseData(HttpResponseData{Request: completed, Data: []byte("!"), Completed: true})
+
+		out.RequestStarted(aborted)
+		out.Notice("Stopping the tunnel. Waiting up to %s for %d pending requests.", "1m0s", 1)
+		out.RequestFailed(aborted, ErrAborted)
+
+		session := newSession()
+		session.RequestStarted()
+		session.RequestFailed(true)
+
+		out.Closed(errors.New("connection reset"), session)
+	})
+
+	if stderr != "Stopping the tunnel. Waiting up to 1m0s for 1 pending requests.\n" {
+		t.Errorf("expected only the notice on stderr, got %q", stderr)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+
+	events := make([]jsonEvent, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &events[i]); err != nil {
+			t.Fatalf("expected a JSON document per line, got %q: %v", line, err)
+		}
+	}
+
+	expected := []string{"subscribed", "requestStarted", "response", "requestStarted", "error", "disconnected"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %q", len(expected), stdout)
+	}
+
+	for i, event := range events {
+		if event.Event != expected[i] || event.Time.IsZero() {
+			t.Errorf("expected the event %s with time, got %+v", expected[i], event)
+		}
+	}
+
+	if e := events[0]; e.PublicUrl != "https://users.example.com" || e.LocalUrl != "http://localhost:8080" {
+		t.Errorf("expected the urls, got %+v", e)
+	}
+
+	if e := events[1]; e.RequestId != "request-1" || e.Method != http.MethodPost || e.Path != "/hooks" {
+		t.Errorf("expected the started request, got %+v", e)
+	}
+
+	if e := events[2]; e.RequestId != "request-1" || e.Status != http.StatusCreated || e.Size == nil || *e.Size != 3 || e.DurationMs == nil {
+		t.Errorf("expected the response with status and size, got %+v", e)
+	}
+
+	if e := events[4]; e.RequestId != "request-2" || e.Path != "/" || !e.Aborted || e.Error != ErrAborted.Error() {
+		t.Errorf("expected the aborted request, got %+v", e)
+	}
+
+	if e := events[5]; e.Error != "connection reset" || e.Session == nil || e.Session.Requests != 1 || e.Session.Aborted != 1 {
+		t.Errorf("expected the error and the session, got %+v", e)
+	}
+}
@@ -2,49 +2,75 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TunneledRequest struct {
-	cancel          context.CancelFunc
+	aborted         error
+	cancel          context.CancelCauseFunc
+	captured        *Exchange
 	completed       bool
 	Headers         http.Header
+	lock            sync.Mutex
+	maxCaptureSize  int
 	Method          string
 	onError         []func(msg HttpError)
+	onRequestResume []func(msg HttpRequestResume)
 	onResponseData  []func(msg HttpResponseData)
 	onResponseStart []func(msg HttpResponseStart)
 	Path            string
 	requestBody     *requestReader
 	RequestId       string
+	TraceContext    map[string]string
 	Url             string
 }
 
-func NewTunneledRequest(localBase string, requestId string, method string, path string, headers http.Header) *TunneledRequest {
+func NewTunneledRequest(localBase string, requestId string, method string, path string, headers http.Header, traceContext map[string]string) *TunneledRequest {
 	request := &TunneledRequest{
 		Headers:         headers,
 		Method:          method,
 		onError:         make([]func(msg HttpError), 0, 1),
+		onRequestResume: make([]func(msg HttpRequestResume), 0, 1),
 		onResponseData:  make([]func(msg HttpResponseData), 0, 1),
 		onResponseStart: make([]func(msg HttpResponseStart), 0, 1),
 		Path:            path,
-		requestBody:     newRequestReader(),
 		RequestId:       requestId,
+		TraceContext:    traceContext,
 		Url:             combineUrl(localBase, path),
 	}
 
+	request.requestBody = newRequestReader(request.emitRequestResume)
+
 	return request
 }
 
-func (r *TunneledRequest) WriteRequestData(data []byte, completed bool) {
-	r.requestBody.AppendData(data, completed)
+// WriteRequestData buffers the chunk for the local server without waiting for it.
+// It returns true when the local server has fallen behind and the server has to pause the request body.
+func (r *TunneledRequest) WriteRequestData(data []byte, completed bool) bool {
+	r.captureRequestData(data, completed)
+	return r.requestBody.AppendData(data, completed)
 }
 
 func (t *TunneledRequest) OnError(action func(HttpError)) {
 	t.onError = append(t.onError, action)
 }
 
+func (t *TunneledRequest) OnRequestResume(action func(HttpRequestResume)) {
+	t.onRequestResume = append(t.onRequestResume, action)
+}
+
 func (t *TunneledRequest) OnResponseData(action func(HttpResponseData)) {
 	t.onResponseData = append(t.onResponseData, action)
 }
@@ -54,18 +80,65 @@ func (t *TunneledRequest) OnResponseStart(action func(HttpResponseStart)) {
 }
 
 func (r *TunneledRequest) Cancel() {
+	r.Abort(nil)
+}
+
+// Abort cancels the call to the local server and reports the reason to the server.
+func (r *TunneledRequest) Abort(reason error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if reason == nil {
+		reason = context.Canceled
+	}
+
+	// The request might be aborted before it has been started.
 	if r.cancel == nil {
+		r.aborted = reason
 		return
 	}
 
-	r.cancel()
+	r.cancel(reason)
 }
 
-func (r *TunneledRequest) Run(ctx context.Context, timeout time.Duration) {
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+func (r *TunneledRequest) Run(ctx context.Context, tracer trace.Tracer, timeout time.Duration) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
+	// Deletes the buffered body, the transport might still wait for it when the request has been aborted.
+	defer r.requestBody.Close()
+
+	r.lock.Lock()
 	r.cancel = cancel
+	if r.aborted != nil {
+		cancel(r.aborted)
+	}
+	r.lock.Unlock()
+
+	ctx, cancelTimeout := context.WithTimeout(ctx, timeout)
+	defer cancelTimeout()
+
+	// The query often contains tokens, therefore only the path is recorded. The name must not contain the path, because it has a high cardinality.
+	path, _, _ := strings.Cut(r.Path, "?")
+
+	// Continue the trace of the server.
+	ctx, span := tracer.Start(propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier(r.TraceContext)), "local "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("wh.request_id", r.RequestId),
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(path),
+		),
+	)
+	defer span.End()
+
+	r.OnError(func(msg HttpError) {
+		if msg.Error != nil {
+			span.SetStatus(codes.Error, msg.Error.Error())
+		} else if msg.Timeout {
+			span.SetStatus(codes.Error, context.DeadlineExceeded.Error())
+		}
+	})
 
 	request, err := http.NewRequestWithContext(ctx, r.Method, r.Url, r.requestBody)
 	if err != nil {
@@ -73,14 +146,26 @@ func (r *TunneledRequest) Run(ctx context.Context, timeout time.Duration) {
 		return
 	}
 
+	// Otherwise the body is sent in chunks, because the length of the buffered body is not known in advance.
+	if length, err := strconv.ParseInt(r.Headers.Get("Content-Length"), 10, 64); err == nil && length >= 0 {
+		request.ContentLength = length
+	}
+
+	// Let the local server join the trace.
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	started := time.Now()
+
 	response, err := http.DefaultClient.Do(request)
 	if err != nil {
-		r.emitError(err, false)
+		r.emitError(getAbortReason(ctx, err), false)
 		return
 	}
 
+	span.SetAttributes(semconv.HTTPResponseStatusCode(response.StatusCode))
+
 	if len(r.onResponseStart) > 0 {
-		msg := HttpResponseStart{Request: r, Headers: response.Header, Status: int32(response.StatusCode)}
+		msg := HttpResponseStart{Request: r, Headers: response.Header, Status: int32(response.StatusCode), Duration: time.Since(started)}
 		for _, r := range r.onResponseStart {
 			r(msg)
 		}
@@ -90,14 +175,18 @@ func (r *TunneledRequest) Run(ctx context.Context, timeout time.Duration) {
 	for {
 		select {
 		case <-ctx.Done():
-			r.emitError(nil, true)
+			if reason := getAbortReason(ctx, nil); reason != nil {
+				r.emitError(reason, false)
+			} else {
+				r.emitError(nil, true)
+			}
 			return
 
 		default:
 			buffer := make([]byte, 4*1024)
 			n, err := body.Read(buffer)
 			if err != nil && err != io.EOF {
-				r.emitError(err, false)
+				r.emitError(getAbortReason(ctx, err), false)
 				return
 			}
 
@@ -116,8 +205,19 @@ func (r *TunneledRequest) Run(ctx context.Context, timeout time.Duration) {
 	}
 }
 
+func (r *TunneledRequest) emitRequestResume() {
+	if len(r.onRequestResume) > 0 {
+		msg := HttpRequestResume{Request: r}
+		for _, r := range r.onRequestResume {
+			r(msg)
+		}
+	}
+}
+
+// Reports the error to the server, no matter whether the local server has already answered.
 func (r *TunneledRequest) emitError(err error, timeout bool) {
-	if !r.completed {
+	// Only report the first error, the request is terminated afterwards.
+	if r.completed {
 		return
 	}
 
@@ -130,3 +230,13 @@ func (r *TunneledRequest) emitError(err error, timeout bool) {
 		}
 	}
 }
+
+// Prefers the reason of an abort over the generic error of the canceled context.
+func getAbortReason(ctx context.Context, err error) error {
+	cause := context.Cause(ctx)
+	if cause == nil || errors.Is(cause, context.Canceled) || errors.Is(cause, context.DeadlineExceeded) {
+		return err
+	}
+
+	return cause
+}
@@ -1,58 +1,185 @@
 package tunnel
 
 import (
+	"bytes"
 	"io"
+	"os"
+	"sync"
 )
 
+const (
+	// Larger backlogs are written to a temporary file, so that a slow local server cannot exhaust the memory.
+	requestMemoryBufferSize = 1024 * 1024
+
+	// The server pauses the request body when the local server has fallen behind by this amount of data.
+	requestPauseThreshold = 8 * 1024 * 1024
+
+	// The server resumes the request body when the local server has caught up again.
+	requestResumeThreshold = 1024 * 1024
+)
+
+// Buffers the request body between the tunnel and the local server, because the tunnel must never wait for a single request.
 type requestReader struct {
-	curr   *requestChunk
-	cursor int
-	data   chan requestChunk
+	buffered    int
+	closed      bool
+	cond        *sync.Cond
+	done        bool
+	err         error
+	file        *os.File
+	fileRead    int64
+	fileWritten int64
+	lock        sync.Mutex
+	memory      bytes.Buffer
+	onResumed   func()
+	paused      bool
 }
 
-type requestChunk struct {
-	data []byte
-	done bool
-}
+func newRequestReader(onResumed func()) *requestReader {
+	r := &requestReader{onResumed: onResumed}
+	r.cond = sync.NewCond(&r.lock)
 
-func newRequestReader() *requestReader {
-	return &requestReader{data: make(chan requestChunk, 1)}
+	return r
 }
 
-func (r *requestReader) AppendData(data []byte, done bool) {
-	r.data <- requestChunk{data: data, done: done}
+// AppendData never blocks. It returns true when the server has to pause the body until the local server has caught up.
+func (r *requestReader) AppendData(data []byte, done bool) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	defer r.cond.Broadcast()
+
+	if r.closed {
+		return false
+	}
+
+	r.done = done
+
+	if len(data) == 0 || r.err != nil {
+		return false
+	}
+
+	// Keep the order of the chunks, once the data is spilled to the file it is read from there until the file is empty.
+	if r.fileWritten == r.fileRead && r.memory.Len()+len(data) <= requestMemoryBufferSize {
+		r.memory.Write(data)
+	} else if err := r.spill(data); err != nil {
+		r.err = err
+		return false
+	}
+
+	r.buffered += len(data)
+
+	if !r.paused && r.buffered >= requestPauseThreshold {
+		r.paused = true
+		return true
+	}
+
+	return false
 }
 
 func (r *requestReader) Read(p []byte) (n int, err error) {
-	if r.curr != nil {
-		return r.readNext(p)
-	}
+	r.lock.Lock()
 
 	// Just wait for the next chunk from the backend.
-	d := <-r.data
-	r.curr = &d
+	for r.buffered == 0 && !r.done && !r.closed && r.err == nil {
+		r.cond.Wait()
+	}
+
+	if r.closed {
+		r.lock.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+
+	if r.err != nil {
+		r.lock.Unlock()
+		return 0, r.err
+	}
+
+	if r.buffered == 0 {
+		r.lock.Unlock()
+		return 0, io.EOF
+	}
+
+	if r.memory.Len() > 0 {
+		n, _ = r.memory.Read(p)
+	} else {
+		n, err = r.readFile(p)
+	}
+
+	r.buffered -= n
+
+	resumed := r.paused && r.buffered <= requestResumeThreshold
+	if resumed {
+		r.paused = false
+	}
+
+	r.lock.Unlock()
+
+	// Notify outside of the lock, because the listener waits until the tunnel has taken the message.
+	if resumed && r.onResumed != nil {
+		r.onResumed()
+	}
 
-	return r.readNext(p)
+	return n, err
 }
 
-func (r *requestReader) readNext(p []byte) (n int, err error) {
-	data := r.curr.data
-	n = 0
-	if r.cursor < len(data) {
-		slice := r.curr.data[r.cursor:]
+// Close deletes the temporary file and releases a pending read.
+func (r *requestReader) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	r.cond.Broadcast()
+
+	r.memory = bytes.Buffer{}
 
-		n = copy(p, slice)
-		r.cursor += n
+	if r.file == nil {
+		return nil
 	}
 
-	err = nil
-	if r.cursor >= len(data) {
-		if r.curr.done {
-			err = io.EOF
+	_ = r.file.Close()
+	return os.Remove(r.file.Name())
+}
+
+func (r *requestReader) spill(data []byte) error {
+	if r.file == nil {
+		file, err := os.CreateTemp("", "wh-request-*")
+		if err != nil {
+			return err
 		}
 
-		r.curr = nil
-		r.cursor = 0
+		r.file = file
+	}
+
+	n, err := r.file.WriteAt(data, r.fileWritten)
+	r.fileWritten += int64(n)
+
+	return err
+}
+
+func (r *requestReader) readFile(p []byte) (int, error) {
+	if remaining := r.fileWritten - r.fileRead; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := r.file.ReadAt(p, r.fileRead)
+	r.fileRead += int64(n)
+
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+
+	// Reuse the file from the beginning, when the local server has caught up.
+	if r.fileRead == r.fileWritten {
+		r.fileRead = 0
+		r.fileWritten = 0
+
+		if truncateErr := r.file.Truncate(0); truncateErr != nil && err == nil {
+			err = truncateErr
+		}
 	}
 
 	return n, err
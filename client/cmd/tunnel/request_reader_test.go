@@ -0,0 +1,148 @@
+package tunnel
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+// Returns a chunk with a recognizable pattern, so that reordered data is detected.
+func newTestChunk(size int, seed byte) []byte {
+	result := make([]byte, size)
+	for i := range result {
+		result[i] = seed + byte(i%251)
+	}
+
+	return result
+}
+
+func readExactly(t *testing.T, r *requestReader, size int) []byte {
+	t.Helper()
+
+	result := make([]byte, size)
+	if _, err := io.ReadFull(r, result); err != nil {
+		t.Fatalf("failed to read %d bytes: %v", size, err)
+	}
+
+	return result
+}
+
+func TestRequestReaderSpillOrder(t *testing.T) {
+	r := newRequestReader(nil)
+	defer r.Close()
+
+	var expected bytes.Buffer
+	appendChunk := func(chunk []byte, done bool) {
+		r.AppendData(chunk, done)
+		expected.Write(chunk)
+	}
+
+	// The first chunk fits into memory, the second one is spilled to the file.
+	appendChunk(newTestChunk(requestMemoryBufferSize/2, 1), false)
+	appendChunk(newTestChunk(requestMemoryBufferSize, 2), false)
+
+	if r.file == nil || r.fileWritten == 0 {
+		t.Fatal("expected the second chunk to be written to the file")
+	}
+
+	// Small chunks must not overtake the data in the file, even though the memory has room again.
+	actual := bytes.NewBuffer(readExactly(t, r, requestMemoryBufferSize/2))
+	appendChunk(newTestChunk(10, 3), false)
+
+	if r.memory.Len() != 0 {
+		t.Errorf("expected the chunk to be appended to the file, got %d bytes in memory", r.memory.Len())
+	}
+
+	// The file is reused from the beginning once the local server has caught up.
+	actual.Write(readExactly(t, r, requestMemoryBufferSize+10))
+
+	if r.fileWritten != 0 || r.fileRead != 0 {
+		t.Errorf("expected the file to be reset, got %d written and %d read", r.fileWritten, r.fileRead)
+	}
+
+	appendChunk(newTestChunk(100, 4), false)
+	appendChunk(newTestChunk(2*requestMemoryBufferSize, 5), true)
+
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read the rest: %v", err)
+	}
+
+	actual.Write(rest)
+
+	if !bytes.Equal(actual.Bytes(), expected.Bytes()) {
+		t.Errorf("expected %d bytes in order, got %d different bytes", expected.Len(), actual.Len())
+	}
+}
+
+func TestRequestReaderCloseRemovesFile(t *testing.T) {
+	r := newRequestReader(nil)
+	r.AppendData(newTestChunk(2*requestMemoryBufferSize, 1), false)
+
+	if r.file == nil {
+		t.Fatal("expected the data to be spilled to the file")
+	}
+
+	name := r.file.Name()
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("expected the file to be removed, got %v", err)
+	}
+
+	// A pending read of the local server is released.
+	if _, err := r.Read(make([]byte, 10)); err != io.ErrClosedPipe {
+		t.Errorf("expected ErrClosedPipe, got %v", err)
+	}
+}
+
+func TestRequestReaderPauseAndResume(t *testing.T) {
+	resumed := 0
+	r := newRequestReader(func() {
+		resumed++
+	})
+	defer r.Close()
+
+	const chunkSize = 1024 * 1024
+
+	// The server is paused exactly once, when the threshold has been reached.
+	for i := 1; i <= 10; i++ {
+		paused := r.AppendData(newTestChunk(chunkSize, byte(i)), false)
+
+		if expected := i*chunkSize == requestPauseThreshold; paused != expected {
+			t.Fatalf("expected pause %v after %d MB, got %v", expected, i, paused)
+		}
+	}
+
+	// The server is resumed once, when the local server has caught up to the resume threshold.
+	readExactly(t, r, 10*chunkSize-requestResumeThreshold-1)
+	if resumed != 0 {
+		t.Fatalf("expected no resume above the threshold, got %d", resumed)
+	}
+
+	readExactly(t, r, 1)
+	if resumed != 1 {
+		t.Fatalf("expected a resume at the threshold, got %d", resumed)
+	}
+
+	readExactly(t, r, requestResumeThreshold/2)
+	if resumed != 1 {
+		t.Fatalf("expected a single resume, got %d", resumed)
+	}
+
+	// The body is paused again when the local server falls behind again.
+	pauses := 0
+	for i := 0; i < 10; i++ {
+		if r.AppendData(newTestChunk(chunkSize, byte(i)), false) {
+			pauses++
+		}
+	}
+
+	if pauses != 1 {
+		t.Errorf("expected a second pause, got %d", pauses)
+	}
+}
@@ -0,0 +1,184 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Runs the request against the local server and returns the reported errors.
+func runTestRequest(t *testing.T, url string, timeout time.Duration) []HttpError {
+	t.Helper()
+
+	request := NewTunneledRequest(url, "request-1", http.MethodGet, "/", http.Header{}, nil)
+
+	errs := make([]HttpError, 0)
+	request.OnError(func(msg HttpError) {
+		errs = append(errs, msg)
+	})
+
+	// The request has no body.
+	request.WriteRequestData(nil, true)
+	request.Run(context.Background(), noop.NewTracerProvider().Tracer(tracerName), timeout)
+
+	// Errors after the termination must not be reported.
+	request.emitError(errors.New("late"), false)
+
+	return errs
+}
+
+func TestRequestReportsLocalError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	errs := runTestRequest(t, url, time.Second)
+	if len(errs) != 1 || errs[0].Error == nil || errs[0].Error.Error() == "late" {
+		t.Errorf("expected the connection error once, got %v", errs)
+	}
+}
+
+func TestRequestReportsStalledResponse(t *testing.T) {
+	release := make(chan bool)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+
+	defer server.Close()
+	defer close(release)
+
+	errs := runTestRequest(t, server.URL, 100*time.Millisecond)
+	if len(errs) != 1 || !errors.Is(errs[0].Error, context.DeadlineExceeded) {
+		t.Errorf("expected the timeout once, got %v", errs)
+	}
+}
+
+func TestRequestReportsBrokenResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The local server crashes after a part of the announced body.
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+
+	defer server.Close()
+
+	errs := runTestRequest(t, server.URL, time.Second)
+	if len(errs) != 1 || !errors.Is(errs[0].Error, io.ErrUnexpectedEOF) || errs[0].Timeout {
+		t.Errorf("expected the broken response once, got %v", errs)
+	}
+}
+
+func TestRequestSlowLocalServerDoesNotBlockOthers(t *testing.T) {
+	release := make(chan bool)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The slow handler does not read the body until the end of the test.
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	defer server.Close()
+	defer close(release)
+
+	tracer := noop.NewTracerProvider().Tracer(tracerName)
+
+	slow := NewTunneledRequest(server.URL, "slow", http.MethodPost, "/slow", http.Header{}, nil)
+	fast := NewTunneledRequest(server.URL, "fast", http.MethodPost, "/fast", http.Header{}, nil)
+
+	completed := make(chan bool, 1)
+	fast.OnResponseData(func(msg HttpResponseData) {
+		if msg.Completed {
+			completed <- true
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go slow.Run(ctx, tracer, time.Minute)
+	go fast.Run(ctx, tracer, time.Minute)
+
+	// Like the tunnel, a single goroutine writes the chunks of both requests in the order of the server.
+	written := make(chan bool)
+	go func() {
+		defer close(written)
+
+		chunk := bytes.Repeat([]byte("x"), 64*1024)
+		for i := 0; i < 2*requestPauseThreshold/len(chunk); i++ {
+			slow.WriteRequestData(chunk, false)
+
+			if i == 10 {
+				fast.WriteRequestData([]byte("fast"), true)
+			}
+		}
+	}()
+
+	select {
+	case <-written:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the tunnel not to wait for the slow local server")
+	}
+
+	select {
+	case <-completed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the fast request to complete while the slow one is still pending")
+	}
+}
+
+func TestRequestAbortedBeforeRun(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	defer server.Close()
+
+	request := NewTunneledRequest(server.URL, "request-1", http.MethodGet, "/", http.Header{}, nil)
+
+	errs := make([]HttpError, 0)
+	request.OnError(func(msg HttpError) {
+		errs = append(errs, msg)
+	})
+
+	// The tunnel aborts the pending requests before the goroutine of this request has been started.
+	request.Abort(ErrAborted)
+	request.WriteRequestData(nil, true)
+	request.Run(context.Background(), noop.NewTracerProvider().Tracer(tracerName), time.Second)
+
+	if len(errs) != 1 || !errors.Is(errs[0].Error, ErrAborted) || errs[0].Timeout {
+		t.Errorf("expected the abort once, got %v", errs)
+	}
+
+	if called {
+		t.Error("expected the local server not to be called")
+	}
+}
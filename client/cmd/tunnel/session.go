@@ -0,0 +1,130 @@
+package tunnel
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Counts the requests that have been served during the lifetime of the tunnel.
+type session struct {
+	aborted  int
+	failed   int
+	lock     sync.Mutex
+	rejected int
+	started  time.Time
+	statuses [6]int
+	total    int
+}
+
+func newSession() *session {
+	return &session{started: time.Now()}
+}
+
+func (s *session) RequestStarted() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.total++
+}
+
+func (s *session) RequestRejected() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.rejected++
+}
+
+func (s *session) ResponseStarted(status int32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	class := int(status / 100)
+	if class < 1 || class > 5 {
+		class = 0
+	}
+
+	s.statuses[class]++
+}
+
+func (s *session) RequestFailed(aborted bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if aborted {
+		s.aborted++
+	} else {
+		s.failed++
+	}
+}
+
+type sessionSummary struct {
+	DurationMs int64          `json:"durationMs"`
+	Requests   int            `json:"requests"`
+	Statuses   map[string]int `json:"statuses"`
+	Failed     int            `json:"failed"`
+	Aborted    int            `json:"aborted"`
+	Rejected   int            `json:"rejected"`
+}
+
+// Summary returns the counters, the statuses are grouped by class, for example 2xx.
+func (s *session) Summary() sessionSummary {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	statuses := make(map[string]int)
+	for class := 1; class <= 5; class++ {
+		if s.statuses[class] > 0 {
+			statuses[fmt.Sprintf("%dxx", class)] = s.statuses[class]
+		}
+	}
+
+	if s.statuses[0] > 0 {
+		statuses["other"] = s.statuses[0]
+	}
+
+	return sessionSummary{
+		DurationMs: time.Since(s.started).Milliseconds(),
+		Requests:   s.total,
+		Statuses:   statuses,
+		Failed:     s.failed,
+		Aborted:    s.aborted,
+		Rejected:   s.rejected,
+	}
+}
+
+func (s *session) Print() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fmt.Println()
+	fmt.Println("Session")
+	fmt.Println("-------")
+	fmt.Println()
+	fmt.Printf("Duration:         %s\n", time.Since(s.started).Round(time.Second))
+	fmt.Printf("Requests:         %d\n", s.total)
+
+	for class := 1; class <= 5; class++ {
+		if s.statuses[class] > 0 {
+			fmt.Printf("  %dxx:            %d\n", class, s.statuses[class])
+		}
+	}
+
+	if s.statuses[0] > 0 {
+		fmt.Printf("  Other:          %d\n", s.statuses[0])
+	}
+
+	if s.failed > 0 {
+		fmt.Printf("  Failed:         %d\n", s.failed)
+	}
+
+	if s.aborted > 0 {
+		fmt.Printf("  Aborted:        %d\n", s.aborted)
+	}
+
+	if s.rejected > 0 {
+		fmt.Printf("Rejected:         %d\n", s.rejected)
+	}
+
+	fmt.Println()
+}
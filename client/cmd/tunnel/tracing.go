@@ -0,0 +1,35 @@
+package tunnel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "wh/cli"
+
+// Creates the tracer of the tunnel. Without an endpoint no spans are exported, but the trace context of the server is still forwarded to the local server.
+func newTracer(ctx context.Context, endpoint string) (trace.Tracer, func(context.Context) error, error) {
+	if endpoint == "" {
+		return noop.NewTracerProvider().Tracer(tracerName), func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("wh-cli"))),
+		// The server decides whether the request is sampled.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+
+	return provider.Tracer(tracerName), provider.Shutdown, nil
+}
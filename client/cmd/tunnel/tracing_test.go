@@ -0,0 +1,75 @@
+package tunnel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func TestRequestContinuesTrace(t *testing.T) {
+	const (
+		traceId      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		serverSpanId = "00f067aa0ba902b7"
+	)
+
+	traceparents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+	}))
+
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	traceContext := map[string]string{"traceparent": "00-" + traceId + "-" + serverSpanId + "-01"}
+	request := NewTunneledRequest(server.URL, "request-1", http.MethodPost, "/hook?token=secret", http.Header{}, traceContext)
+	request.WriteRequestData(nil, true)
+	request.Run(context.Background(), provider.Tracer(tracerName), time.Second)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+
+	span := spans[0]
+	if span.Name != "local POST" {
+		t.Errorf("expected the name without the path, got %s", span.Name)
+	}
+
+	if span.SpanContext.TraceID().String() != traceId || span.Parent.SpanID().String() != serverSpanId {
+		t.Errorf("expected the span to continue the trace of the server, got %s with parent %s", span.SpanContext.TraceID(), span.Parent.SpanID())
+	}
+
+	// The query can contain secrets, therefore neither the query nor the full URL is recorded.
+	for _, a := range span.Attributes {
+		if a.Key == semconv.URLFullKey || a.Key == semconv.URLQueryKey {
+			t.Errorf("expected no URL with query, got %v", a)
+		}
+	}
+
+	expected := []attribute.KeyValue{semconv.URLPath("/hook"), semconv.HTTPResponseStatusCode(http.StatusOK)}
+	for _, e := range expected {
+		found := false
+		for _, a := range span.Attributes {
+			found = found || a == e
+		}
+
+		if !found {
+			t.Errorf("expected attribute %v, got %v", e, span.Attributes)
+		}
+	}
+
+	// The local server joins the trace as child of the span of the client.
+	expectedParent := "00-" + traceId + "-" + span.SpanContext.SpanID().String() + "-01"
+	if actual := <-traceparents; actual != expectedParent {
+		t.Errorf("expected traceparent %s, got %s", expectedParent, actual)
+	}
+}
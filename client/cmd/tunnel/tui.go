@@ -0,0 +1,723 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wh/cli/console"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+const (
+	// The bodies are only kept for the detail pane, therefore large bodies are truncated.
+	tuiMaxBodySize = 1024 * 1024
+
+	// Old requests are removed from the list to limit the memory usage of long running tunnels.
+	tuiMaxEntries = 1000
+
+	// A replay that does not complete in time is shown as failed, like a stalled request.
+	tuiReplayTimeout = 1 * time.Minute
+)
+
+var (
+	tuiFaintStyle    = lipgloss.NewStyle().Faint(true)
+	tuiHeadingStyle  = lipgloss.NewStyle().Bold(true)
+	tuiSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	tuiErrorStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	tuiSuccessStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	tuiWarningStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("3"))
+)
+
+// Shows the requests in a full screen terminal UI.
+type tuiOutput struct {
+	done      chan bool
+	interrupt func()
+	program   *tea.Program
+}
+
+func newTuiOutput(interrupt func()) output {
+	return &tuiOutput{
+		done:      make(chan bool),
+		interrupt: interrupt,
+	}
+}
+
+func (o *tuiOutput) Subscribed(publicUrl string, localBase string) {
+	// The tunnel handles the signals, because it has to unsubscribe first.
+	o.program = tea.NewProgram(newTuiModel(publicUrl, localBase, o.interrupt), tea.WithAltScreen(), tea.WithoutSignalHandler())
+
+	go func() {
+		defer close(o.done)
+
+		if _, err := o.program.Run(); err != nil {
+			console.Logf("Error: %v\n", err)
+		}
+	}()
+}
+
+func (o *tuiOutput) RequestStarted(request *TunneledRequest) {
+	o.program.Send(tuiRequestStartedMsg{
+		headers: request.Headers,
+		id:      request.RequestId,
+		method:  request.Method,
+		path:    request.Path,
+		url:     request.Url,
+	})
+}
+
+func (o *tuiOutput) RequestData(request *TunneledRequest, data []byte, completed bool) {
+	o.program.Send(tuiRequestDataMsg{id: request.RequestId, data: data, completed: completed})
+}
+
+func (o *tuiOutput) ResponseStarted(msg HttpResponseStart) {
+	o.program.Send(tuiResponseStartedMsg{id: msg.Request.RequestId, headers: msg.Headers, status: msg.Status})
+}
+
+func (o *tuiOutput) ResponseData(msg HttpResponseData) {
+	o.program.Send(tuiResponseDataMsg{id: msg.Request.RequestId, data: msg.Data, completed: msg.Completed})
+}
+
+func (o *tuiOutput) RequestFailed(request *TunneledRequest, err error) {
+	o.program.Send(tuiRequestFailedMsg{id: request.RequestId, err: err})
+}
+
+func (o *tuiOutput) Notice(format string, a ...any) {
+	o.program.Send(tuiNoticeMsg(fmt.Sprintf(format, a...)))
+}
+
+func (o *tuiOutput) Closed(err error, session *session) {
+	o.program.Quit()
+	<-o.done
+
+	// The summary is printed to the normal screen, so that it is still visible afterwards.
+	newLineOutput().Closed(err, session)
+}
+
+type tuiRequestStartedMsg struct {
+	headers http.Header
+	id      string
+	method  string
+	path    string
+	url     string
+}
+
+type tuiRequestDataMsg struct {
+	completed bool
+	data      []byte
+	id        string
+}
+
+type tuiResponseStartedMsg struct {
+	headers http.Header
+	id      string
+	status  int32
+}
+
+type tuiResponseDataMsg struct {
+	completed bool
+	data      []byte
+	id        string
+}
+
+type tuiRequestFailedMsg struct {
+	err error
+	id  string
+}
+
+type tuiReplayedMsg struct {
+	entry *tuiEntry
+}
+
+type tuiNoticeMsg string
+
+type tuiEntry struct {
+	completed         bool
+	duration          time.Duration
+	err               error
+	id                string
+	method            string
+	path              string
+	replay            bool
+	requestBody       []byte
+	requestComplete   bool
+	requestHeaders    http.Header
+	requestTruncated  bool
+	responseBody      []byte
+	responseHeaders   http.Header
+	responseTruncated bool
+	size              int
+	started           time.Time
+	status            int32
+	url               string
+}
+
+type tuiModel struct {
+	detail        viewport.Model
+	detailFocused bool
+	entries       []*tuiEntry
+	filter        textinput.Model
+	height        int
+	ids           map[string]*tuiEntry
+	interrupt     func()
+	localBase     string
+	notice        string
+	offset        int
+	publicUrl     string
+	replays       int
+	selected      string
+	shown         *tuiEntry
+	width         int
+}
+
+func newTuiModel(publicUrl string, localBase string, interrupt func()) *tuiModel {
+	filter := textinput.New()
+	filter.Prompt = "Filter: "
+	filter.Placeholder = "method, path or status"
+
+	return &tuiModel{
+		detail:    viewport.New(0, 0),
+		filter:    filter,
+		ids:       make(map[string]*tuiEntry),
+		interrupt: interrupt,
+		localBase: localBase,
+		notice:    "Waiting for requests.",
+		publicUrl: publicUrl,
+	}
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	// Only render the detail pane again when the shown request has changed, bodies might be large.
+	changed := ""
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.shown = nil
+
+		_, detailHeight := m.layout()
+		m.detail.Width = m.width
+		m.detail.Height = detailHeight
+
+	case tea.KeyMsg:
+		cmd = m.handleKey(msg)
+
+	case tuiNoticeMsg:
+		m.notice = string(msg)
+
+	case tuiRequestStartedMsg:
+		m.add(&tuiEntry{
+			id:             msg.id,
+			method:         msg.method,
+			path:           msg.path,
+			requestHeaders: msg.headers,
+			started:        time.Now(),
+			url:            msg.url,
+		})
+
+	case tuiRequestDataMsg:
+		if e, ok := m.ids[msg.id]; ok {
+			e.requestBody, e.requestTruncated = appendCapture(e.requestBody, msg.data, e.requestTruncated, tuiMaxBodySize)
+			e.requestComplete = msg.completed
+
+			if msg.completed {
+				changed = msg.id
+			}
+		}
+
+	case tuiResponseStartedMsg:
+		if e, ok := m.ids[msg.id]; ok {
+			e.responseHeaders = msg.headers
+			e.status = msg.status
+			changed = msg.id
+		}
+
+	case tuiResponseDataMsg:
+		if e, ok := m.ids[msg.id]; ok {
+			e.responseBody, e.responseTruncated = appendCapture(e.responseBody, msg.data, e.responseTruncated, tuiMaxBodySize)
+			e.size += len(msg.data)
+
+			if msg.completed {
+				e.completed = true
+				e.duration = time.Since(e.started)
+				changed = msg.id
+			}
+		}
+
+	case tuiRequestFailedMsg:
+		if e, ok := m.ids[msg.id]; ok {
+			e.completed = true
+			e.duration = time.Since(e.started)
+			e.err = msg.err
+			changed = msg.id
+		}
+
+	case tuiReplayedMsg:
+		m.add(msg.entry)
+		m.selected = msg.entry.id
+		m.notice = fmt.Sprintf("Replayed %s %s.", msg.entry.method, formatPath(msg.entry.path))
+	}
+
+	m.refreshDetail(changed)
+
+	return m, cmd
+}
+
+func (m *tuiModel) handleKey(msg tea.KeyMsg) tea.Cmd {
+	if m.filter.Focused() {
+		switch msg.String() {
+		case "ctrl+c":
+			m.interrupt()
+		case "esc":
+			m.filter.Reset()
+			m.filter.Blur()
+		case "enter":
+			m.filter.Blur()
+		default:
+			var cmd tea.Cmd
+			m.filter, cmd = m.filter.Update(msg)
+			return cmd
+		}
+
+		return nil
+	}
+
+	switch msg.String() {
+	case "ctrl+c", "q":
+		// The first interrupt stops the tunnel gracefully, the second one aborts the pending requests.
+		m.interrupt()
+
+	case "/":
+		m.detailFocused = false
+		return m.filter.Focus()
+
+	case "tab":
+		m.detailFocused = !m.detailFocused
+
+	case "up", "k":
+		if m.detailFocused {
+			m.detail.LineUp(1)
+		} else {
+			m.move(-1)
+		}
+
+	case "down", "j":
+		if m.detailFocused {
+			m.detail.LineDown(1)
+		} else {
+			m.move(1)
+		}
+
+	case "pgup":
+		m.detail.HalfViewUp()
+
+	case "pgdown":
+		m.detail.HalfViewDown()
+
+	case "r":
+		if e := m.current(); e != nil {
+			if !e.requestComplete || e.requestTruncated {
+				m.notice = "The request cannot be replayed, because the body has not been received completely."
+				return nil
+			}
+
+			m.replays++
+			m.notice = fmt.Sprintf("Replaying %s %s.", e.method, formatPath(e.path))
+
+			return replay(e, fmt.Sprintf("replay-%d", m.replays))
+		}
+
+	case "c":
+		if e := m.current(); e != nil {
+			termenv.Copy(toCurl(e))
+			m.notice = "Copied the request as curl command to the clipboard."
+		}
+	}
+
+	return nil
+}
+
+func (m *tuiModel) View() string {
+	if m.width == 0 {
+		return ""
+	}
+
+	listHeight, _ := m.layout()
+
+	var b strings.Builder
+
+	b.WriteString(tuiHeadingStyle.Render("WEBHOOK TUNNEL"))
+	b.WriteString(tuiFaintStyle.Render(fmt.Sprintf("  %s -> %s, %d requests", m.publicUrl, m.localBase, len(m.entries))))
+	b.WriteString("\n")
+	b.WriteString(truncate(m.notice, m.width))
+	b.WriteString("\n")
+
+	pathWidth := max(m.width-58, 10)
+	b.WriteString(tuiHeadingStyle.Render(fmt.Sprintf("%s %s %s %s %s %s",
+		formatCell("Time", 8),
+		formatCell("Method", 8),
+		formatCell("Path", pathWidth),
+		formatCell("Status", 16),
+		formatCell("Duration", 10),
+		formatCell("Size", 10))))
+	b.WriteString("\n")
+
+	visible := m.visible()
+	current := m.current()
+
+	// Scroll the list, so that the selected request is always visible.
+	for i, e := range visible {
+		if e == current {
+			if i < m.offset {
+				m.offset = i
+			} else if i >= m.offset+listHeight {
+				m.offset = i - listHeight + 1
+			}
+		}
+	}
+
+	m.offset = min(m.offset, max(len(visible)-listHeight, 0))
+
+	for i := 0; i < listHeight; i++ {
+		if m.offset+i < len(visible) {
+			b.WriteString(m.renderRow(visible[m.offset+i], current, pathWidth))
+		}
+
+		b.WriteString("\n")
+	}
+
+	b.WriteString(tuiFaintStyle.Render(strings.Repeat("─", m.width)))
+	b.WriteString("\n")
+
+	b.WriteString(m.detail.View())
+	b.WriteString("\n")
+
+	if m.filter.Focused() || m.filter.Value() != "" {
+		b.WriteString(m.filter.View())
+	} else {
+		b.WriteString(tuiFaintStyle.Render("up/down select  tab scroll details  / filter  r replay  c copy as curl  q stop"))
+	}
+
+	return b.String()
+}
+
+func (m *tuiModel) renderRow(entry *tuiEntry, current *tuiEntry, pathWidth int) string {
+	started := entry.started.Format(time.TimeOnly)
+	if entry.replay {
+		started = "replay"
+	}
+
+	status, style := getEntryStatus(entry)
+
+	duration := ""
+	size := ""
+	if entry.completed {
+		duration = formatDuration(entry.duration)
+		size = formatSize(entry.size)
+	}
+
+	row := fmt.Sprintf("%s %s %s %s %s %s",
+		formatCell(started, 8),
+		formatCell(entry.method, 8),
+		formatCell(formatPath(entry.path), pathWidth),
+		formatCell(status, 16),
+		formatCell(duration, 10),
+		formatCell(size, 10))
+
+	if entry == current {
+		return tuiSelectedStyle.Render(row)
+	}
+
+	return style.Render(row)
+}
+
+// The list takes 40 percent of the lines that are not used for the header and the footer.
+func (m *tuiModel) layout() (int, int) {
+	available := max(m.height-5, 2)
+	listHeight := max(available*2/5, 1)
+
+	return listHeight, available - listHeight
+}
+
+func (m *tuiModel) add(entry *tuiEntry) {
+	m.entries = append(m.entries, entry)
+	m.ids[entry.id] = entry
+
+	if len(m.entries) > tuiMaxEntries {
+		delete(m.ids, m.entries[0].id)
+		m.entries = m.entries[1:]
+	}
+}
+
+// Returns the requests that match the filter, the latest request first.
+func (m *tuiModel) visible() []*tuiEntry {
+	filter := strings.ToLower(strings.TrimSpace(m.filter.Value()))
+
+	result := make([]*tuiEntry, 0, len(m.entries))
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		e := m.entries[i]
+		if filter == "" || strings.Contains(e.searchText(), filter) {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
+
+// Follows the latest request until the user selects a request.
+func (m *tuiModel) current() *tuiEntry {
+	visible := m.visible()
+	if len(visible) == 0 {
+		return nil
+	}
+
+	for _, e := range visible {
+		if e.id == m.selected {
+			return e
+		}
+	}
+
+	return visible[0]
+}
+
+func (m *tuiModel) move(delta int) {
+	visible := m.visible()
+	current := m.current()
+
+	for i, e := range visible {
+		if e == current {
+			next := min(max(i+delta, 0), len(visible)-1)
+			m.selected = visible[next].id
+			return
+		}
+	}
+}
+
+func (m *tuiModel) refreshDetail(changed string) {
+	current := m.current()
+	if current == m.shown && (current == nil || current.id != changed) {
+		return
+	}
+
+	if current == nil {
+		m.detail.SetContent("")
+	} else {
+		m.detail.SetContent(lipgloss.NewStyle().Width(max(m.width, 1)).Render(renderDetail(current)))
+	}
+
+	// Start at the top when another request is shown.
+	if current != m.shown {
+		m.detail.GotoTop()
+	}
+
+	m.shown = current
+}
+
+func (e *tuiEntry) searchText() string {
+	status, _ := getEntryStatus(e)
+	return strings.ToLower(e.method + " " + formatPath(e.path) + " " + status)
+}
+
+func getEntryStatus(entry *tuiEntry) (string, lipgloss.Style) {
+	switch {
+	case entry.err != nil && errors.Is(entry.err, ErrAborted):
+		return "Aborted", tuiErrorStyle
+	case entry.err != nil:
+		return "Error", tuiErrorStyle
+	case entry.status == 0:
+		return "Pending", tuiFaintStyle
+	case entry.status >= 500:
+		return formatStatus(entry.status), tuiErrorStyle
+	case entry.status >= 400:
+		return formatStatus(entry.status), tuiWarningStyle
+	default:
+		return formatStatus(entry.status), tuiSuccessStyle
+	}
+}
+
+func renderDetail(entry *tuiEntry) string {
+	var b strings.Builder
+
+	b.WriteString(tuiHeadingStyle.Render(entry.method + " " + entry.url))
+	b.WriteString("\n")
+
+	status, style := getEntryStatus(entry)
+	b.WriteString(style.Render(status))
+	if entry.completed {
+		b.WriteString(fmt.Sprintf(", %s, %s", formatDuration(entry.duration), formatSize(entry.size)))
+	}
+	b.WriteString("\n")
+
+	if entry.err != nil {
+		b.WriteString(tuiErrorStyle.Render(entry.err.Error()))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(tuiHeadingStyle.Render("Request"))
+	b.WriteString("\n")
+	b.WriteString(renderHeaders(entry.requestHeaders))
+	b.WriteString("\n")
+	b.WriteString(renderBody(entry.requestBody, entry.requestTruncated))
+	b.WriteString("\n")
+
+	if entry.responseHeaders != nil {
+		b.WriteString("\n")
+		b.WriteString(tuiHeadingStyle.Render("Response"))
+		b.WriteString("\n")
+		b.WriteString(renderHeaders(entry.responseHeaders))
+		b.WriteString("\n")
+		b.WriteString(renderBody(entry.responseBody, entry.responseTruncated))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func renderHeaders(headers http.Header) string {
+	var b strings.Builder
+	for _, name := range getSortedHeaders(headers) {
+		for _, value := range headers[name] {
+			b.WriteString(tuiFaintStyle.Render(name+":") + " " + value + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+func renderBody(body []byte, truncated bool) string {
+	if len(body) == 0 {
+		return tuiFaintStyle.Render("No body")
+	}
+
+	result := ""
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, body, "", "  "); err == nil {
+		result = pretty.String()
+	} else if utf8.Valid(body) {
+		result = string(body)
+	} else {
+		result = tuiFaintStyle.Render(fmt.Sprintf("%s of binary data", formatSize(len(body))))
+	}
+
+	if truncated {
+		result += "\n" + tuiWarningStyle.Render(fmt.Sprintf("The body has been truncated after %s.", formatSize(len(body))))
+	}
+
+	return result
+}
+
+// Sends the recorded request to the local server again. The response is shown as a new entry.
+func replay(source *tuiEntry, id string) tea.Cmd {
+	exchange := Exchange{
+		Method:          source.method,
+		Path:            source.path,
+		RequestBody:     source.requestBody,
+		RequestComplete: true,
+		RequestHeaders:  source.requestHeaders,
+		Url:             source.url,
+	}
+
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), tuiReplayTimeout)
+		defer cancel()
+
+		result := exchange.Replay(ctx, id, tuiMaxBodySize)
+
+		entry := &tuiEntry{
+			completed:         true,
+			duration:          result.Duration,
+			id:                id,
+			method:            result.Method,
+			path:              result.Path,
+			replay:            true,
+			requestBody:       result.RequestBody,
+			requestComplete:   true,
+			requestHeaders:    result.RequestHeaders,
+			responseBody:      result.ResponseBody,
+			responseHeaders:   result.ResponseHeaders,
+			responseTruncated: result.ResponseTruncated,
+			size:              result.Size,
+			started:           result.Started,
+			status:            result.Status,
+			url:               result.Url,
+		}
+
+		if result.Error != "" {
+			entry.err = errors.New(result.Error)
+		}
+
+		return tuiReplayedMsg{entry: entry}
+	}
+}
+
+func toCurl(entry *tuiEntry) string {
+	parts := []string{"curl", "-X", entry.method, quoteShell(entry.url)}
+
+	for _, name := range getSortedHeaders(entry.requestHeaders) {
+		// Curl calculates the length itself.
+		if http.CanonicalHeaderKey(name) == "Content-Length" {
+			continue
+		}
+
+		for _, value := range entry.requestHeaders[name] {
+			parts = append(parts, "-H", quoteShell(name+": "+value))
+		}
+	}
+
+	if len(entry.requestBody) > 0 {
+		parts = append(parts, "--data-binary", quoteShell(string(entry.requestBody)))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func quoteShell(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func getSortedHeaders(headers http.Header) []string {
+	result := make([]string, 0, len(headers))
+	for name := range headers {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// Both paths are technically not the same, but it looks weird.
+func formatPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+
+	return path
+}
+
+func truncate(source string, max int) string {
+	if len(source) <= max {
+		return source
+	}
+
+	return source[0:max]
+}
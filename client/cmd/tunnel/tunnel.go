@@ -2,15 +2,27 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 	"wh/cli/api"
 	"wh/cli/api/tunnel"
+	"wh/cli/console"
 
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrAborted The client has been stopped before the local server has answered the request.
+var ErrAborted = errors.New("the client has been stopped before the request has been completed")
+
+// ErrShuttingDown The client does not accept new requests, because it is shutting down.
+var ErrShuttingDown = errors.New("the client is shutting down")
+
 var TunnelCmd = &cobra.Command{
 	Use:   "tunnel <ENDPOINT> <LOCAL_URL>",
 	Short: "Creates a tunnel with and endpoint",
@@ -20,14 +32,91 @@ Simple tunnel
 	tunnel <endpoint> <local_server>
 
 for example:
-	tunnel users http://localhost:8080/users`,
-	Args: cobra.MatchAll(cobra.ExactArgs(2), cobra.OnlyValidArgs),
+	tunnel users http://localhost:8080/users
+
+Tunnel with an endpoint allocated by the server
+	tunnel --auto <local_server>
+
+Tunnel that only records the metadata of every tenth request
+	tunnel --record=metadata --sample=10 <endpoint> <local_server>
+
+Tunnel that exports the spans of the requests to an OpenTelemetry collector
+	tunnel --trace-endpoint=http://localhost:4318 <endpoint> <local_server>
+
+Tunnel with a full screen terminal UI to inspect, replay and filter the requests
+	tunnel --tui <endpoint> <local_server>
+
+Tunnel with a web UI on http://localhost:4040 to inspect and replay the requests
+	tunnel --inspect=:4040 <endpoint> <local_server>
+
+Press Ctrl+C to stop the tunnel. New requests are not accepted anymore, but pending requests
+are completed within the grace period. Press Ctrl+C again to abort them immediately.
+	tunnel --grace-period=30s <endpoint> <local_server>`,
+	Args: cobra.MatchAll(cobra.RangeArgs(1, 2), cobra.OnlyValidArgs),
 	Run: func(cmd *cobra.Command, args []string) {
-		endpoint := args[0]
+		auto, _ := cmd.Flags().GetBool("auto")
+		gracePeriod, _ := cmd.Flags().GetDuration("grace-period")
+		inspect, _ := cmd.Flags().GetString("inspect")
+		record, _ := cmd.Flags().GetString("record")
+		sample, _ := cmd.Flags().GetInt("sample")
+		traceEndpoint, _ := cmd.Flags().GetString("trace-endpoint")
+		tui, _ := cmd.Flags().GetBool("tui")
+
+		recording, err := parseRecording(record, sample)
+		if err != nil {
+			console.Logf("Error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		tracer, shutdownTracer, err := newTracer(cmd.Context(), traceEndpoint)
+		if err != nil {
+			console.Logf("Error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		defer func() {
+			// Export the spans of the last requests.
+			_ = shutdownTracer(context.Background())
+		}()
+
+		endpoint := ""
+		localBase := ""
+		if auto {
+			if len(args) != 1 {
+				console.Logf("Error: Only the local server is allowed when the endpoint is allocated by the server.\n")
+				os.Exit(1)
+				return
+			}
+
+			localBase = args[0]
+		} else {
+			if len(args) != 2 {
+				console.Logf("Error: Endpoint and local server are required.\n")
+				os.Exit(1)
+				return
+			}
+
+			endpoint = args[0]
+			localBase = args[1]
+		}
+
+		var requestInspector *inspector
+		if inspect != "" {
+			requestInspector, err = newInspector(inspect)
+			if err != nil {
+				console.Logf("Error: %v\n", err)
+				os.Exit(1)
+				return
+			}
+
+			defer requestInspector.Close()
+		}
 
 		client, ctx, err := api.GetClient()
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
+			console.Logf("Error: %v\n", err)
 			os.Exit(1)
 			return
 		}
@@ -42,7 +131,7 @@ for example:
 
 		stream, err := client.Service.Subscribe(ctx)
 		if err != nil {
-			fmt.Printf("Error: Failed to subscribe to stream. %v\n", err)
+			console.Logf("Error: Failed to subscribe to stream. %v\n", err)
 			os.Exit(1)
 			return
 		}
@@ -50,212 +139,393 @@ for example:
 		subscribeMessage := &tunnel.ClientMessage{
 			TestMessageType: &tunnel.ClientMessage_Subscribe{
 				Subscribe: &tunnel.SubscribeRequest{
-					Endpoint: &endpoint,
+					Endpoint:      &endpoint,
+					Auto:          &auto,
+					Recording:     recording,
+					ClientVersion: &api.Version,
 				},
 			},
 		}
 
 		err = stream.Send(subscribeMessage)
 		if err != nil {
-			fmt.Printf("Error: Failed to subscribe to server. Endpoint is probably already used. %v\n", err)
+			console.Logf("Error: Failed to subscribe to server. %v\n", err)
 			return
 		}
 
-		localBase := args[1]
-
-		fmt.Println()
-		fmt.Printf("WEBHOOK TUNNEL")
-		fmt.Println()
-		fmt.Println()
-		fmt.Printf("Forwarding from:  %s\n", combineUrl(client.Config.Endpoint, "endpoints", endpoint))
-		fmt.Printf("Forwarding to:    %s\n", localBase)
-		fmt.Println()
-		fmt.Println("HTTP Requests")
-		fmt.Println("-------------")
-		fmt.Println()
-
-		clientError := make(chan HttpError)
-		requestData := make(chan *tunnel.RequestData)
-		requestStart := make(chan *tunnel.RequestStart)
-		responseData := make(chan HttpResponseData)
-		responseStart := make(chan HttpResponseStart)
-		serverError := make(chan *tunnel.TransportError)
-		unregister := make(chan *TunneledRequest)
-
-		go func() {
-			// This map is only used in this goroutine, therefore we don't have to send updates.
-			requests := make(map[string]*TunneledRequest)
-
-			for {
-				select {
-				case msg := <-requestStart:
-					request := NewTunneledRequest(localBase,
-						msg.GetRequestId(),
-						msg.GetMethod(),
-						msg.GetPath(),
-						fromHeaders(msg.GetHeaders()))
-
-					printStatus(request, "Started")
-
-					request.OnResponseStart(func(msg HttpResponseStart) {
-						responseStart <- msg
-					})
-
-					request.OnResponseData(func(msg HttpResponseData) {
-						responseData <- msg
-					})
-
-					request.OnError(func(msg HttpError) {
-						clientError <- msg
-					})
-
-					// Register the request immediately, because the actual consecutive request might arrive immediately.
-					requests[request.RequestId] = request
-
-					// Run the request in parallel to other requests.
-					go func() {
-						defer func() {
-							unregister <- request
-						}()
-
-						request.Run(ctx, 1*time.Hour)
-					}()
+		// The server always confirms the subscription with the first message.
+		confirmation, err := stream.Recv()
+		if err != nil || confirmation.GetSubscribed() == nil {
+			console.Logf("Error: Failed to subscribe to server. Endpoint is probably already used or reserved. %v\n", err)
+			return
+		}
 
-				case msg := <-unregister:
-					// There are no weak refs in golang, therefore remove the completed request.
-					delete(requests, msg.RequestId)
+		endpoint = confirmation.GetSubscribed().GetEndpoint()
 
-				case msg := <-requestData:
-					t, ok := requests[msg.GetRequestId()]
-					if !ok {
-						break
-					}
+		publicUrl := combineUrl(client.Config.Endpoint, "endpoints", endpoint)
+		if host := confirmation.GetSubscribed().GetHost(); host != "" {
+			publicUrl = replaceHost(client.Config.Endpoint, host)
+		}
 
-					t.WriteRequestData(msg.GetData(), msg.GetCompleted())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
 
-				case msg := <-responseStart:
-					t, ok := requests[msg.Request.RequestId]
-					if !ok {
-						break
-					}
+		// The full screen UI reads the keys itself, therefore Ctrl+C does not raise a signal.
+		out := newOutput(tui, func() {
+			select {
+			case signals <- os.Interrupt:
+			default:
+			}
+		})
 
-					m := &tunnel.ClientMessage{
-						TestMessageType: &tunnel.ClientMessage_ResponseStart{
-							ResponseStart: &tunnel.ResponseStart{
-								RequestId: &t.RequestId,
-								Headers:   toHeaders(msg.Headers),
-								Status:    &msg.Status,
-							},
-						},
-					}
+		out.Subscribed(publicUrl, localBase)
 
-					if err := stream.Send(m); err != nil {
-						printStatus(t, "Error: Failed to send request to server. %v", err)
-					}
+		if requestInspector != nil {
+			out.Notice("Inspecting requests at %s", requestInspector.Url())
+		}
 
-				case msg := <-responseData:
-					t, ok := requests[msg.Request.RequestId]
-					if !ok {
-						break
-					}
+		serve(ctx, stream, signals, out, tunnelOptions{
+			gracePeriod: gracePeriod,
+			inspector:   requestInspector,
+			localBase:   localBase,
+			tracer:      tracer,
+		})
+	},
+}
 
-					m := &tunnel.ClientMessage{
-						TestMessageType: &tunnel.ClientMessage_ResponseData{
-							ResponseData: &tunnel.ResponseData{
-								RequestId: &t.RequestId,
-								Data:      msg.Data,
-								Completed: &msg.Completed,
-							},
-						},
-					}
+type tunnelOptions struct {
+	// How long pending requests may take to complete when the tunnel is stopped.
+	gracePeriod time.Duration
+	inspector   *inspector
+	localBase   string
+	tracer      trace.Tracer
+}
 
-					if err := stream.Send(m); err != nil {
-						printStatus(t, "Error: Failed to send request to server. %v", err)
-					}
+// Forwards the requests of the server to the local server until the stream is closed and returns the counters of the session.
+func serve(ctx context.Context, stream tunnel.WebhookService_SubscribeClient, signals chan os.Signal, out output, options tunnelOptions) *session {
+	clientError := make(chan HttpError)
+	requestData := make(chan *tunnel.RequestData)
+	requestResume := make(chan HttpRequestResume)
+	requestStart := make(chan *tunnel.RequestStart)
+	responseData := make(chan HttpResponseData)
+	responseStart := make(chan HttpResponseStart)
+	serverError := make(chan *tunnel.TransportError)
+	unregister := make(chan *TunneledRequest)
 
-				case msg := <-clientError:
-					t, ok := requests[msg.Request.RequestId]
-					if !ok {
-						break
-					}
+	// Closed before the client closes its side of the stream, so that the end of the stream is not reported as an error.
+	closing := make(chan bool)
+
+	session := newSession()
+
+	go func() {
+		// This map is only used in this goroutine, therefore we don't have to send updates.
+		requests := make(map[string]*TunneledRequest)
+
+		// The timer is only set when the client is shutting down, a nil channel blocks forever.
+		var gracePeriodElapsed <-chan time.Time
+		shuttingDown := false
+
+		// The server completes the stream when the client does not send messages anymore.
+		finish := sync.OnceFunc(func() {
+			close(closing)
+
+			if err := stream.CloseSend(); err != nil {
+				out.Notice("Error: Failed to close the tunnel. %v", err)
+			}
+		})
+
+		abort := func() {
+			for _, t := range requests {
+				t.Abort(ErrAborted)
+			}
+		}
+
+		for {
+			select {
+			case <-signals:
+				if shuttingDown {
+					out.Notice("Aborting %d pending requests.", len(requests))
+					abort()
+					break
+				}
+
+				shuttingDown = true
+
+				m := &tunnel.ClientMessage{
+					TestMessageType: &tunnel.ClientMessage_Unsubscribe{
+						Unsubscribe: &tunnel.UnsubscribeRequest{},
+					},
+				}
+
+				if err := stream.Send(m); err != nil {
+					out.Notice("Error: Failed to unsubscribe from server. %v", err)
+				}
+
+				if len(requests) == 0 {
+					out.Notice("Stopping the tunnel.")
+					finish()
+					break
+				}
+
+				out.Notice("Stopping the tunnel. Waiting up to %s for %d pending requests, press Ctrl+C again to abort them.", options.gracePeriod, len(requests))
+				gracePeriodElapsed = time.After(options.gracePeriod)
+
+			case <-gracePeriodElapsed:
+				out.Notice("Grace period elapsed. Aborting %d pending requests.", len(requests))
+				abort()
+
+			case msg := <-requestStart:
+				request := NewTunneledRequest(options.localBase,
+					msg.GetRequestId(),
+					msg.GetMethod(),
+					msg.GetPath(),
+					fromHeaders(msg.GetHeaders()),
+					msg.GetTraceContext())
+
+				// The server might have sent the request before it has received the unsubscription.
+				if shuttingDown {
+					out.RequestFailed(request, ErrShuttingDown)
+					session.RequestRejected()
 
 					m := &tunnel.ClientMessage{
 						TestMessageType: &tunnel.ClientMessage_Error{
 							Error: &tunnel.TransportError{
-								RequestId: &t.RequestId,
-								Error:     toError(msg.Error),
-								Timeout:   &msg.Timeout,
+								RequestId: &request.RequestId,
+								Error:     toError(ErrShuttingDown),
+								Timeout:   new(bool),
 							},
 						},
 					}
 
 					if err := stream.Send(m); err != nil {
-						printStatus(t, "Error: Failed with client error. %v", msg.Error)
+						out.Notice("Error: Failed to reject request. %v", err)
 					}
+					break
+				}
 
-				case msg := <-serverError:
-					t, ok := requests[msg.GetRequestId()]
-					if !ok {
-						break
-					}
+				out.RequestStarted(request)
+				session.RequestStarted()
 
-					t.cancel()
+				request.OnRequestResume(func(msg HttpRequestResume) {
+					requestResume <- msg
+				})
 
-					if msg.GetTimeout() {
-						printStatus(t, "Error: Failed with server timeout")
-					} else {
-						printStatus(t, "Error: Failed with server error. %s", msg.GetError())
-					}
+				request.OnResponseStart(func(msg HttpResponseStart) {
+					responseStart <- msg
+				})
+
+				request.OnResponseData(func(msg HttpResponseData) {
+					responseData <- msg
+				})
+
+				request.OnError(func(msg HttpError) {
+					clientError <- msg
+				})
+
+				if options.inspector != nil {
+					options.inspector.Add(request)
 				}
-			}
-		}()
 
-		for {
-			serverMessage, err := stream.Recv()
-			if err != nil {
-				fmt.Printf("Error: Connection closed by server: %v.\n", err)
-				return
-			}
+				// Register the request immediately, because the actual consecutive request might arrive immediately.
+				requests[request.RequestId] = request
 
-			if s := serverMessage.GetRequestStart(); s != nil {
-				requestStart <- s
-			}
+				// Run the request in parallel to other requests.
+				go func() {
+					defer func() {
+						unregister <- request
+					}()
+
+					request.Run(ctx, options.tracer, 1*time.Hour)
+				}()
+
+			case msg := <-unregister:
+				// There are no weak refs in golang, therefore remove the completed request.
+				delete(requests, msg.RequestId)
+
+				if shuttingDown && len(requests) == 0 {
+					finish()
+				}
 
-			if d := serverMessage.GetRequestData(); d != nil {
-				requestData <- d
+			case msg := <-requestData:
+				t, ok := requests[msg.GetRequestId()]
+				if !ok {
+					break
+				}
+
+				out.RequestData(t, msg.GetData(), msg.GetCompleted())
+
+				// The body is buffered, so that a slow local server does not stall the other requests of the tunnel.
+				if t.WriteRequestData(msg.GetData(), msg.GetCompleted()) {
+					sendFlowControl(stream, t, true, out)
+				}
+
+			case msg := <-requestResume:
+				if _, ok := requests[msg.Request.RequestId]; !ok {
+					break
+				}
+
+				sendFlowControl(stream, msg.Request, false, out)
+
+			case msg := <-responseStart:
+				t, ok := requests[msg.Request.RequestId]
+				if !ok {
+					break
+				}
+
+				session.ResponseStarted(msg.Status)
+				out.ResponseStarted(msg)
+
+				localDuration := msg.Duration.Microseconds()
+
+				m := &tunnel.ClientMessage{
+					TestMessageType: &tunnel.ClientMessage_ResponseStart{
+						ResponseStart: &tunnel.ResponseStart{
+							RequestId:     &t.RequestId,
+							Headers:       toHeaders(msg.Headers),
+							Status:        &msg.Status,
+							LocalDuration: &localDuration,
+						},
+					},
+				}
+
+				if err := stream.Send(m); err != nil {
+					out.RequestFailed(t, fmt.Errorf("Failed to send request to server. %v", err))
+				}
+
+			case msg := <-responseData:
+				t, ok := requests[msg.Request.RequestId]
+				if !ok {
+					break
+				}
+
+				m := &tunnel.ClientMessage{
+					TestMessageType: &tunnel.ClientMessage_ResponseData{
+						ResponseData: &tunnel.ResponseData{
+							RequestId: &t.RequestId,
+							Data:      msg.Data,
+							Completed: &msg.Completed,
+						},
+					},
+				}
+
+				if err := stream.Send(m); err != nil {
+					out.RequestFailed(t, fmt.Errorf("Failed to send request to server. %v", err))
+				} else {
+					out.ResponseData(msg)
+				}
+
+			case msg := <-clientError:
+				t, ok := requests[msg.Request.RequestId]
+				if !ok {
+					break
+				}
+
+				aborted := errors.Is(msg.Error, ErrAborted)
+				session.RequestFailed(aborted)
+
+				if aborted {
+					out.RequestFailed(t, msg.Error)
+				} else if msg.Timeout {
+					out.RequestFailed(t, fmt.Errorf("Failed with client timeout"))
+				} else {
+					out.RequestFailed(t, fmt.Errorf("Failed with client error. %v", msg.Error))
+				}
+
+				m := &tunnel.ClientMessage{
+					TestMessageType: &tunnel.ClientMessage_Error{
+						Error: &tunnel.TransportError{
+							RequestId: &t.RequestId,
+							Error:     toError(msg.Error),
+							Timeout:   &msg.Timeout,
+						},
+					},
+				}
+
+				if err := stream.Send(m); err != nil {
+					out.Notice("Error: Failed to send the error to the server. %v", err)
+				}
+
+			case msg := <-serverError:
+				t, ok := requests[msg.GetRequestId()]
+				if !ok {
+					break
+				}
+
+				// The server does not wait for the request anymore, therefore the error of the local call is not reported.
+				delete(requests, t.RequestId)
+
+				err := fmt.Errorf("Failed with server error. %s", msg.GetError())
+				if msg.GetTimeout() {
+					err = fmt.Errorf("Failed with server timeout")
+				}
+
+				t.Abort(err)
+
+				session.RequestFailed(false)
+				out.RequestFailed(t, err)
+
+				if shuttingDown && len(requests) == 0 {
+					finish()
+				}
 			}
+		}
+	}()
 
-			if e := serverMessage.GetError(); e != nil {
-				serverError <- e
+	for {
+		serverMessage, err := stream.Recv()
+		if err != nil {
+			select {
+			case <-closing:
+				out.Closed(nil, session)
+			default:
+				out.Closed(err, session)
 			}
+
+			return session
 		}
-	},
-}
 
-func printStatus(request *TunneledRequest, format string, a ...any) {
-	requestPath := request.Path
+		if s := serverMessage.GetRequestStart(); s != nil {
+			requestStart <- s
+		}
 
-	// Both paths are technically not the same, but it looks weird.
-	if requestPath == "" {
-		requestPath = "/"
-	}
+		if d := serverMessage.GetRequestData(); d != nil {
+			requestData <- d
+		}
 
-	prefix := fmt.Sprintf(" - %s %s ",
-		formatCell(request.Method, 10),
-		formatCell(requestPath, 30))
+		if e := serverMessage.GetError(); e != nil {
+			serverError <- e
+		}
 
-	fmt.Println(prefix + fmt.Sprintf(format, a...))
-}
+		if n := serverMessage.GetShutdown(); n != nil {
+			deadline := time.UnixMilli(n.GetDeadline())
 
-func formatCell(source string, max int) string {
-	length := len(source)
-	if length > max {
-		return source[0:(max-3)] + "..."
-	} else {
-		for i := length; i < max; i++ {
-			source += " "
+			out.Notice("The server is shutting down. Pending requests are terminated at %s, restart the tunnel afterwards.", deadline.Format(time.TimeOnly))
 		}
+	}
+}
 
-		return source
+func sendFlowControl(stream tunnel.WebhookService_SubscribeClient, request *TunneledRequest, paused bool, out output) {
+	m := &tunnel.ClientMessage{
+		TestMessageType: &tunnel.ClientMessage_FlowControl{
+			FlowControl: &tunnel.FlowControl{
+				RequestId: &request.RequestId,
+				Paused:    &paused,
+			},
+		},
 	}
+
+	if err := stream.Send(m); err != nil {
+		out.Notice("Error: Failed to send the flow control to the server. %v", err)
+	}
+}
+
+func init() {
+	TunnelCmd.Flags().BoolP("auto", "a", false, "Let the server allocate a random endpoint")
+	TunnelCmd.Flags().Duration("grace-period", 10*time.Second, "How long pending requests may take to complete when the tunnel is stopped")
+	TunnelCmd.Flags().String("inspect", "", "Serves a web UI to inspect and replay the requests on the address, for example :4040")
+	TunnelCmd.Flags().String("record", "", "Restricts what the server records: off, metadata, full or the maximum body size in bytes")
+	TunnelCmd.Flags().Int("sample", 0, "Let the server record only one of N requests")
+	TunnelCmd.Flags().String("trace-endpoint", "", "Exports the spans of the tunneled requests to an OTLP/HTTP collector, for example http://localhost:4318")
+	TunnelCmd.Flags().Bool("tui", false, "Shows the requests in a full screen terminal UI, when the client runs in a terminal")
 }
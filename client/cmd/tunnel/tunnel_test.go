@@ -0,0 +1,172 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+	"wh/cli/api/tunnel"
+
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+)
+
+// Replaces the stream of the server, the client closes its side with CloseSend.
+type testStream struct {
+	grpc.ClientStream
+
+	closed   chan bool
+	received chan *tunnel.ServerMessage
+	sent     chan *tunnel.ClientMessage
+}
+
+func newTestStream() *testStream {
+	return &testStream{
+		closed:   make(chan bool),
+		received: make(chan *tunnel.ServerMessage, 10),
+		sent:     make(chan *tunnel.ClientMessage, 10),
+	}
+}
+
+func (s *testStream) Send(m *tunnel.ClientMessage) error {
+	s.sent <- m
+	return nil
+}
+
+func (s *testStream) Recv() (*tunnel.ServerMessage, error) {
+	select {
+	case m := <-s.received:
+		return m, nil
+	case <-s.closed:
+		return nil, io.EOF
+	}
+}
+
+func (s *testStream) CloseSend() error {
+	close(s.closed)
+	return nil
+}
+
+// Forwards a request without a body to the client.
+func (s *testStream) startRequest(requestId string) {
+	endpoint := "users"
+	method := http.MethodPost
+	path := "/"
+	completed := true
+
+	s.received <- &tunnel.ServerMessage{
+		TestMessageType: &tunnel.ServerMessage_RequestStart{
+			RequestStart: &tunnel.RequestStart{RequestId: &requestId, Endpoint: &endpoint, Method: &method, Path: &path},
+		},
+	}
+
+	s.received <- &tunnel.ServerMessage{
+		TestMessageType: &tunnel.ServerMessage_RequestData{
+			RequestData: &tunnel.RequestData{RequestId: &requestId, Completed: &completed},
+		},
+	}
+}
+
+func (s *testStream) next(t *testing.T) *tunnel.ClientMessage {
+	t.Helper()
+
+	select {
+	case m := <-s.sent:
+		return m
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected a message of the client")
+		return nil
+	}
+}
+
+// Ignores the progress, the tests check the messages and the session.
+type testOutput struct{}
+
+func (o *testOutput) Subscribed(publicUrl string, localBase string)                     {}
+func (o *testOutput) RequestStarted(request *TunneledRequest)                           {}
+func (o *testOutput) RequestData(request *TunneledRequest, data []byte, completed bool) {}
+func (o *testOutput) ResponseStarted(msg HttpResponseStart)                             {}
+func (o *testOutput) ResponseData(msg HttpResponseData)                                 {}
+func (o *testOutput) RequestFailed(request *TunneledRequest, err error)                 {}
+func (o *testOutput) Notice(format string, a ...any)                                    {}
+func (o *testOutput) Closed(err error, session *session)                                {}
+
+func TestTunnelAbortsPendingRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		gracePeriod time.Duration
+		interrupts  int
+	}{
+		{name: "second interrupt", gracePeriod: time.Hour, interrupts: 2},
+		{name: "grace period elapsed", gracePeriod: 100 * time.Millisecond, interrupts: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			release := make(chan bool)
+			started := make(chan bool, 1)
+
+			// The local server does not answer until the request has been aborted.
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				started <- true
+
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+			}))
+
+			defer server.Close()
+			defer close(release)
+
+			stream := newTestStream()
+			signals := make(chan os.Signal, 1)
+
+			summary := make(chan sessionSummary, 1)
+			go func() {
+				options := tunnelOptions{
+					gracePeriod: test.gracePeriod,
+					localBase:   server.URL,
+					tracer:      noop.NewTracerProvider().Tracer(tracerName),
+				}
+
+				summary <- serve(context.Background(), stream, signals, &testOutput{}, options).Summary()
+			}()
+
+			stream.startRequest("request-1")
+
+			select {
+			case <-started:
+			case <-time.After(10 * time.Second):
+				t.Fatal("expected the request to reach the local server")
+			}
+
+			signals <- os.Interrupt
+			if m := stream.next(t); m.GetUnsubscribe() == nil {
+				t.Fatalf("expected the unsubscription, got %v", m)
+			}
+
+			for i := 1; i < test.interrupts; i++ {
+				signals <- os.Interrupt
+			}
+
+			e := stream.next(t).GetError()
+			if e == nil || e.GetRequestId() != "request-1" || e.GetError() != ErrAborted.Error() || e.GetTimeout() {
+				t.Errorf("expected the abort to be sent to the server, got %v", e)
+			}
+
+			// The tunnel is closed once the last request has been aborted.
+			select {
+			case s := <-summary:
+				if s.Requests != 1 || s.Aborted != 1 || s.Failed != 0 {
+					t.Errorf("expected the aborted request, got %+v", s)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("expected the tunnel to be closed")
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package tunnel
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"wh/cli/api/tunnel"
 )
@@ -17,6 +20,22 @@ func combineUrl(baseUrl string, paths ...string) string {
 	return url
 }
 
+func replaceHost(baseUrl string, host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	parsed, err := url.Parse(baseUrl)
+	if err != nil {
+		return "https://" + host
+	}
+
+	// Keep the port, because the server is the same, just the host name is different.
+	if port := parsed.Port(); port != "" {
+		host += ":" + port
+	}
+
+	return parsed.Scheme + "://" + host
+}
+
 func toHeaders(headers http.Header) map[string]*tunnel.HttpHeaderValues {
 	result := make(map[string]*tunnel.HttpHeaderValues, len(headers))
 	for header, v := range headers {
@@ -43,3 +62,35 @@ func toError(err error) *string {
 
 	return &result
 }
+
+// Parses the recording flags. The policy is nil when the server should use its own policy.
+func parseRecording(record string, sample int) (*tunnel.RecordingPolicy, error) {
+	if record == "" && sample == 0 {
+		return nil, nil
+	}
+
+	if sample < 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", sample)
+	}
+
+	mode := strings.ToLower(record)
+	maxBodySize := int64(0)
+	sampleRate := int32(sample)
+
+	switch mode {
+	case "", "full":
+		mode = "full"
+	case "metadata", "off":
+	default:
+		// A number records the bodies up to the given size.
+		size, err := strconv.ParseInt(record, 10, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid recording mode '%s', use off, metadata, full or the maximum body size in bytes", record)
+		}
+
+		mode = "full"
+		maxBodySize = size
+	}
+
+	return &tunnel.RecordingPolicy{Mode: &mode, MaxBodySize: &maxBodySize, SampleRate: &sampleRate}, nil
+}
@@ -0,0 +1,27 @@
+package tunnel
+
+import "testing"
+
+func TestReplaceHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseUrl  string
+		host     string
+		expected string
+	}{
+		{name: "host", baseUrl: "https://example.com", host: "users.example.com", expected: "https://users.example.com"},
+		{name: "port", baseUrl: "http://localhost:8080", host: "users.localhost", expected: "http://users.localhost:8080"},
+		{name: "path", baseUrl: "https://example.com/webhooks/", host: "users.example.com", expected: "https://users.example.com"},
+		{name: "uppercase", baseUrl: "https://example.com", host: "Users.Example.com", expected: "https://users.example.com"},
+		{name: "fully qualified", baseUrl: "https://example.com", host: "users.example.com.", expected: "https://users.example.com"},
+		{name: "invalid url", baseUrl: "://example.com", host: "users.example.com", expected: "https://users.example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := replaceHost(test.baseUrl, test.host); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+type Format = string
+
+const (
+	// FormatText Prints human readable text to stdout.
+	FormatText Format = "text"
+
+	// FormatJSON Prints one JSON document per line to stdout and the log messages to stderr.
+	FormatJSON Format = "json"
+)
+
+var (
+	format = FormatText
+	lock   sync.Mutex
+)
+
+// SetFormat selects the format of the output for all commands.
+func SetFormat(value string) error {
+	if value != FormatText && value != FormatJSON {
+		return fmt.Errorf("invalid output format '%s', use %s or %s", value, FormatText, FormatJSON)
+	}
+
+	format = value
+	return nil
+}
+
+// IsJSON indicates whether the data has to be written as JSON.
+func IsJSON() bool {
+	return format == FormatJSON
+}
+
+// Logf prints a message for the user. The message is separated from the data when the output is JSON.
+func Logf(message string, a ...any) {
+	fmt.Fprintf(logs(), message, a...)
+}
+
+// WriteJSON writes the value as a single line to stdout, so that scripts can process the output line by line.
+func WriteJSON(value any) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := json.NewEncoder(os.Stdout).Encode(value); err != nil {
+		Logf("Error: Failed to convert to JSON. %v\n", err)
+	}
+}
+
+func logs() io.Writer {
+	if IsJSON() {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
@@ -0,0 +1,113 @@
+package console
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Redirects stdout and stderr while the action runs and returns what has been written to them.
+func captureOutput(t *testing.T, action func()) (string, string) {
+	t.Helper()
+
+	read := func(target **os.File) func() string {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+
+		original := *target
+		*target = writer
+
+		result := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(reader)
+			result <- string(data)
+		}()
+
+		return func() string {
+			*target = original
+			_ = writer.Close()
+			return <-result
+		}
+	}
+
+	stdout := read(&os.Stdout)
+	stderr := read(&os.Stderr)
+
+	action()
+
+	return stdout(), stderr()
+}
+
+func useFormat(t *testing.T, value string) {
+	if err := SetFormat(value); err != nil {
+		t.Fatalf("failed to set format: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = SetFormat(FormatText)
+	})
+}
+
+func TestSetFormat(t *testing.T) {
+	useFormat(t, FormatJSON)
+
+	if !IsJSON() {
+		t.Error("expected the JSON format")
+	}
+
+	if err := SetFormat("yaml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+
+	// The format is not changed by an invalid value.
+	if !IsJSON() {
+		t.Error("expected the JSON format to be kept")
+	}
+}
+
+func TestLogfWithText(t *testing.T) {
+	useFormat(t, FormatText)
+
+	stdout, stderr := captureOutput(t, func() {
+		Logf("Forwarding to %s\n", "localhost")
+	})
+
+	if stdout != "Forwarding to localhost\n" || stderr != "" {
+		t.Errorf("expected the message on stdout, got %q and %q", stdout, stderr)
+	}
+}
+
+func TestWriteJSONSeparatesLogs(t *testing.T) {
+	useFormat(t, FormatJSON)
+
+	stdout, stderr := captureOutput(t, func() {
+		WriteJSON(map[string]string{"event": "first", "text": "line\nbreak"})
+		Logf("Stopping the tunnel.\n")
+		WriteJSON(map[string]string{"event": "second"})
+	})
+
+	// The messages for the user must not break the processing of the data.
+	if stderr != "Stopping the tunnel.\n" {
+		t.Errorf("expected the message on stderr, got %q", stderr)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two lines, got %q", stdout)
+	}
+
+	for i, expected := range []string{"first", "second"} {
+		var document map[string]string
+		if err := json.Unmarshal([]byte(lines[i]), &document); err != nil {
+			t.Fatalf("expected a JSON document, got %q: %v", lines[i], err)
+		}
+
+		if document["event"] != expected {
+			t.Errorf("expected %s, got %s", expected, document["event"])
+		}
+	}
+}
@@ -12,9 +12,17 @@ import (
 	"wh/domain/areas/api"
 	"wh/domain/areas/auth"
 	"wh/domain/areas/home"
+	"wh/domain/areas/status"
 	"wh/domain/areas/tunnel"
 	generated "wh/domain/areas/tunnel/api/tunnel"
+	"wh/domain/encryption"
+	"wh/domain/health"
+	"wh/domain/ipfilter"
+	"wh/domain/metrics"
 	"wh/domain/publish"
+	"wh/domain/redaction"
+	"wh/domain/tracing"
+	"wh/domain/verification"
 	"wh/infrastructure/configuration"
 	"wh/infrastructure/log"
 	"wh/infrastructure/server"
@@ -23,6 +31,8 @@ import (
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -37,9 +47,22 @@ var (
 	config         *viper.Viper
 	handleApi      api.ApiHandler
 	handleHome     home.HomeHandler
+	handleStatus   status.StatusHandler
+	healthChecker  health.Health
+	healthServer   *grpchealth.Server
+	ipFilter       ipfilter.IPFilter
+	keyRotator     publish.KeyRotator
+	keyring        encryption.Keyring
+	limiter        publish.Limiter
 	logger         *zap.Logger
+	stats          metrics.Metrics
+	policies       publish.RecordingPolicies
 	publisher      publish.Publisher
+	redactor       redaction.Redactor
 	store          publish.Store
+	tracer         tracing.Tracing
+	tunnelServer   tunnel.TunnelServer
+	verifier       verification.Verifier
 )
 
 func main() {
@@ -58,21 +81,63 @@ func main() {
 		panic(fmt.Errorf("fatal error creating logger: %w", err))
 	}
 
-	store, err = publish.NewStore(config)
+	keyring, err = encryption.NewKeyring(config)
+	if err != nil {
+		panic(fmt.Errorf("fatal error creating keyring: %w", err))
+	}
+
+	store, err = publish.NewStore(config, keyring)
 	if err != nil {
 		panic(fmt.Errorf("fatal error creating store: %w", err))
 	}
 
+	buckets, err = publish.NewBuckets(config, keyring)
+	if err != nil {
+		panic(fmt.Errorf("fatal error creating buckets: %w", err))
+	}
+
+	policies, err = publish.NewRecordingPolicies(store, config)
+	if err != nil {
+		panic(fmt.Errorf("fatal error creating recording policies: %w", err))
+	}
+
+	redactor, err = redaction.NewRedactor(config)
+	if err != nil {
+		panic(fmt.Errorf("fatal error creating redactor: %w", err))
+	}
+
+	ipFilter, err = ipfilter.NewIPFilter(config)
+	if err != nil {
+		panic(fmt.Errorf("fatal error creating IP filter: %w", err))
+	}
+
+	verifier, err = verification.NewVerifier(config)
+	if err != nil {
+		panic(fmt.Errorf("fatal error creating verifier: %w", err))
+	}
+
+	tracer, err = tracing.NewTracing(config)
+	if err != nil {
+		panic(fmt.Errorf("fatal error creating tracing: %w", err))
+	}
+
 	defer func(log *zap.Logger) {
 		_ = log.Sync()
 	}(logger)
 
-	buckets = publish.NewFileBucket(config)
-	publisher = publish.NewPublisher(store, buckets, logger)
+	keyRotator = publish.NewKeyRotator(store, buckets, keyring, config, logger)
+	keyRotator.Start()
+
+	stats = metrics.NewMetrics()
+	limiter = publish.NewLimiter(config)
+	publisher = publish.NewPublisher(store, buckets, limiter, policies, redactor, stats, tracer, config, logger)
 	authenticator = auth.NewAuthenticator(config)
 	authMiddleware = auth.NewAuthMiddleware(authenticator, logger)
-	handleHome = home.NewHomeHandler(store, buckets, authenticator, logger)
-	handleApi = api.NewApiHandler(publisher, config, logger)
+	handleHome = home.NewHomeHandler(store, buckets, policies, publisher, authenticator, logger)
+	handleApi = api.NewApiHandler(publisher, verifier, ipFilter, stats, tracer, config, logger)
+	healthServer = grpchealth.NewServer()
+	healthChecker = health.NewHealth(store, buckets, healthServer, config)
+	handleStatus = status.NewStatusHandler(healthChecker, publisher, logger)
 
 	// Create a grpc server, but do not start it yet, because it is handled by the mux.
 	grpcServer := initGrpc()
@@ -112,9 +177,46 @@ func main() {
 		panic(fmt.Errorf("fatal error waiting for application stop:  %w", err))
 	}
 
+	shutdownTimeout := config.GetDuration("shutdown.timeout")
+	deadline := time.Now().Add(shutdownTimeout)
+
+	logger.Info("Shutting down the server.",
+		zap.Duration("timeout", shutdownTimeout),
+	)
+
+	// Answer new webhooks with 503 and let the readiness probe fail, so that no new tunnels are routed to this instance.
+	publisher.Shutdown()
+	healthServer.Shutdown()
+
+	// Tell the clients, so that they can reconnect to another instance.
+	tunnelServer.Shutdown(deadline)
+
+	// Wait for the pending requests, which are recorded when they are completed.
+	drainCtx, cancelDrain := context.WithDeadline(context.Background(), deadline)
+	defer cancelDrain()
+
+	if err := publisher.Drain(drainCtx); err != nil {
+		logger.Warn("Pending requests have been terminated.",
+			zap.Error(err),
+		)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	_ = http1Server.Shutdown(ctx)
+
+	// Export the spans of the last requests.
+	_ = tracer.Shutdown(ctx)
+
+	// The key rotation must not touch the store after it has been closed.
+	keyRotator.Stop()
+
+	// Close the store at the end, because everything else might still record data.
+	if err := store.Close(); err != nil {
+		logger.Error("Could not close the store.",
+			zap.Error(err),
+		)
+	}
 }
 
 func startHttp() *echo.Echo {
@@ -125,6 +227,7 @@ func startHttp() *echo.Echo {
 	e.Use(server.PassThroughContext())
 	e.Use(server.Localize())
 	e.Use(server.Logger(logger))
+	e.Use(handleApi.Subdomain)
 	e.Use(server.LiveReload())
 	e.Static("/public", "./public")
 	e.HTTPErrorHandler = handleHome.ErrorHandler
@@ -134,18 +237,34 @@ func startHttp() *echo.Echo {
 	e.GET("/buckets/:id/request", handleHome.RequestBlob, authMiddleware.MustBeAuthenticated)
 	e.GET("/buckets/:id/response", handleHome.ResponseBlob, authMiddleware.MustBeAuthenticated)
 	e.GET("/internal", handleHome.GetInternal, authMiddleware.MustBeAuthenticated)
+	e.GET("/internal/recording", handleHome.GetRecording, authMiddleware.MustBeAuthenticated, authMiddleware.MustHaveCsrfToken)
+	e.POST("/internal/recording", handleHome.PostRecording, authMiddleware.MustBeAuthenticated, authMiddleware.MustHaveCsrfToken)
+	e.GET("/internal/tunnels", handleHome.GetTunnels, authMiddleware.MustBeAuthenticated, authMiddleware.MustHaveCsrfToken)
+	e.POST("/internal/tunnels", handleHome.PostTunnels, authMiddleware.MustBeAuthenticated, authMiddleware.MustHaveCsrfToken)
 	e.GET("/error", handleHome.GetError)
 	e.GET("/events", handleHome.GetEvents, authMiddleware.MustBeAuthenticated)
+	e.GET("/healthz", handleStatus.GetHealth)
+	e.GET("/readyz", handleStatus.GetReady)
+	e.GET("/status", handleStatus.GetStatus, authMiddleware.MustHaveApiKey)
 	e.Any("/endpoints/*", handleApi.Index)
 
+	if config.GetBool("metrics.enabled") {
+		if config.GetBool("metrics.requireAuth") {
+			e.GET("/metrics", echo.WrapHandler(stats.Handler()), authMiddleware.MustHaveApiKey)
+		} else {
+			e.GET("/metrics", echo.WrapHandler(stats.Handler()))
+		}
+	}
+
 	return e
 }
 
 func initGrpc() *grpc.Server {
 	serverG := grpc.NewServer()
-	service := tunnel.NewTunnelServer(publisher, logger)
+	tunnelServer = tunnel.NewTunnelServer(publisher, policies, stats, tracer, config, logger)
 
-	generated.RegisterWebhookServiceServer(serverG, service)
+	generated.RegisterWebhookServiceServer(serverG, tunnelServer)
+	healthpb.RegisterHealthServer(serverG, healthServer)
 
 	return serverG
 }
@@ -1,16 +1,28 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+	"wh/domain/ipfilter"
+	"wh/domain/metrics"
 	"wh/domain/publish"
+	"wh/domain/tracing"
+	"wh/domain/verification"
 
 	"github.com/labstack/echo/v4"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -19,36 +31,101 @@ var (
 )
 
 type apiHandler struct {
-	logger    *zap.Logger
-	publisher publish.Publisher
-	timeout   time.Duration
+	baseDomain string
+	ipFilter   ipfilter.IPFilter
+	logger     *zap.Logger
+	maxSize    int64
+	metrics    metrics.Metrics
+	publisher  publish.Publisher
+	timeout    time.Duration
+	tracing    tracing.Tracing
+	verifier   verification.Verifier
 }
 
 type ApiHandler interface {
 	Index(c echo.Context) error
+
+	Subdomain(next echo.HandlerFunc) echo.HandlerFunc
 }
 
-func NewApiHandler(publisher publish.Publisher, config *viper.Viper, logger *zap.Logger) ApiHandler {
+func NewApiHandler(publisher publish.Publisher, verifier verification.Verifier, ipFilter ipfilter.IPFilter, metrics metrics.Metrics, tracing tracing.Tracing, config *viper.Viper, logger *zap.Logger) ApiHandler {
 	timeout := config.GetDuration("request.timeout")
 
 	return &apiHandler{
-		logger:    logger,
-		publisher: publisher,
-		timeout:   timeout,
+		baseDomain: strings.TrimSuffix(strings.ToLower(config.GetString("http.baseDomain")), "."),
+		ipFilter:   ipFilter,
+		logger:     logger,
+		maxSize:    config.GetInt64("request.maxSize"),
+		metrics:    metrics,
+		publisher:  publisher,
+		timeout:    timeout,
+		tracing:    tracing,
+		verifier:   verifier,
 	}
 }
 
 // ANY /endpoints/*
 func (a apiHandler) Index(c echo.Context) error {
-	request := c.Request()
-	response := c.Response()
-
-	var endpoint, path, ok = splitEndpointAndPath(request.URL.Path)
+	var endpoint, path, ok = splitEndpointAndPath(c.Request().URL.Path)
 	if !ok {
-		response.WriteHeader(http.StatusBadRequest)
+		c.Response().WriteHeader(http.StatusBadRequest)
 		return nil
 	}
 
+	return a.forward(c, endpoint, path)
+}
+
+// ANY <endpoint>.<baseDomain>/*
+func (a apiHandler) Subdomain(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var endpoint, ok = splitEndpointAndHost(c.Request().Host, a.baseDomain)
+		if !ok {
+			return next(c)
+		}
+
+		// The path is not touched, because some webhook providers require an exact match.
+		return a.forward(c, endpoint, c.Request().URL.Path)
+	}
+}
+
+func (a apiHandler) forward(c echo.Context, endpoint string, path string) (err error) {
+	request := c.Request()
+	response := c.Response()
+
+	started := time.Now()
+	received := &countingReader{reader: request.Body}
+
+	// Only forwarded calls are tracked by name, otherwise anybody could create arbitrary time series.
+	metricsEndpoint := ""
+
+	// Continue the trace of the caller, if the webhook provider sends a traceparent header.
+	ctx, span := a.tracing.Start(a.tracing.Extract(request.Context(), propagation.HeaderCarrier(request.Header)), "webhook",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("wh.endpoint", endpoint),
+			semconv.HTTPRequestMethodKey.String(request.Method),
+			semconv.URLPath(path),
+		),
+	)
+
+	defer func() {
+		status := response.Status
+		if err != nil && !response.Committed {
+			// The error handler writes the response after this function.
+			status = http.StatusInternalServerError
+		}
+
+		a.metrics.ObserveRequest(metricsEndpoint, status, received.count, response.Size, time.Since(started))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		} else if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		span.End()
+	}()
+
 	// Fragments are not sent to the server, therefore we just have to handle query strings.
 	if request.URL.RawQuery != "" {
 		path += "?"
@@ -61,26 +138,86 @@ func (a apiHandler) Index(c echo.Context) error {
 	)
 
 	forwardedRequest := publish.HttpRequestStart{
-		Path:    path,
-		Method:  request.Method,
-		Headers: request.Header,
+		Path:         path,
+		Method:       request.Method,
+		Headers:      request.Header,
+		TraceContext: a.tracing.Inject(ctx),
+	}
+
+	if err := a.ipFilter.Check(endpoint, request); err != nil {
+		a.logger.Warn("Webhook call from denied IP address",
+			zap.String("input.endpoint", endpoint),
+			zap.Error(err),
+		)
+
+		a.publisher.Reject(endpoint, forwardedRequest, publish.HttpResponseStart{Status: http.StatusForbidden}, err)
+
+		response.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+
+	var body io.Reader = received
+
+	// The signature is calculated over the whole body, therefore we have to buffer it.
+	if profile := a.verifier.GetProfile(endpoint); profile != nil {
+		buffered, err := io.ReadAll(io.LimitReader(received, a.maxSize+1))
+		if err != nil {
+			return err
+		}
+
+		if int64(len(buffered)) > a.maxSize {
+			response.WriteHeader(http.StatusRequestEntityTooLarge)
+			return nil
+		}
+
+		if err := profile.Verify(request.Header, buffered, time.Now()); err != nil {
+			a.logger.Warn("Webhook signature is not valid",
+				zap.String("input.endpoint", endpoint),
+				zap.Error(err),
+			)
+
+			forwardedRequest.Verification = publish.VerificationInvalid
+
+			if profile.Reject {
+				a.publisher.Reject(endpoint, forwardedRequest, publish.HttpResponseStart{Status: http.StatusUnauthorized}, err)
+
+				response.WriteHeader(http.StatusUnauthorized)
+				return nil
+			}
+		} else {
+			forwardedRequest.Verification = publish.VerificationValid
+		}
+
+		body = bytes.NewReader(buffered)
 	}
 
+	var throttled *publish.ThrottledError
+
 	tunneled, err := a.publisher.ForwardRequest(endpoint, forwardedRequest)
 	if errors.Is(err, publish.ErrNotRegistered) {
 		response.WriteHeader(http.StatusServiceUnavailable)
 		return nil
+	} else if errors.Is(err, publish.ErrShuttingDown) {
+		response.WriteHeader(http.StatusServiceUnavailable)
+		return nil
+	} else if errors.As(err, &throttled) {
+		response.Header().Set("Retry-After", strconv.Itoa(throttled.RetryAfterSeconds()))
+		response.WriteHeader(http.StatusTooManyRequests)
+		return nil
 	} else if err != nil {
 		return err
 	}
 
+	metricsEndpoint = endpoint
+
 	// Also cancel the request in case something goes wrong to forward the status to the client if not done yet.
 	defer tunneled.Cancel(EventOrigin)
 
 	// Use one channel per type to have a type safe behavior.
 	responseData := make(chan publish.HttpResponseData)
 	responseStart := make(chan publish.HttpResponseStart)
-	serverClientError := make(chan publish.HttpError)
+	// The error might be emitted while the body is still forwarded. It is emitted only once, therefore the buffer never blocks the tunnel.
+	serverClientError := make(chan publish.HttpError, 1)
 
 	// Have a separate closed channel that is closed by the sender to avoid deadlocks.
 	closed := make(chan bool)
@@ -110,11 +247,22 @@ func (a apiHandler) Index(c echo.Context) error {
 		}
 	})
 
-	ctx, cancel := context.WithTimeout(c.Request().Context(), 4*time.Hour)
+	ctx, cancel := context.WithTimeout(ctx, 4*time.Hour)
 	defer cancel()
 
-	body := request.Body
 	for {
+		// Stop forwarding the body when the request has already failed, the error is handled below.
+		if len(serverClientError) > 0 {
+			break
+		}
+
+		// Only the caller of a paused request has to wait, the other requests of the tunnel are not affected.
+		if err := tunneled.WaitResumed(ctx); err != nil {
+			tunneled.EmitError(EventOrigin, nil, true)
+			response.WriteHeader(http.StatusGatewayTimeout)
+			return nil
+		}
+
 		buffer := make([]byte, 4096)
 		n, err := body.Read(buffer)
 		if err != nil && err != io.EOF {
@@ -139,6 +287,9 @@ func (a apiHandler) Index(c echo.Context) error {
 			if msg.Timeout {
 				response.WriteHeader(http.StatusGatewayTimeout)
 				return nil
+			} else if errors.Is(msg.Error, publish.ErrShuttingDown) && !response.Committed {
+				response.WriteHeader(http.StatusServiceUnavailable)
+				return nil
 			} else {
 				return msg.Error
 			}
@@ -167,6 +318,39 @@ func (a apiHandler) Index(c echo.Context) error {
 	}
 }
 
+// Counts the bytes of the request body for the metrics.
+type countingReader struct {
+	count  int64
+	reader io.Reader
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+func splitEndpointAndHost(host string, baseDomain string) (string, bool) {
+	if baseDomain == "" {
+		return "", false
+	}
+
+	// The host might contain the port, which is not relevant for the routing.
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	// A fully qualified host name ends with a dot.
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	endpoint, ok := strings.CutSuffix(host, "."+baseDomain)
+	if !ok || endpoint == "" || strings.Contains(endpoint, ".") {
+		return "", false
+	}
+
+	return endpoint, true
+}
+
 func splitEndpointAndPath(rawPath string) (string, string, bool) {
 	parts := make([]string, 0)
 	for _, v := range strings.Split(rawPath, "/") {
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wh/domain/ipfilter"
+	"wh/domain/metrics"
+	"wh/domain/publish"
+	"wh/domain/redaction"
+	"wh/domain/tracing"
+	"wh/domain/verification"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type testApi struct {
+	echo      *echo.Echo
+	metrics   metrics.Metrics
+	publisher publish.Publisher
+	store     publish.Store
+}
+
+func newTestApi(t *testing.T, config *viper.Viper, tracing tracing.Tracing) *testApi {
+	logger := zap.NewNop()
+
+	store := publish.NewMemoryStore(config)
+
+	policies, err := publish.NewRecordingPolicies(store, config)
+	if err != nil {
+		t.Fatalf("failed to create policies: %v", err)
+	}
+
+	redactor, err := redaction.NewRedactor(config)
+	if err != nil {
+		t.Fatalf("failed to create redactor: %v", err)
+	}
+
+	filter, err := ipfilter.NewIPFilter(config)
+	if err != nil {
+		t.Fatalf("failed to create IP filter: %v", err)
+	}
+
+	verifier, err := verification.NewVerifier(config)
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	m := metrics.NewMetrics()
+
+	publisher := publish.NewPublisher(store, publish.NewMemoryBucket(config), publish.NewLimiter(config), policies, redactor, m, tracing, config, logger)
+	handler := NewApiHandler(publisher, verifier, filter, m, tracing, config, logger)
+
+	e := echo.New()
+	e.Any("/endpoints/*", handler.Index)
+
+	return &testApi{echo: e, metrics: m, publisher: publisher, store: store}
+}
+
+func newDisabledTracing(t *testing.T) tracing.Tracing {
+	result, err := tracing.NewTracing(viper.New())
+	if err != nil {
+		t.Fatalf("failed to create tracing: %v", err)
+	}
+
+	return result
+}
+
+// Subscribes a client that answers every request with the given status, once the request body has been received.
+func (a *testApi) subscribe(t *testing.T, endpoint string, status int32) {
+	handler := func(request *publish.TunneledRequest) {
+		request.OnRequestData(publish.EventOrigin, func(msg publish.HttpRequestData) {
+			if !msg.Completed {
+				return
+			}
+
+			// The events are emitted while the request is locked.
+			go func() {
+				request.EmitResponse(publish.EventOrigin, http.Header{}, status, 0)
+				request.EmitResponseData(publish.EventOrigin, []byte("ok"), true)
+			}()
+		})
+	}
+
+	if err := a.publisher.Subscribe(endpoint, "", publish.TunnelInfo{}, handler, func() {}); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+}
+
+func (a *testApi) call(request *http.Request) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	a.echo.ServeHTTP(response, request)
+
+	return response
+}
+
+func (a *testApi) scrape(t *testing.T) string {
+	response := httptest.NewRecorder()
+	a.metrics.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+
+	return string(body)
+}
+
+func TestMetricsEndpointLabel(t *testing.T) {
+	config := viper.New()
+	config.Set("request.maxSize", 10)
+	config.Set("endpoints.denied.ipFilter.deny", []string{"0.0.0.0/0"})
+	config.Set("endpoints.signed.verification.type", "github")
+	config.Set("endpoints.signed.verification.secret", "secret")
+	config.Set("endpoints.signed.verification.reject", true)
+
+	api := newTestApi(t, config, newDisabledTracing(t))
+	api.subscribe(t, "users", http.StatusCreated)
+	api.subscribe(t, "denied", http.StatusCreated)
+	api.subscribe(t, "signed", http.StatusCreated)
+
+	tests := []struct {
+		name     string
+		request  *http.Request
+		expected int
+	}{
+		{name: "forwarded", request: httptest.NewRequest(http.MethodPost, "/endpoints/users/hook", strings.NewReader("{}")), expected: http.StatusCreated},
+		{name: "unknown", request: httptest.NewRequest(http.MethodPost, "/endpoints/unknown/hook", strings.NewReader("{}")), expected: http.StatusServiceUnavailable},
+		{name: "denied", request: httptest.NewRequest(http.MethodPost, "/endpoints/denied/hook", strings.NewReader("{}")), expected: http.StatusForbidden},
+		{name: "invalid signature", request: httptest.NewRequest(http.MethodPost, "/endpoints/signed/hook", strings.NewReader("{}")), expected: http.StatusUnauthorized},
+		{name: "too large", request: httptest.NewRequest(http.MethodPost, "/endpoints/signed/hook", strings.NewReader("01234567890")), expected: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		if response := api.call(test.request); response.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expected, response.Code)
+		}
+	}
+
+	scraped := api.scrape(t)
+
+	if !strings.Contains(scraped, `wh_requests_total{endpoint="users",status="2xx"} 1`) {
+		t.Errorf("expected the forwarded request to be counted by endpoint:\n%s", scraped)
+	}
+
+	if !strings.Contains(scraped, `wh_requests_total{endpoint="",status="4xx"} 3`) || !strings.Contains(scraped, `wh_requests_total{endpoint="",status="5xx"} 1`) {
+		t.Errorf("expected the other requests to be counted without endpoint:\n%s", scraped)
+	}
+
+	for _, endpoint := range []string{"unknown", "denied", "signed"} {
+		if strings.Contains(scraped, `endpoint="`+endpoint+`"`) {
+			t.Errorf("expected no label for the endpoint %s", endpoint)
+		}
+	}
+}
+
+func TestShutdownDeadlineReturnsServiceUnavailable(t *testing.T) {
+	api := newTestApi(t, viper.New(), newDisabledTracing(t))
+
+	// The client never answers, therefore the request is still pending at the deadline.
+	forwarded := make(chan bool, 1)
+	handler := func(request *publish.TunneledRequest) {
+		request.OnRequestData(publish.EventOrigin, func(msg publish.HttpRequestData) {
+			if msg.Completed {
+				forwarded <- true
+			}
+		})
+	}
+
+	if err := api.publisher.Subscribe("users", "", publish.TunnelInfo{}, handler, func() {}); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	responses := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		responses <- api.call(httptest.NewRequest(http.MethodPost, "/endpoints/users/hook", strings.NewReader("{}")))
+	}()
+
+	<-forwarded
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := api.publisher.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	select {
+	case response := <-responses:
+		if response.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, response.Code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the pending request to be answered")
+	}
+
+	// New requests are rejected while the server shuts down.
+	if response := api.call(httptest.NewRequest(http.MethodPost, "/endpoints/users/hook", strings.NewReader("{}"))); response.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d for new requests, got %d", http.StatusServiceUnavailable, response.Code)
+	}
+}
+
+func TestThrottledRequest(t *testing.T) {
+	config := viper.New()
+	config.Set("limits.endpoint.rate", 1)
+
+	api := newTestApi(t, config, newDisabledTracing(t))
+	api.subscribe(t, "users", http.StatusOK)
+
+	if response := api.call(httptest.NewRequest(http.MethodPost, "/endpoints/users/hook", strings.NewReader("{}"))); response.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/endpoints/users/hook", strings.NewReader("{}"))
+	request.Header.Set("Authorization", "Bearer secret")
+
+	response := api.call(request)
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected status %d with Retry-After, got %d with %q", http.StatusTooManyRequests, response.Code, response.Header().Get("Retry-After"))
+	}
+
+	entries, _, err := api.store.GetEntries(0)
+	if err != nil {
+		t.Fatalf("failed to get entries: %v", err)
+	}
+
+	var throttled *publish.StoreEntry
+	for i := range entries {
+		if entries[i].Status == publish.StatusThrottled {
+			throttled = &entries[i]
+		}
+	}
+
+	if throttled == nil || throttled.Response == nil || throttled.Response.Status != http.StatusTooManyRequests || throttled.Response.Headers.Get("Retry-After") != "1" {
+		t.Fatalf("expected the throttled request to be recorded, got %+v", throttled)
+	}
+
+	// The request is not forwarded, but its headers are recorded.
+	if throttled.Endpoint != "users" || throttled.Request.Headers.Get("Authorization") != "Bearer secret" {
+		t.Errorf("expected the request to be recorded, got %+v", throttled.Request)
+	}
+}
+
+func TestSplitEndpointAndHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		host       string
+		baseDomain string
+		endpoint   string
+		ok         bool
+	}{
+		{name: "subdomain", host: "users.example.com", baseDomain: "example.com", endpoint: "users", ok: true},
+		{name: "port", host: "users.example.com:8080", baseDomain: "example.com", endpoint: "users", ok: true},
+		{name: "uppercase", host: "Users.Example.COM", baseDomain: "example.com", endpoint: "users", ok: true},
+		{name: "fully qualified", host: "users.example.com.", baseDomain: "example.com", endpoint: "users", ok: true},
+		{name: "fully qualified with port", host: "users.example.com.:8080", baseDomain: "example.com", endpoint: "users", ok: true},
+		{name: "nested subdomain", host: "api.users.example.com", baseDomain: "example.com", ok: false},
+		{name: "base domain", host: "example.com", baseDomain: "example.com", ok: false},
+		{name: "empty subdomain", host: ".example.com", baseDomain: "example.com", ok: false},
+		{name: "other domain", host: "users.example.org", baseDomain: "example.com", ok: false},
+		{name: "suffix without dot", host: "usersexample.com", baseDomain: "example.com", ok: false},
+		{name: "no base domain", host: "users.example.com", baseDomain: "", ok: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint, ok := splitEndpointAndHost(test.host, test.baseDomain)
+			if endpoint != test.endpoint || ok != test.ok {
+				t.Errorf("expected %q and %v, got %q and %v", test.endpoint, test.ok, endpoint, ok)
+			}
+		})
+	}
+}
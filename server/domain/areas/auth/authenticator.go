@@ -58,10 +58,12 @@ func (a authenticator) GetApiKey(c echo.Context) (string, error) {
 func (a authenticator) SetApiKey(c echo.Context, apiKey string) error {
 	if apiKey == "" {
 		cookie := &http.Cookie{
-			Name:    a.cookieName,
-			Value:   "removed",
-			Path:    "/",
-			Expires: time.Unix(0, 0),
+			Name:     a.cookieName,
+			Value:    "removed",
+			Path:     "/",
+			Expires:  time.Unix(0, 0),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
 		}
 
 		c.SetCookie(cookie)
@@ -81,11 +83,14 @@ func setCookie(a authenticator, c echo.Context, apiKey string) error {
 		return err
 	}
 
+	// Browsers do not send the cookie with form posts from other sites.
 	cookie := &http.Cookie{
-		Name:    a.cookieName,
-		Value:   encoded,
-		Path:    "/",
-		Expires: time.Now().Add(30 * 24 * time.Hour),
+		Name:     a.cookieName,
+		Value:    encoded,
+		Path:     "/",
+		Expires:  time.Now().Add(30 * 24 * time.Hour),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	}
 
 	c.SetCookie(cookie)
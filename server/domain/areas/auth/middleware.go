@@ -2,16 +2,21 @@ package auth
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/securecookie"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
+const csrfContextKey = "csrf"
+
 type authMiddleware struct {
 	authenticator Authenticator
+	csrf          echo.MiddlewareFunc
 	logger        *zap.Logger
 }
 
@@ -19,10 +24,31 @@ type AuthMiddleware interface {
 	MustBeAuthenticated(next echo.HandlerFunc) echo.HandlerFunc
 
 	MustNotBeAuthenticated(next echo.HandlerFunc) echo.HandlerFunc
+
+	// MustHaveApiKey accepts the API key as bearer token or cookie and does not redirect, which is needed for machines.
+	MustHaveApiKey(next echo.HandlerFunc) echo.HandlerFunc
+
+	// MustHaveCsrfToken rejects form posts without the token of the CSRF cookie. The forms get the token with GetCsrfToken.
+	MustHaveCsrfToken(next echo.HandlerFunc) echo.HandlerFunc
 }
 
 func NewAuthMiddleware(authenticator Authenticator, logger *zap.Logger) AuthMiddleware {
-	return &authMiddleware{logger: logger, authenticator: authenticator}
+	csrf := middleware.CSRFWithConfig(middleware.CSRFConfig{
+		TokenLookup:    "form:_csrf",
+		ContextKey:     csrfContextKey,
+		CookieName:     "CSRF",
+		CookiePath:     "/internal",
+		CookieHTTPOnly: true,
+		CookieSameSite: http.SameSiteStrictMode,
+	})
+
+	return &authMiddleware{logger: logger, authenticator: authenticator, csrf: csrf}
+}
+
+// GetCsrfToken returns the token for the forms of pages that are protected by MustHaveCsrfToken.
+func GetCsrfToken(c echo.Context) string {
+	token, _ := c.Get(csrfContextKey).(string)
+	return token
 }
 
 func createKey(name string, config *viper.Viper) []byte {
@@ -82,6 +108,26 @@ func (a authMiddleware) MustNotBeAuthenticated(next echo.HandlerFunc) echo.Handl
 	}
 }
 
+func (a authMiddleware) MustHaveApiKey(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		apiKey, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if !ok {
+			apiKey, _ = a.authenticator.GetApiKey(c)
+		}
+
+		if apiKey == "" || !a.authenticator.Validate(apiKey) {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+			return c.NoContent(http.StatusUnauthorized)
+		}
+
+		return next(c)
+	}
+}
+
+func (a authMiddleware) MustHaveCsrfToken(next echo.HandlerFunc) echo.HandlerFunc {
+	return a.csrf(next)
+}
+
 func redirectToLogin(a Authenticator, c echo.Context) error {
 	a.SetApiKey(c, "")
 	return c.Redirect(http.StatusFound, "/")
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Serves a form that is protected against CSRF and returns the token of the form.
+func newTestCsrfServer() *echo.Echo {
+	e := echo.New()
+	middleware := NewAuthMiddleware(NewAuthenticator(viper.New()), zap.NewNop())
+
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, GetCsrfToken(c))
+	}
+
+	e.GET("/internal/tunnels", handler, middleware.MustHaveCsrfToken)
+	e.POST("/internal/tunnels", handler, middleware.MustHaveCsrfToken)
+
+	return e
+}
+
+func postForm(e *echo.Echo, cookie *http.Cookie, token string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	if token != "" {
+		form.Set("_csrf", token)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/internal/tunnels", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestMustHaveCsrfToken(t *testing.T) {
+	e := newTestCsrfServer()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/internal/tunnels", nil))
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "CSRF" {
+		t.Fatalf("expected the CSRF cookie, got %v", cookies)
+	}
+
+	cookie := cookies[0]
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.Path != "/internal" {
+		t.Errorf("expected a strict cookie for the internal pages, got %+v", cookie)
+	}
+
+	token := rec.Body.String()
+	if token == "" || token != cookie.Value {
+		t.Fatalf("expected the token of the cookie, got %q", token)
+	}
+
+	tests := []struct {
+		name     string
+		cookie   *http.Cookie
+		token    string
+		expected int
+	}{
+		{name: "valid", cookie: cookie, token: token, expected: http.StatusOK},
+		{name: "missing token", cookie: cookie, expected: http.StatusBadRequest},
+		{name: "wrong token", cookie: cookie, token: "other", expected: http.StatusForbidden},
+		// A forged request from another site does not have the cookie.
+		{name: "missing cookie", token: token, expected: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rec := postForm(e, test.cookie, test.token); rec.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAuthCookieIsSameSite(t *testing.T) {
+	e := echo.New()
+	authenticator := NewAuthenticator(viper.New())
+
+	for _, apiKey := range []string{"key", ""} {
+		rec := httptest.NewRecorder()
+		if err := authenticator.SetApiKey(e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec), apiKey); err != nil {
+			t.Fatalf("failed to set cookie: %v", err)
+		}
+
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+			t.Errorf("expected a lax http only cookie, got %v", cookies)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package home
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
@@ -19,6 +20,14 @@ type HomeHandler interface {
 
 	GetInternal(c echo.Context) error
 
+	GetRecording(c echo.Context) error
+
+	PostRecording(c echo.Context) error
+
+	GetTunnels(c echo.Context) error
+
+	PostTunnels(c echo.Context) error
+
 	PostIndex(c echo.Context) error
 
 	GetError(c echo.Context) error
@@ -36,14 +45,18 @@ type homeHandler struct {
 	authenticator auth.Authenticator
 	buckets       publish.Buckets
 	logger        *zap.Logger
+	policies      publish.RecordingPolicies
+	publisher     publish.Publisher
 	store         publish.Store
 }
 
-func NewHomeHandler(store publish.Store, buckets publish.Buckets, authenticator auth.Authenticator, logger *zap.Logger) HomeHandler {
+func NewHomeHandler(store publish.Store, buckets publish.Buckets, policies publish.RecordingPolicies, publisher publish.Publisher, authenticator auth.Authenticator, logger *zap.Logger) HomeHandler {
 	return &homeHandler{
 		authenticator: authenticator,
 		buckets:       buckets,
 		logger:        logger,
+		policies:      policies,
+		publisher:     publisher,
 		store:         store,
 	}
 }
@@ -79,6 +92,91 @@ func (h homeHandler) GetInternal(c echo.Context) error {
 	return server.Render(c, http.StatusOK, views.InternalView(vm))
 }
 
+// GET /internal/recording
+func (h homeHandler) GetRecording(c echo.Context) error {
+	return h.renderRecording(c, false)
+}
+
+// POST /internal/recording
+func (h homeHandler) PostRecording(c echo.Context) error {
+	endpoint := strings.TrimSpace(c.FormValue("endpoint"))
+
+	var policy *publish.RecordingPolicy
+	if c.FormValue("reset") != "true" {
+		maxBodySize, err := strconv.Atoi(c.FormValue("maxBodySize"))
+		if err != nil {
+			return h.renderRecording(c, true)
+		}
+
+		sampleRate, err := strconv.Atoi(c.FormValue("sampleRate"))
+		if err != nil {
+			return h.renderRecording(c, true)
+		}
+
+		policy = &publish.RecordingPolicy{
+			Mode:        c.FormValue("mode"),
+			MaxBodySize: maxBodySize,
+			SampleRate:  sampleRate,
+		}
+	}
+
+	err := h.policies.Set(endpoint, policy)
+	if errors.Is(err, publish.ErrInvalidRecordingPolicy) || errors.Is(err, publish.ErrInvalidEndpoint) {
+		return h.renderRecording(c, true)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, "/internal/recording")
+}
+
+func (h homeHandler) renderRecording(c echo.Context, invalid bool) error {
+	vm := views.RecordingVM{
+		CsrfToken:     auth.GetCsrfToken(c),
+		Default:       h.policies.GetDefault(),
+		Policies:      h.policies.GetStored(),
+		InvalidPolicy: invalid,
+	}
+
+	status := http.StatusOK
+	if invalid {
+		status = http.StatusBadRequest
+	}
+
+	return server.Render(c, status, views.RecordingView(vm))
+}
+
+// GET /internal/tunnels
+func (h homeHandler) GetTunnels(c echo.Context) error {
+	vm := views.TunnelsVM{
+		CsrfToken: auth.GetCsrfToken(c),
+		Tunnels:   h.publisher.GetTunnels(),
+	}
+
+	return server.Render(c, http.StatusOK, views.TunnelsView(vm))
+}
+
+// POST /internal/tunnels
+func (h homeHandler) PostTunnels(c echo.Context) error {
+	endpoint := c.FormValue("endpoint")
+
+	// The tunnel might have been closed in the meantime.
+	err := h.publisher.Disconnect(endpoint)
+	if err != nil && !errors.Is(err, publish.ErrNotRegistered) {
+		return err
+	}
+
+	if err == nil {
+		h.logger.Info("Tunnel disconnected by user.",
+			zap.String("endpoint", endpoint),
+		)
+	}
+
+	return c.Redirect(http.StatusFound, "/internal/tunnels")
+}
+
 // GET /error
 func (h homeHandler) GetError(c echo.Context) error {
 	vm := views.ErrorVM{
@@ -175,6 +273,11 @@ func (h homeHandler) ErrorHandler(err error, c echo.Context) {
 
 func writeResponse(response *echo.Response, reader io.Reader, headers http.Header) error {
 	for k, v := range headers {
+		// The stored body can be different from the original body, for example when redacted.
+		if k == echo.HeaderContentLength {
+			continue
+		}
+
 		for _, h := range v {
 			response.Header().Add(k, h)
 		}
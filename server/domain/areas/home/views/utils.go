@@ -1,11 +1,17 @@
 package views
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"sort"
 	"time"
+	"wh/domain/publish"
+	"wh/domain/texts"
 	"wh/infrastructure/utils"
+
+	"github.com/a-h/templ"
 )
 
 func getStatusClass(status int32) string {
@@ -32,6 +38,10 @@ func getStartTime(vm LogEntryVM) string {
 	return vm.Entry.Started.Format(time.RFC822)
 }
 
+func getConnectedTime(tunnel publish.TunnelInfo) string {
+	return tunnel.Connected.Format(time.RFC822)
+}
+
 func getCompleteTime(vm LogEntryVM) string {
 	if vm.Entry.Completed == nil {
 		return ""
@@ -61,3 +71,121 @@ func getSortedHeaders(headers http.Header) []string {
 
 	return keys
 }
+
+func getRedacted(vm LogEntryVM, location publish.RedactionLocation) []string {
+	result := make([]string, 0)
+
+	for _, r := range vm.Entry.Redacted {
+		if r.Location == location {
+			result = append(result, r.Name)
+		}
+	}
+
+	return result
+}
+
+func getRecordingMode(ctx context.Context, mode publish.RecordingMode) string {
+	switch mode {
+	case publish.RecordingMetadata:
+		return texts.CommonRecordingModeMetadata(ctx)
+	case publish.RecordingOff:
+		return texts.CommonRecordingModeOff(ctx)
+	}
+
+	return texts.CommonRecordingModeFull(ctx)
+}
+
+func getWaterfall(ctx context.Context, vm LogEntryVM) []WaterfallPhase {
+	result := make([]WaterfallPhase, 0)
+
+	entry := vm.Entry
+	if entry.Completed == nil {
+		return result
+	}
+
+	start := entry.Started
+	total := entry.Completed.Sub(start)
+	if total <= 0 {
+		return result
+	}
+
+	add := func(label string, class string, from time.Time, to time.Time) {
+		duration := to.Sub(from)
+		if duration < 0 {
+			return
+		}
+
+		// The clocks of the client and the server are not synchronized, therefore keep the bar within the request.
+		visibleFrom := max(from.Sub(start), 0)
+		visibleTo := min(to.Sub(start), total)
+
+		result = append(result, WaterfallPhase{
+			Label:    label,
+			Class:    class,
+			Offset:   percent(visibleFrom, total),
+			Width:    percent(visibleTo-visibleFrom, total),
+			Duration: duration,
+		})
+	}
+
+	timings := entry.Timings
+
+	waitingSince := start
+	if timings.RequestForwarded != nil {
+		add(texts.CommonTimingForwarded(ctx), "bg-gray-400", start, *timings.RequestForwarded)
+		waitingSince = *timings.RequestForwarded
+	}
+
+	// Requests without a response have been waiting until they failed.
+	if timings.ResponseStarted == nil {
+		add(texts.CommonTimingWaiting(ctx), "bg-gray-400", waitingSince, *entry.Completed)
+		return result
+	}
+
+	add(texts.CommonTimingWaiting(ctx), "bg-gray-400", waitingSince, *timings.ResponseStarted)
+	downloadSince := *timings.ResponseStarted
+
+	// The client measures the local server from the request start to the response start.
+	if timings.LocalDuration > 0 {
+		add(texts.CommonTimingLocal(ctx), "bg-primary", timings.ResponseStarted.Add(-timings.LocalDuration), *timings.ResponseStarted)
+	}
+
+	if timings.FirstResponseByte != nil {
+		add(texts.CommonTimingFirstByte(ctx), "bg-gray-400", downloadSince, *timings.FirstResponseByte)
+		downloadSince = *timings.FirstResponseByte
+	}
+
+	add(texts.CommonTimingCompleted(ctx), "bg-gray-400", downloadSince, *entry.Completed)
+
+	return result
+}
+
+// The time spent in the tunnel and the server, when the client has reported the time of the local server.
+func getTunnelDuration(vm LogEntryVM) string {
+	timings := vm.Entry.Timings
+	if timings.ResponseStarted == nil || timings.LocalDuration <= 0 {
+		return ""
+	}
+
+	overhead := timings.ResponseStarted.Sub(vm.Entry.Started) - timings.LocalDuration
+	return formatDuration(max(overhead, 0))
+}
+
+func getLocalDuration(vm LogEntryVM) string {
+	return formatDuration(vm.Entry.Timings.LocalDuration)
+}
+
+func getPhaseAttributes(phase WaterfallPhase) templ.Attributes {
+	// Always show a small bar, even when the phase is very short.
+	return templ.Attributes{
+		"style": fmt.Sprintf("left: %.2f%%; width: %.2f%%; min-width: 2px", phase.Offset, phase.Width),
+	}
+}
+
+func formatDuration(duration time.Duration) string {
+	return duration.Round(time.Microsecond).String()
+}
+
+func percent(value time.Duration, total time.Duration) float64 {
+	return math.Min(100, math.Max(0, float64(value)*100/float64(total)))
+}
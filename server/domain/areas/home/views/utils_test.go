@@ -0,0 +1,129 @@
+package views
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+	"wh/domain/publish"
+)
+
+type testPhase struct {
+	offset   float64
+	width    float64
+	duration time.Duration
+}
+
+func TestGetWaterfall(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) *time.Time {
+		result := start.Add(time.Duration(ms) * time.Millisecond)
+		return &result
+	}
+
+	tests := []struct {
+		name      string
+		completed *time.Time
+		timings   publish.Timings
+		expected  []testPhase
+	}{
+		{
+			name:     "pending",
+			expected: []testPhase{},
+		},
+		{
+			name:      "completed at start",
+			completed: at(0),
+			expected:  []testPhase{},
+		},
+		{
+			name:      "all phases",
+			completed: at(100),
+			timings:   publish.Timings{RequestForwarded: at(10), ResponseStarted: at(60), FirstResponseByte: at(70), LocalDuration: 40 * time.Millisecond},
+			expected: []testPhase{
+				{offset: 0, width: 10, duration: 10 * time.Millisecond},
+				{offset: 10, width: 50, duration: 50 * time.Millisecond},
+				{offset: 20, width: 40, duration: 40 * time.Millisecond},
+				{offset: 60, width: 10, duration: 10 * time.Millisecond},
+				{offset: 70, width: 30, duration: 30 * time.Millisecond},
+			},
+		},
+		{
+			// Rejected and failed requests have no timings.
+			name:      "missing phases",
+			completed: at(100),
+			expected:  []testPhase{{offset: 0, width: 100, duration: 100 * time.Millisecond}},
+		},
+		{
+			name:      "no response",
+			completed: at(100),
+			timings:   publish.Timings{RequestForwarded: at(20)},
+			expected: []testPhase{
+				{offset: 0, width: 20, duration: 20 * time.Millisecond},
+				{offset: 20, width: 80, duration: 80 * time.Millisecond},
+			},
+		},
+		{
+			// The client measured more time than the server, because the clocks run differently.
+			name:      "local duration longer than the request",
+			completed: at(100),
+			timings:   publish.Timings{ResponseStarted: at(50), LocalDuration: 80 * time.Millisecond},
+			expected: []testPhase{
+				{offset: 0, width: 50, duration: 50 * time.Millisecond},
+				{offset: 0, width: 50, duration: 80 * time.Millisecond},
+				{offset: 50, width: 50, duration: 50 * time.Millisecond},
+			},
+		},
+		{
+			// The phases out of order are skipped instead of being drawn with a negative width.
+			name:      "response before forwarded",
+			completed: at(100),
+			timings:   publish.Timings{RequestForwarded: at(60), ResponseStarted: at(40)},
+			expected: []testPhase{
+				{offset: 0, width: 60, duration: 60 * time.Millisecond},
+				{offset: 40, width: 60, duration: 60 * time.Millisecond},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := LogEntryVM{Entry: publish.StoreEntry{Started: start, Completed: test.completed, Timings: test.timings}}
+
+			phases := getWaterfall(context.Background(), vm)
+			if len(phases) != len(test.expected) {
+				t.Fatalf("expected %d phases, got %+v", len(test.expected), phases)
+			}
+
+			for i, phase := range phases {
+				expected := test.expected[i]
+				if math.Abs(phase.Offset-expected.offset) > 0.01 || math.Abs(phase.Width-expected.width) > 0.01 || phase.Duration != expected.duration {
+					t.Errorf("phase %d: expected %+v, got %+v", i, expected, phase)
+				}
+
+				if phase.Offset+phase.Width > 100.01 {
+					t.Errorf("phase %d: expected the bar within the request, got %+v", i, phase)
+				}
+			}
+		})
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		value    time.Duration
+		total    time.Duration
+		expected float64
+	}{
+		{value: 25, total: 100, expected: 25},
+		{value: 0, total: 100, expected: 0},
+		{value: -10, total: 100, expected: 0},
+		{value: 150, total: 100, expected: 100},
+	}
+
+	for _, test := range tests {
+		if actual := percent(test.value, test.total); actual != test.expected {
+			t.Errorf("%d of %d: expected %v, got %v", test.value, test.total, test.expected, actual)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 	"wh/domain/publish"
 )
 
@@ -18,6 +19,18 @@ type IndexVM struct {
 type InternalVM struct {
 }
 
+type RecordingVM struct {
+	CsrfToken     string
+	Default       publish.RecordingPolicy
+	Policies      []publish.RecordingPolicyEntry
+	InvalidPolicy bool
+}
+
+type TunnelsVM struct {
+	CsrfToken string
+	Tunnels   []publish.TunnelInfo
+}
+
 type EventsVM struct {
 	Entries []LogEntryVM
 }
@@ -28,6 +41,14 @@ type LogEntryVM struct {
 	ResponseEditor *EditorInfo
 }
 
+type WaterfallPhase struct {
+	Label    string
+	Class    string
+	Offset   float64
+	Width    float64
+	Duration time.Duration
+}
+
 type EditorInfo struct {
 	Mode   string
 	Source string
@@ -0,0 +1,71 @@
+package status
+
+import (
+	"net/http"
+	"wh/domain/health"
+	"wh/domain/publish"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type statusHandler struct {
+	health    health.Health
+	logger    *zap.Logger
+	publisher publish.Publisher
+}
+
+type StatusHandler interface {
+	GetHealth(c echo.Context) error
+
+	GetReady(c echo.Context) error
+
+	GetStatus(c echo.Context) error
+}
+
+// The probes do not require authentication, therefore they do not return the details of the checks.
+type readyResponse struct {
+	Status health.Status `json:"status"`
+}
+
+type statusResponse struct {
+	Status  health.Status        `json:"status"`
+	Checks  []health.Check       `json:"checks"`
+	Tunnels []publish.TunnelInfo `json:"tunnels"`
+}
+
+func NewStatusHandler(health health.Health, publisher publish.Publisher, logger *zap.Logger) StatusHandler {
+	return &statusHandler{health: health, logger: logger, publisher: publisher}
+}
+
+// GET /healthz
+func (h statusHandler) GetHealth(c echo.Context) error {
+	// The process is alive as long as it can answer, the dependencies are checked by the readiness probe.
+	return c.JSON(http.StatusOK, readyResponse{Status: health.StatusOk})
+}
+
+// GET /readyz
+func (h statusHandler) GetReady(c echo.Context) error {
+	checks, ready := h.health.Ready(c.Request().Context())
+	if !ready {
+		h.logger.Warn("Server is not ready.",
+			zap.Any("checks", checks),
+		)
+
+		return c.JSON(http.StatusServiceUnavailable, readyResponse{Status: health.StatusFailed})
+	}
+
+	return c.JSON(http.StatusOK, readyResponse{Status: health.StatusOk})
+}
+
+// GET /status
+func (h statusHandler) GetStatus(c echo.Context) error {
+	checks, ready := h.health.Ready(c.Request().Context())
+
+	status := health.StatusOk
+	if !ready {
+		status = health.StatusFailed
+	}
+
+	return c.JSON(http.StatusOK, statusResponse{Status: status, Checks: checks, Tunnels: h.publisher.GetTunnels()})
+}
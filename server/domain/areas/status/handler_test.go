@@ -0,0 +1,84 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wh/domain/health"
+	"wh/domain/publish"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type fixedHealth struct {
+	checks []health.Check
+	ready  bool
+}
+
+func (h fixedHealth) Ready(ctx context.Context) ([]health.Check, bool) {
+	return h.checks, h.ready
+}
+
+type noTunnels struct {
+	publish.Publisher
+}
+
+func (p noTunnels) GetTunnels() []publish.TunnelInfo {
+	return []publish.TunnelInfo{}
+}
+
+func serve(t *testing.T, handler echo.HandlerFunc) (int, map[string]any) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	if err := handler(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)); err != nil {
+		t.Fatalf("failed to handle request: %v", err)
+	}
+
+	body := make(map[string]any)
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	return rec.Code, body
+}
+
+func TestReadyAndStatus(t *testing.T) {
+	failed := fixedHealth{
+		checks: []health.Check{
+			{Name: "store", Status: health.StatusFailed, Error: "dial tcp 10.0.0.5:5432: connection refused"},
+			{Name: "buckets", Status: health.StatusOk},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		health   health.Health
+		ready    int
+		expected health.Status
+	}{
+		{name: "ok", health: fixedHealth{checks: []health.Check{{Name: "store", Status: health.StatusOk}}, ready: true}, ready: http.StatusOK, expected: health.StatusOk},
+		{name: "failed", health: failed, ready: http.StatusServiceUnavailable, expected: health.StatusFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := NewStatusHandler(test.health, noTunnels{}, zap.NewNop())
+
+			// The probe does not require authentication, therefore the details must not be returned.
+			code, body := serve(t, handler.GetReady)
+			if code != test.ready || body["status"] != test.expected || len(body) != 1 {
+				t.Errorf("expected %d with status %s only, got %d with %v", test.ready, test.expected, code, body)
+			}
+
+			code, body = serve(t, handler.GetStatus)
+			checks, _ := body["checks"].([]any)
+			if code != http.StatusOK || body["status"] != test.expected || len(checks) != len(test.health.(fixedHealth).checks) {
+				t.Errorf("expected the status %s with the checks, got %d with %v", test.expected, code, body)
+			}
+		})
+	}
+}
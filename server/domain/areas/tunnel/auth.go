@@ -6,6 +6,7 @@ import (
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -27,3 +28,26 @@ func Authorize(authenticator auth.Authenticator, ctx context.Context) error {
 
 	return nil
 }
+
+func getApiKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	authHeader := md.Get("authorization")
+	if len(authHeader) == 0 {
+		return ""
+	}
+
+	return authHeader[0]
+}
+
+func getRemoteAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	return p.Addr.String()
+}
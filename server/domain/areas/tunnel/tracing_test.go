@@ -0,0 +1,179 @@
+package tunnel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wh/domain/areas/api"
+	generated "wh/domain/areas/tunnel/api/tunnel"
+	"wh/domain/ipfilter"
+	"wh/domain/publish"
+	"wh/domain/tracing"
+	"wh/domain/verification"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+// Captures the requests that the webhook handler passes to the publisher.
+type capturingPublisher struct {
+	publish.Publisher
+
+	forwarded chan publish.HttpRequestStart
+}
+
+func (p *capturingPublisher) ForwardRequest(endpoint string, request publish.HttpRequestStart) (*publish.TunneledRequest, error) {
+	p.forwarded <- request
+	return p.Publisher.ForwardRequest(endpoint, request)
+}
+
+// The in-memory exporter deletes the spans on shutdown, but the tracing has to be shut down to export them.
+type keepingExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (e keepingExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+
+	t.Fatalf("expected span %s, got %d spans", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func hasAttribute(span tracetest.SpanStub, expected attribute.KeyValue) bool {
+	for _, a := range span.Attributes {
+		if a == expected {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestTracePropagation(t *testing.T) {
+	const (
+		traceId      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		callerSpanId = "00f067aa0ba902b7"
+	)
+
+	config := viper.New()
+	config.Set("tracing.serviceName", "wh")
+	config.Set("tracing.sampleRatio", 1.0)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tr := tracing.NewTracingWithExporter(keepingExporter{exporter}, config)
+
+	publisher := &capturingPublisher{forwarded: make(chan publish.HttpRequestStart, 1)}
+	tunnel := newTestTunnelWithPublisher(t, tr, "users", func(p publish.Publisher) publish.Publisher {
+		publisher.Publisher = p
+		return publisher
+	})
+
+	filter, err := ipfilter.NewIPFilter(config)
+	if err != nil {
+		t.Fatalf("failed to create IP filter: %v", err)
+	}
+
+	verifier, err := verification.NewVerifier(config)
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	handler := api.NewApiHandler(tunnel.publisher, verifier, filter, tunnel.metrics, tr, config, zap.NewNop())
+
+	e := echo.New()
+	e.Any("/endpoints/*", handler.Index)
+
+	// The webhook provider already traces the call.
+	request := httptest.NewRequest(http.MethodPost, "/endpoints/users/hook", strings.NewReader("{}"))
+	request.Header.Set("traceparent", "00-"+traceId+"-"+callerSpanId+"-01")
+
+	response := httptest.NewRecorder()
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		e.ServeHTTP(response, request)
+	}()
+
+	forwarded := <-publisher.forwarded
+	if !strings.Contains(forwarded.TraceContext["traceparent"], traceId) {
+		t.Errorf("expected the trace context in the forwarded request, got %v", forwarded.TraceContext)
+	}
+
+	start := tunnel.stream.next(t).GetRequestStart()
+	if start == nil {
+		t.Fatal("expected the request to be sent to the client")
+	}
+
+	// Wait until the body has been forwarded, then answer like the client.
+	for data := tunnel.stream.next(t).GetRequestData(); !data.GetCompleted(); data = tunnel.stream.next(t).GetRequestData() {
+	}
+
+	requestId := start.GetRequestId()
+	status := int32(http.StatusOK)
+	completed := true
+
+	tunnel.stream.received <- &generated.ClientMessage{
+		TestMessageType: &generated.ClientMessage_ResponseStart{
+			ResponseStart: &generated.ResponseStart{RequestId: &requestId, Status: &status},
+		},
+	}
+
+	tunnel.stream.received <- &generated.ClientMessage{
+		TestMessageType: &generated.ClientMessage_ResponseData{
+			ResponseData: &generated.ResponseData{RequestId: &requestId, Data: []byte("ok"), Completed: &completed},
+		},
+	}
+
+	<-done
+	tunnel.sync()
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.Code)
+	}
+
+	// Exports the pending spans.
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down tracing: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	webhook := findSpan(t, spans, "webhook")
+	forward := findSpan(t, spans, "forward")
+	tunnelSpan := findSpan(t, spans, "tunnel")
+
+	for _, span := range []tracetest.SpanStub{webhook, forward, tunnelSpan} {
+		if span.SpanContext.TraceID().String() != traceId {
+			t.Errorf("expected span %s to continue the trace of the caller, got %s", span.Name, span.SpanContext.TraceID())
+		}
+
+		// The endpoint is not part of the name, because the names must have a low cardinality.
+		if !hasAttribute(span, attribute.String("wh.endpoint", "users")) {
+			t.Errorf("expected span %s to have the endpoint, got %v", span.Name, span.Attributes)
+		}
+	}
+
+	if webhook.Parent.SpanID().String() != callerSpanId || forward.Parent.SpanID() != webhook.SpanContext.SpanID() || tunnelSpan.Parent.SpanID() != forward.SpanContext.SpanID() {
+		t.Errorf("expected the spans to be nested: webhook %s, forward %s, tunnel %s", webhook.Parent.SpanID(), forward.Parent.SpanID(), tunnelSpan.Parent.SpanID())
+	}
+
+	// The client continues the trace as child of the tunnel span.
+	expected := "00-" + traceId + "-" + tunnelSpan.SpanContext.SpanID().String() + "-01"
+	if actual := start.GetTraceContext()["traceparent"]; actual != expected {
+		t.Errorf("expected traceparent %s, got %s", expected, actual)
+	}
+}
@@ -1,49 +1,111 @@
 package tunnel
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 	generated "wh/domain/areas/tunnel/api/tunnel"
+	"wh/domain/metrics"
 	"wh/domain/publish"
+	"wh/domain/tracing"
 
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
 	EventOrigin = 13
 )
 
+// ErrTunnelClosed The tunnel has been closed before the request has been completed.
+var ErrTunnelClosed = errors.New("TunnelClosed")
+
 type Stream = grpc.BidiStreamingServer[generated.ClientMessage, generated.ServerMessage]
 
 type tunnelServer struct {
-	logger    *zap.Logger
-	publisher publish.Publisher
+	baseDomain string
+	lock       sync.Mutex
+	logger     *zap.Logger
+	metrics    metrics.Metrics
+	policies   publish.RecordingPolicies
+	publisher  publish.Publisher
+	shutdowns  map[chan time.Time]bool
+	tracing    tracing.Tracing
 	generated.UnimplementedWebhookServiceServer
 }
 
-func NewTunnelServer(publisher publish.Publisher, logger *zap.Logger) generated.WebhookServiceServer {
-	return &tunnelServer{logger: logger, publisher: publisher}
+type TunnelServer interface {
+	generated.WebhookServiceServer
+
+	// Shutdown notifies the connected clients that the server is shutting down and terminates the pending requests at the deadline.
+	Shutdown(deadline time.Time)
+}
+
+func NewTunnelServer(publisher publish.Publisher, policies publish.RecordingPolicies, metrics metrics.Metrics, tracing tracing.Tracing, config *viper.Viper, logger *zap.Logger) TunnelServer {
+	baseDomain := strings.TrimSuffix(strings.ToLower(config.GetString("http.baseDomain")), ".")
+
+	return &tunnelServer{baseDomain: baseDomain, logger: logger, metrics: metrics, policies: policies, publisher: publisher, shutdowns: make(map[chan time.Time]bool), tracing: tracing}
 }
 
 func (s *tunnelServer) Subscribe(stream Stream) error {
 	s.logger.Info("Tunnel opened by client.")
 
+	s.metrics.StreamOpened()
+	defer s.metrics.StreamClosed()
+
 	// Use one channel per type to have a type safe behavior.
 	clientError := make(chan *generated.TransportError)
+	flowControl := make(chan *generated.FlowControl)
 	requestData := make(chan publish.HttpRequestData)
 	requestStart := make(chan *publish.TunneledRequest)
 	responseData := make(chan *generated.ResponseData)
 	responseStart := make(chan *generated.ResponseStart)
 	serverError := make(chan publish.HttpError)
+	subscribed := make(chan bool)
 	unsubscribed := make(chan bool)
 
 	// Have a separate closed channel that is closed by the sender to avoid deadlocks.
 	closed := make(chan bool)
 
+	// Receives the deadline when the server is shutting down.
+	shutdown := make(chan time.Time, 1)
+	s.register(shutdown)
+	defer s.unregister(shutdown)
+
+	// Closed when the tunnel is disconnected by the server.
+	disconnected := make(chan bool)
+	disconnect := sync.OnceFunc(func() {
+		close(disconnected)
+	})
+
 	endpoint := ""
+	recording := false
+
+	// Release the endpoint only once, because another client can take it over after the client has unsubscribed.
+	unsubscribe := sync.OnceFunc(func() {
+		s.publisher.Unsubscribe(endpoint)
+
+		if endpoint != "" {
+			s.metrics.TunnelClosed()
+		}
+
+		if recording {
+			s.policies.SetSession(endpoint, nil)
+		}
+	})
+
 	defer func() {
 		s.logger.Info("Tunnel closes by client.")
 
@@ -51,39 +113,100 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 		// There is no guarantee that the channel stil has receivers, if it has already been completed.
 		unsubscribed <- true
 
-		s.publisher.Unsubscribe(endpoint)
+		unsubscribe()
 	}()
 
 	go func() {
+		// The confirmation must be the first message, therefore wait until it has been sent.
+		select {
+		case <-subscribed:
+		case <-unsubscribed:
+			close(closed)
+			return
+		}
+
 		// There is no weak map yet, therefore ensure to clean it up.
 		requests := make(map[string]*publish.TunneledRequest)
 
+		// The span of each request covers the round trip through the tunnel.
+		spans := make(map[string]trace.Span)
+
+		complete := func(requestId string, err error) {
+			delete(requests, requestId)
+
+			if span, ok := spans[requestId]; ok {
+				if err != nil {
+					span.SetStatus(codes.Error, err.Error())
+				}
+
+				span.End()
+				delete(spans, requestId)
+			}
+		}
+
 		for {
 			select {
 			case <-unsubscribed:
 				close(closed)
+
+				// Terminate the pending requests, otherwise the callers wait until the timeout.
+				for requestId, t := range requests {
+					complete(requestId, ErrTunnelClosed)
+
+					t.EmitError(EventOrigin, ErrTunnelClosed, false)
+				}
+
 				return
+			case deadline := <-shutdown:
+				deadlineMillis := deadline.UnixMilli()
+
+				m := &generated.ServerMessage{
+					TestMessageType: &generated.ServerMessage_Shutdown{
+						Shutdown: &generated.ShutdownNotice{
+							Deadline: &deadlineMillis,
+						},
+					},
+				}
+
+				if err := stream.Send(m); err != nil {
+					s.logger.Error("Could not notify client about shutdown.",
+						zap.Error(err),
+					)
+				}
+
 			case msg := <-requestStart:
 				request := msg.Request
 
+				ctx, span := s.tracing.Start(s.tracing.Extract(stream.Context(), propagation.MapCarrier(request.TraceContext)), "tunnel",
+					trace.WithSpanKind(trace.SpanKindProducer),
+					trace.WithAttributes(
+						attribute.String("wh.endpoint", endpoint),
+						attribute.String("wh.request_id", msg.RequestId),
+					),
+				)
+
 				m := &generated.ServerMessage{
 					TestMessageType: &generated.ServerMessage_RequestStart{
 						RequestStart: &generated.RequestStart{
-							RequestId: &msg.RequestId,
-							Endpoint:  &endpoint,
-							Path:      &request.Path,
-							Method:    &request.Method,
-							Headers:   toHeaders(request.Headers),
+							RequestId:    &msg.RequestId,
+							Endpoint:     &endpoint,
+							Path:         &request.Path,
+							Method:       &request.Method,
+							Headers:      toHeaders(request.Headers),
+							TraceContext: s.tracing.Inject(ctx),
 						},
 					},
 				}
 
 				if !s.sendMessage(stream, msg, m, true) {
+					span.SetStatus(codes.Error, ErrTunnelClosed.Error())
+					span.End()
 					break
 				}
 
 				// Only add the requests to the pending list when the request start has been sent successfully.
 				requests[msg.RequestId] = msg
+				spans[msg.RequestId] = span
 
 				s.logger.Info("Forwarding request to client.",
 					zap.String("input.endpoint", endpoint),
@@ -104,7 +227,13 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 
 				if !s.sendMessage(stream, msg.Request, m, true) {
 					// An error always terminates the request.
-					delete(requests, msg.Request.RequestId)
+					complete(msg.Request.RequestId, ErrTunnelClosed)
+				} else if msg.Completed {
+					msg.Request.MarkForwarded()
+
+					if span, ok := spans[msg.Request.RequestId]; ok {
+						span.AddEvent("request forwarded")
+					}
 				}
 
 			case msg := <-responseStart:
@@ -114,7 +243,13 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 					break
 				}
 
-				t.EmitResponse(EventOrigin, fromHeaders(msg.GetHeaders()), msg.GetStatus())
+				localDuration := time.Duration(msg.GetLocalDuration()) * time.Microsecond
+
+				t.EmitResponse(EventOrigin, fromHeaders(msg.GetHeaders()), msg.GetStatus(), localDuration)
+
+				if span, ok := spans[t.RequestId]; ok {
+					span.SetAttributes(attribute.Int("http.response.status_code", int(msg.GetStatus())))
+				}
 
 			case msg := <-responseData:
 				t, ok := requests[msg.GetRequestId()]
@@ -127,7 +262,7 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 
 				if msg.GetCompleted() {
 					// Default completion.
-					delete(requests, t.RequestId)
+					complete(t.RequestId, nil)
 				}
 
 			case msg := <-serverError:
@@ -141,11 +276,29 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 					},
 				}
 
+				reason := msg.Error
+				if reason == nil && msg.Timeout {
+					reason = context.DeadlineExceeded
+				}
+
 				// An error always terminates the request.
-				delete(requests, msg.Request.RequestId)
+				complete(msg.Request.RequestId, reason)
 
 				s.sendMessage(stream, msg.Request, m, false)
 
+			case msg := <-flowControl:
+				// The client might resume a request that has already been completed.
+				t, ok := requests[msg.GetRequestId()]
+				if !ok {
+					break
+				}
+
+				if msg.GetPaused() {
+					t.Pause()
+				} else {
+					t.Resume()
+				}
+
 			case msg := <-clientError:
 				t, ok := requests[msg.GetRequestId()]
 				if !ok {
@@ -153,26 +306,59 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 					break
 				}
 
+				err := errors.New(msg.GetError())
+
 				// An error always terminates the request.
-				delete(requests, t.RequestId)
+				complete(t.RequestId, err)
 
-				t.EmitError(EventOrigin, errors.New(msg.GetError()), true)
+				// Only a timeout of the local server is answered with 504, other errors of the client are failures.
+				t.EmitError(EventOrigin, err, msg.GetTimeout())
 			}
 		}
 	}()
 
-	for {
-		message, err := stream.Recv()
-		if err == io.EOF {
-			s.logger.Info("Tunnel stream closed by client.")
-			return nil
+	// Receive the messages in a separate goroutine, so that the server can close the tunnel.
+	received := make(chan *generated.ClientMessage)
+	receiveError := make(chan error, 1)
+	receiving := make(chan bool)
+	defer close(receiving)
+
+	go func() {
+		for {
+			message, err := stream.Recv()
+			if err != nil {
+				receiveError <- err
+				return
+			}
+
+			select {
+			case received <- message:
+			case <-receiving:
+				return
+			}
 		}
+	}()
+
+	for {
+		var message *generated.ClientMessage
+
+		select {
+		case <-disconnected:
+			s.logger.Info("Tunnel disconnected by server.",
+				zap.String("endpoint", endpoint),
+			)
+			return status.Errorf(grpccodes.Aborted, "Tunnel disconnected by server")
+		case err := <-receiveError:
+			if err == io.EOF {
+				s.logger.Info("Tunnel stream closed by client.")
+				return nil
+			}
 
-		if err != nil {
 			s.logger.Error("Tunnel stream interrupted with error.",
 				zap.Error(err),
 			)
 			return err
+		case message = <-received:
 		}
 
 		subscribeMessage := message.GetSubscribe()
@@ -181,9 +367,7 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 				return fmt.Errorf("you can only subscribe once. Current endpoint %s", endpoint)
 			}
 
-			endpoint = subscribeMessage.GetEndpoint()
-
-			if err := s.publisher.Subscribe(endpoint, func(request *publish.TunneledRequest) {
+			handler := func(request *publish.TunneledRequest) {
 				requestStart <- request
 
 				request.OnRequestData(EventOrigin, func(msg publish.HttpRequestData) {
@@ -199,9 +383,67 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 					case <-closed:
 					}
 				})
-			}); err != nil {
+			}
+
+			// Validate the policy before the endpoint is taken.
+			policy, err := fromRecordingPolicy(subscribeMessage.GetRecording())
+			if err != nil {
 				return err
 			}
+
+			apiKey := getApiKey(stream.Context())
+
+			info := publish.TunnelInfo{
+				ClientVersion: subscribeMessage.GetClientVersion(),
+				RemoteAddress: getRemoteAddress(stream.Context()),
+				Connected:     time.Now(),
+			}
+
+			if subscribeMessage.GetAuto() {
+				endpoint, err = s.publisher.SubscribeRandom(apiKey, info, handler, disconnect)
+			} else {
+				endpoint, err = subscribeMessage.GetEndpoint(), s.publisher.Subscribe(subscribeMessage.GetEndpoint(), apiKey, info, handler, disconnect)
+			}
+
+			if err != nil {
+				endpoint = ""
+				return err
+			}
+
+			s.metrics.TunnelOpened()
+
+			if policy != nil {
+				s.policies.SetSession(endpoint, policy)
+				recording = true
+			}
+
+			// The client cannot know how the server routes the requests.
+			host := ""
+			if s.baseDomain != "" {
+				host = endpoint + "." + s.baseDomain
+			}
+
+			m := &generated.ServerMessage{
+				TestMessageType: &generated.ServerMessage_Subscribed{
+					Subscribed: &generated.SubscribeResponse{
+						Endpoint: &endpoint,
+						Host:     &host,
+					},
+				},
+			}
+
+			if err := stream.Send(m); err != nil {
+				s.logger.Error("Could not confirm subscription to client.",
+					zap.Error(err),
+				)
+				return err
+			}
+
+			s.logger.Info("Tunnel subscribed to endpoint.",
+				zap.String("endpoint", endpoint),
+			)
+
+			close(subscribed)
 			continue
 		}
 
@@ -209,6 +451,15 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 			return fmt.Errorf("not subscribed yet")
 		}
 
+		// The stream stays open until the client has answered the pending requests.
+		if message.GetUnsubscribe() != nil {
+			s.logger.Info("Tunnel unsubscribed by client.",
+				zap.String("endpoint", endpoint),
+			)
+
+			unsubscribe()
+		}
+
 		if s := message.GetResponseStart(); s != nil {
 			select {
 			case responseStart <- s:
@@ -223,6 +474,13 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 			}
 		}
 
+		if f := message.GetFlowControl(); f != nil {
+			select {
+			case flowControl <- f:
+			case <-closed:
+			}
+		}
+
 		if e := message.GetError(); e != nil {
 			select {
 			case clientError <- e:
@@ -232,6 +490,47 @@ func (s *tunnelServer) Subscribe(stream Stream) error {
 	}
 }
 
+func (s *tunnelServer) Reserve(ctx context.Context, request *generated.ReserveRequest) (*generated.ReserveResponse, error) {
+	endpoint := request.GetEndpoint()
+
+	if err := s.publisher.Reserve(endpoint, getApiKey(ctx)); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Endpoint reserved by client.",
+		zap.String("endpoint", endpoint),
+	)
+
+	return &generated.ReserveResponse{Endpoint: &endpoint}, nil
+}
+
+func (s *tunnelServer) Shutdown(deadline time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for shutdown := range s.shutdowns {
+		// Never block, the tunnel has already been notified when the channel is full.
+		select {
+		case shutdown <- deadline:
+		default:
+		}
+	}
+}
+
+func (s *tunnelServer) register(shutdown chan time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.shutdowns[shutdown] = true
+}
+
+func (s *tunnelServer) unregister(shutdown chan time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.shutdowns, shutdown)
+}
+
 func (s *tunnelServer) logUnknownRequest(requestId string) {
 	s.logger.Error("Cannot find request.",
 		zap.String("requestId", requestId),
@@ -272,6 +571,24 @@ func fromHeaders(source map[string]*generated.HttpHeaderValues) http.Header {
 	return result
 }
 
+func fromRecordingPolicy(source *generated.RecordingPolicy) (*publish.RecordingPolicy, error) {
+	if source == nil {
+		return nil, nil
+	}
+
+	policy := &publish.RecordingPolicy{
+		Mode:        strings.ToLower(source.GetMode()),
+		MaxBodySize: int(source.GetMaxBodySize()),
+		SampleRate:  int(source.GetSampleRate()),
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
 func toError(err error) *string {
 	result := ""
 	if err != nil {
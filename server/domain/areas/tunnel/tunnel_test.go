@@ -0,0 +1,416 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+	generated "wh/domain/areas/tunnel/api/tunnel"
+	"wh/domain/metrics"
+	"wh/domain/publish"
+	"wh/domain/redaction"
+	"wh/domain/tracing"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// The origin of the events that are emitted by the tests.
+const testOrigin = 9999
+
+// Simulates the gRPC stream of a client without a network connection.
+type fakeStream struct {
+	grpc.ServerStream
+
+	ctx      context.Context
+	received chan *generated.ClientMessage
+	sent     chan *generated.ServerMessage
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeStream) Recv() (*generated.ClientMessage, error) {
+	select {
+	case m, ok := <-s.received:
+		if !ok {
+			return nil, io.EOF
+		}
+
+		return m, nil
+	case <-s.ctx.Done():
+		return nil, io.EOF
+	}
+}
+
+func (s *fakeStream) Send(m *generated.ServerMessage) error {
+	select {
+	case s.sent <- m:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// Waits for the next message of the server.
+func (s *fakeStream) next(t *testing.T) *generated.ServerMessage {
+	t.Helper()
+
+	select {
+	case m := <-s.sent:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a message from the server")
+		return nil
+	}
+}
+
+type testTunnel struct {
+	// Closed when the stream has been closed by the server.
+	done      chan bool
+	metrics   metrics.Metrics
+	publisher publish.Publisher
+	server    TunnelServer
+	stream    *fakeStream
+}
+
+// Creates a server with the in-memory implementations and subscribes a client to the endpoint.
+func newTestTunnel(t *testing.T, tracing tracing.Tracing, endpoint string) *testTunnel {
+	return newTestTunnelWithPublisher(t, tracing, endpoint, func(p publish.Publisher) publish.Publisher { return p })
+}
+
+func newTestTunnelWithPublisher(t *testing.T, tracing tracing.Tracing, endpoint string, wrap func(publish.Publisher) publish.Publisher) *testTunnel {
+	config := viper.New()
+	logger := zap.NewNop()
+
+	store := publish.NewMemoryStore(config)
+
+	policies, err := publish.NewRecordingPolicies(store, config)
+	if err != nil {
+		t.Fatalf("failed to create policies: %v", err)
+	}
+
+	redactor, err := redaction.NewRedactor(config)
+	if err != nil {
+		t.Fatalf("failed to create redactor: %v", err)
+	}
+
+	m := metrics.NewMetrics()
+
+	publisher := wrap(publish.NewPublisher(store, publish.NewMemoryBucket(config), publish.NewLimiter(config), policies, redactor, m, tracing, config, logger))
+	server := NewTunnelServer(publisher, policies, m, tracing, config, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream := &fakeStream{
+		ctx:      ctx,
+		received: make(chan *generated.ClientMessage),
+		sent:     make(chan *generated.ServerMessage, 10),
+	}
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		_ = server.Subscribe(stream)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	stream.received <- &generated.ClientMessage{
+		TestMessageType: &generated.ClientMessage_Subscribe{
+			Subscribe: &generated.SubscribeRequest{Endpoint: &endpoint},
+		},
+	}
+
+	if stream.next(t).GetSubscribed() == nil {
+		t.Fatal("expected the subscription to be confirmed")
+	}
+
+	return &testTunnel{done: done, metrics: m, publisher: publisher, server: server, stream: stream}
+}
+
+// Waits until the server has handled the previous messages of the client.
+// The messages are handed over one by one, therefore the first message has been handled when the second one is received.
+func (tunnel *testTunnel) sync() {
+	requestId := "sync"
+	paused := false
+
+	for i := 0; i < 2; i++ {
+		tunnel.stream.received <- &generated.ClientMessage{
+			TestMessageType: &generated.ClientMessage_FlowControl{
+				FlowControl: &generated.FlowControl{RequestId: &requestId, Paused: &paused},
+			},
+		}
+	}
+}
+
+func newDisabledTracing(t *testing.T) tracing.Tracing {
+	result, err := tracing.NewTracing(viper.New())
+	if err != nil {
+		t.Fatalf("failed to create tracing: %v", err)
+	}
+
+	return result
+}
+
+func TestTunnelForwardsClientTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  bool
+		expected publish.Status
+	}{
+		{name: "timeout", timeout: true, expected: publish.StatusTimeout},
+		{name: "error", timeout: false, expected: publish.StatusFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tunnel := newTestTunnel(t, newDisabledTracing(t), "users")
+
+			request, err := tunnel.publisher.ForwardRequest("users", publish.HttpRequestStart{Method: http.MethodGet, Path: "/", Headers: http.Header{}})
+			if err != nil {
+				t.Fatalf("failed to forward request: %v", err)
+			}
+
+			errors := make(chan publish.HttpError, 1)
+			request.OnError(publish.EventOrigin, func(msg publish.HttpError) {
+				errors <- msg
+			})
+
+			start := tunnel.stream.next(t).GetRequestStart()
+			if start == nil {
+				t.Fatal("expected the request to be sent to the client")
+			}
+
+			// The local server of the client did not answer in time.
+			requestId := start.GetRequestId()
+			message := "local server failed"
+			tunnel.stream.received <- &generated.ClientMessage{
+				TestMessageType: &generated.ClientMessage_Error{
+					Error: &generated.TransportError{RequestId: &requestId, Error: &message, Timeout: &test.timeout},
+				},
+			}
+
+			select {
+			case msg := <-errors:
+				if msg.Timeout != test.timeout || request.Status != test.expected {
+					t.Errorf("expected timeout %v and status %d, got %v and %d", test.timeout, test.expected, msg.Timeout, request.Status)
+				}
+
+				if msg.Error == nil || msg.Error.Error() != message {
+					t.Errorf("expected the error of the client, got %v", msg.Error)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected the error of the client")
+			}
+		})
+	}
+}
+
+func TestTunnelDisconnectedByServer(t *testing.T) {
+	tunnel := newTestTunnel(t, newDisabledTracing(t), "users")
+
+	request, err := tunnel.publisher.ForwardRequest("users", publish.HttpRequestStart{Method: http.MethodGet, Path: "/", Headers: http.Header{}})
+	if err != nil {
+		t.Fatalf("failed to forward request: %v", err)
+	}
+
+	// The listeners are called in order, therefore the counters have been updated when this one is called.
+	completed := make(chan bool, 1)
+	request.OnResponseData(testOrigin, func(msg publish.HttpResponseData) {
+		if msg.Completed {
+			completed <- true
+		}
+	})
+
+	start := tunnel.stream.next(t).GetRequestStart()
+	if start == nil {
+		t.Fatal("expected the request to be sent to the client")
+	}
+
+	// The body is sent like the API handler does it, because the client can only answer complete requests.
+	request.EmitRequestData(testOrigin, nil, true)
+
+	if tunnel.stream.next(t).GetRequestData() == nil {
+		t.Fatal("expected the request body to be sent to the client")
+	}
+
+	tunnels := tunnel.publisher.GetTunnels()
+	if len(tunnels) != 1 || tunnels[0].Endpoint != "users" || tunnels[0].InFlight != 1 || tunnels[0].Total != 1 {
+		t.Fatalf("expected one request in flight, got %+v", tunnels)
+	}
+
+	requestId := start.GetRequestId()
+	status := int32(http.StatusOK)
+	last := true
+
+	tunnel.stream.received <- &generated.ClientMessage{
+		TestMessageType: &generated.ClientMessage_ResponseStart{
+			ResponseStart: &generated.ResponseStart{RequestId: &requestId, Status: &status},
+		},
+	}
+	tunnel.stream.received <- &generated.ClientMessage{
+		TestMessageType: &generated.ClientMessage_ResponseData{
+			ResponseData: &generated.ResponseData{RequestId: &requestId, Data: []byte("ok"), Completed: &last},
+		},
+	}
+
+	select {
+	case <-completed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the response to be completed")
+	}
+
+	tunnels = tunnel.publisher.GetTunnels()
+	if len(tunnels) != 1 || tunnels[0].InFlight != 0 || tunnels[0].Total != 1 {
+		t.Fatalf("expected the request to be completed, got %+v", tunnels)
+	}
+
+	if err := tunnel.publisher.Disconnect("users"); err != nil {
+		t.Fatalf("failed to disconnect: %v", err)
+	}
+
+	select {
+	case <-tunnel.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to be closed")
+	}
+
+	if tunnels := tunnel.publisher.GetTunnels(); len(tunnels) != 0 {
+		t.Errorf("expected no tunnels, got %+v", tunnels)
+	}
+
+	if _, err := tunnel.publisher.ForwardRequest("users", publish.HttpRequestStart{Method: http.MethodGet, Path: "/", Headers: http.Header{}}); err != publish.ErrNotRegistered {
+		t.Errorf("expected %v, got %v", publish.ErrNotRegistered, err)
+	}
+
+	if err := tunnel.publisher.Disconnect("users"); err != publish.ErrNotRegistered {
+		t.Errorf("expected %v, got %v", publish.ErrNotRegistered, err)
+	}
+
+	// The endpoint has been released, therefore another client can take it over.
+	if err := tunnel.publisher.Subscribe("users", "", publish.TunnelInfo{}, func(*publish.TunneledRequest) {}, func() {}); err != nil {
+		t.Errorf("expected the endpoint to be free, got %v", err)
+	}
+}
+
+func TestTunnelShutdown(t *testing.T) {
+	tunnel := newTestTunnel(t, newDisabledTracing(t), "users")
+
+	request, err := tunnel.publisher.ForwardRequest("users", publish.HttpRequestStart{Method: http.MethodGet, Path: "/", Headers: http.Header{}})
+	if err != nil {
+		t.Fatalf("failed to forward request: %v", err)
+	}
+
+	if tunnel.stream.next(t).GetRequestStart() == nil {
+		t.Fatal("expected the request to be sent to the client")
+	}
+
+	errs := make(chan publish.HttpError, 1)
+	request.OnError(testOrigin, func(msg publish.HttpError) {
+		errs <- msg
+	})
+
+	deadline := time.Now().Add(100 * time.Millisecond)
+	tunnel.server.Shutdown(deadline)
+
+	notice := tunnel.stream.next(t).GetShutdown()
+	if notice == nil || notice.GetDeadline() != deadline.UnixMilli() {
+		t.Fatalf("expected the shutdown notice with the deadline, got %v", notice)
+	}
+
+	// The client does not complete the request before the deadline.
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	if err := tunnel.publisher.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	select {
+	case msg := <-errs:
+		if msg.Error != publish.ErrShuttingDown {
+			t.Errorf("expected %v, got %v", publish.ErrShuttingDown, msg.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the pending request to be terminated")
+	}
+
+	select {
+	case <-tunnel.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to be closed")
+	}
+}
+
+func TestTunnelUnsubscribeKeepsPendingRequests(t *testing.T) {
+	tunnel := newTestTunnel(t, newDisabledTracing(t), "users")
+
+	request, err := tunnel.publisher.ForwardRequest("users", publish.HttpRequestStart{Method: http.MethodGet, Path: "/", Headers: http.Header{}})
+	if err != nil {
+		t.Fatalf("failed to forward request: %v", err)
+	}
+
+	completed := make(chan bool, 1)
+	request.OnResponseData(testOrigin, func(msg publish.HttpResponseData) {
+		if msg.Completed {
+			completed <- true
+		}
+	})
+
+	start := tunnel.stream.next(t).GetRequestStart()
+	if start == nil {
+		t.Fatal("expected the request to be sent to the client")
+	}
+
+	request.EmitRequestData(testOrigin, nil, true)
+
+	if tunnel.stream.next(t).GetRequestData() == nil {
+		t.Fatal("expected the request body to be sent to the client")
+	}
+
+	tunnel.stream.received <- &generated.ClientMessage{
+		TestMessageType: &generated.ClientMessage_Unsubscribe{
+			Unsubscribe: &generated.UnsubscribeRequest{},
+		},
+	}
+
+	tunnel.sync()
+
+	// New requests are not routed to the client anymore.
+	if _, err := tunnel.publisher.ForwardRequest("users", publish.HttpRequestStart{Method: http.MethodGet, Path: "/", Headers: http.Header{}}); err != publish.ErrNotRegistered {
+		t.Errorf("expected %v, got %v", publish.ErrNotRegistered, err)
+	}
+
+	// The stream stays open for the answer of the pending request.
+	requestId := start.GetRequestId()
+	status := int32(http.StatusOK)
+	last := true
+
+	tunnel.stream.received <- &generated.ClientMessage{
+		TestMessageType: &generated.ClientMessage_ResponseStart{
+			ResponseStart: &generated.ResponseStart{RequestId: &requestId, Status: &status},
+		},
+	}
+	tunnel.stream.received <- &generated.ClientMessage{
+		TestMessageType: &generated.ClientMessage_ResponseData{
+			ResponseData: &generated.ResponseData{RequestId: &requestId, Data: []byte("ok"), Completed: &last},
+		},
+	}
+
+	select {
+	case <-completed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the response to be completed")
+	}
+
+	if request.Status != publish.StatusCompleted {
+		t.Errorf("expected status %d, got %d", publish.StatusCompleted, request.Status)
+	}
+}
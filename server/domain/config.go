@@ -11,10 +11,35 @@ func SetDefaultConfig(config *viper.Viper) {
 	config.SetDefault("auth.apiKey", "KEY")
 	config.SetDefault("auth.blockKey", "siTAgTsT51hkE64ltan7eCLbV9exuKIX")
 	config.SetDefault("auth.hashKey", "xTxxg9fCasLXVRGe5dvHTLO6zKGAaOKz")
+	config.SetDefault("encryption.keyFile", "")
+	config.SetDefault("encryption.rotationInterval", time.Hour)
 	config.SetDefault("grpc.address", "0.0.0.0:5010")
+	config.SetDefault("health.bucketsInterval", 30*time.Second)
+	config.SetDefault("health.timeout", 5*time.Second)
 	config.SetDefault("http.address", "0.0.0.0:5000")
+	config.SetDefault("http.baseDomain", "")
+	config.SetDefault("ipFilter.forwardedHeader", "xff")
+	config.SetDefault("ipFilter.presetsFile", "./configs/ip-presets.json")
+	config.SetDefault("ipFilter.trustedProxies", []string{})
 	config.SetDefault("log.maxEntries", 100)
 	config.SetDefault("log.maxSize", 100_000_000)
+	config.SetDefault("metrics.enabled", true)
+	config.SetDefault("metrics.requireAuth", true)
+	config.SetDefault("recording.maxBodySize", 0)
+	config.SetDefault("recording.mode", "full")
+	config.SetDefault("recording.sampleRate", 1)
 	config.SetDefault("request.maxSize", 10_000_000)
 	config.SetDefault("request.timeout", 30*time.Minute)
+	config.SetDefault("shutdown.timeout", 30*time.Second)
+	config.SetDefault("storage.compression", "none")
+	config.SetDefault("storage.type", "file")
+	config.SetDefault("storage.s3.partSize", 5*1024*1024)
+	config.SetDefault("storage.s3.uploadTimeout", time.Hour)
+	config.SetDefault("storage.s3.useSSL", true)
+	config.SetDefault("store.type", "sqlite")
+	config.SetDefault("tracing.enabled", false)
+	config.SetDefault("tracing.endpoint", "")
+	config.SetDefault("tracing.exporter", "otlp")
+	config.SetDefault("tracing.sampleRatio", 1.0)
+	config.SetDefault("tracing.serviceName", "wh")
 }
@@ -0,0 +1,167 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/spf13/viper"
+)
+
+// ErrUnknownKey The data has been encrypted with a key that is not configured anymore.
+var ErrUnknownKey = errors.New("Unknown key")
+
+// ErrInvalidData The encrypted data has been modified or is truncated.
+var ErrInvalidData = errors.New("Invalid encrypted data")
+
+var keyIdPattern = regexp.MustCompile("^[a-zA-Z0-9-]{1,64}$")
+
+type KeyConfig struct {
+	// The unique ID of the key, which is stored with the encrypted data.
+	Id string `json:"id"`
+
+	// The base64 encoded AES key with 16, 24 or 32 bytes.
+	Key string `json:"key"`
+}
+
+type Key struct {
+	Id   string
+	aead cipher.AEAD
+}
+
+type keyring struct {
+	current *Key
+	keys    map[string]*Key
+}
+
+type Keyring interface {
+	// Current returns the key for new data or nil if encryption is disabled.
+	Current() *Key
+
+	// Get returns the current or a previous key.
+	Get(id string) (*Key, error)
+}
+
+func NewKeyring(config *viper.Viper) (Keyring, error) {
+	configs := make([]KeyConfig, 0)
+	if err := config.UnmarshalKey("encryption.keys", &configs); err != nil {
+		return nil, err
+	}
+
+	fromFile, err := loadKeyFile(config.GetString("encryption.keyFile"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The first key is used to encrypt new data, all other keys are only used to decrypt existing data.
+	configs = append(configs, fromFile...)
+
+	result := &keyring{keys: make(map[string]*Key)}
+	for _, c := range configs {
+		key, err := newKey(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := result.keys[key.Id]; ok {
+			return nil, fmt.Errorf("duplicate encryption key '%s'", key.Id)
+		}
+
+		if result.current == nil {
+			result.current = key
+		}
+
+		result.keys[key.Id] = key
+	}
+
+	return result, nil
+}
+
+func (k keyring) Current() *Key {
+	return k.current
+}
+
+func (k keyring) Get(id string) (*Key, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+
+	return key, nil
+}
+
+// Seal encrypts the plaintext and prepends the random nonce.
+func (k *Key) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return k.aead.Seal(nonce, nonce, plaintext, []byte(k.Id)), nil
+}
+
+// Open decrypts the ciphertext that has been created by Seal.
+func (k *Key) Open(ciphertext []byte) ([]byte, error) {
+	nonceSize := k.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, ErrInvalidData
+	}
+
+	plaintext, err := k.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], []byte(k.Id))
+	if err != nil {
+		return nil, ErrInvalidData
+	}
+
+	return plaintext, nil
+}
+
+func newKey(c KeyConfig) (*Key, error) {
+	if !keyIdPattern.MatchString(c.Id) {
+		return nil, fmt.Errorf("invalid encryption key ID '%s', only letters, digits and hyphens are allowed", c.Id)
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key '%s' is not base64 encoded: %v", c.Id, err)
+	}
+
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key '%s': %v", c.Id, err)
+	}
+
+	return &Key{Id: c.Id, aead: aead}, nil
+}
+
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func loadKeyFile(file string) ([]KeyConfig, error) {
+	result := make([]KeyConfig, 0)
+	if file == "" {
+		return result, nil
+	}
+
+	jsonData, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption keys: %v", err)
+	}
+
+	if err := json.Unmarshal(jsonData, &result); err != nil {
+		return nil, fmt.Errorf("failed to convert encryption keys from JSON: %v", err)
+	}
+
+	return result, nil
+}
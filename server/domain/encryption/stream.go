@@ -0,0 +1,228 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+// Encrypted blobs start with the magic bytes, followed by the ID of the key and the data key, which is encrypted with this key.
+var blobMagic = []byte{0, 'W', 'H', 'E'}
+
+const (
+	// The body is encrypted in chunks, because AES-GCM cannot verify the data before the whole message has been read.
+	chunkSize = 64 * 1024
+
+	flagChunk byte = 0
+	flagFinal byte = 1
+)
+
+type encryptingWriter struct {
+	aead    cipher.AEAD
+	buffer  []byte
+	counter uint64
+	inner   io.Writer
+}
+
+type decryptingReader struct {
+	aead    cipher.AEAD
+	buffer  []byte
+	counter uint64
+	final   bool
+	inner   *bufio.Reader
+}
+
+// NewWriter encrypts the data with a new data key. The data is written unchanged if encryption is disabled. Close does not close the inner writer.
+func NewWriter(keyring Keyring, inner io.Writer) (io.WriteCloser, error) {
+	key := keyring.Current()
+	if key == nil {
+		return &nopWriteCloser{inner}, nil
+	}
+
+	// Envelope encryption: Each blob has its own key, which is protected by the configured key.
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := key.Seal(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := bytes.Buffer{}
+	header.Write(blobMagic)
+	header.WriteByte(byte(len(key.Id)))
+	header.WriteString(key.Id)
+	_ = binary.Write(&header, binary.BigEndian, uint16(len(wrappedKey)))
+	header.Write(wrappedKey)
+
+	if _, err := inner.Write(header.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{aead: aead, buffer: make([]byte, 0, chunkSize), inner: inner}, nil
+}
+
+// NewReader decrypts the data. Data that has been written before encryption was enabled is returned unchanged.
+func NewReader(keyring Keyring, inner io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(inner)
+
+	magic, err := buffered.Peek(len(blobMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, blobMagic) {
+		return buffered, nil
+	}
+
+	_, _ = buffered.Discard(len(blobMagic))
+
+	keyIdLength, err := buffered.ReadByte()
+	if err != nil {
+		return nil, ErrInvalidData
+	}
+
+	keyId := make([]byte, keyIdLength)
+	if _, err := io.ReadFull(buffered, keyId); err != nil {
+		return nil, ErrInvalidData
+	}
+
+	var wrappedKeyLength uint16
+	if err := binary.Read(buffered, binary.BigEndian, &wrappedKeyLength); err != nil {
+		return nil, ErrInvalidData
+	}
+
+	wrappedKey := make([]byte, wrappedKeyLength)
+	if _, err := io.ReadFull(buffered, wrappedKey); err != nil {
+		return nil, ErrInvalidData
+	}
+
+	key, err := keyring.Get(string(keyId))
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := key.Open(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptingReader{aead: aead, inner: buffered}, nil
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		n := copy(w.buffer[len(w.buffer):cap(w.buffer)], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		written += n
+		p = p[n:]
+
+		if len(w.buffer) == cap(w.buffer) {
+			if err := w.writeChunk(flagChunk); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (w *encryptingWriter) Close() error {
+	// The final chunk can be empty, but it must exist to detect truncated data.
+	return w.writeChunk(flagFinal)
+}
+
+func (w *encryptingWriter) writeChunk(flag byte) error {
+	ciphertext := w.aead.Seal(nil, chunkNonce(w.aead, w.counter), w.buffer, []byte{flag})
+
+	frame := make([]byte, 5, 5+len(ciphertext))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(ciphertext)))
+	frame = append(frame, ciphertext...)
+
+	if _, err := w.inner.Write(frame); err != nil {
+		return err
+	}
+
+	w.buffer = w.buffer[:0]
+	w.counter++
+	return nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.buffer) == 0 {
+		if r.final {
+			return 0, io.EOF
+		}
+
+		if err := r.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) readChunk() error {
+	frame := make([]byte, 5)
+	if _, err := io.ReadFull(r.inner, frame); err != nil {
+		// The stream has ended before the final chunk.
+		return ErrInvalidData
+	}
+
+	flag := frame[0]
+	length := binary.BigEndian.Uint32(frame[1:])
+	if length > chunkSize+uint32(r.aead.Overhead()) {
+		return ErrInvalidData
+	}
+
+	ciphertext := make([]byte, length)
+	if _, err := io.ReadFull(r.inner, ciphertext); err != nil {
+		return ErrInvalidData
+	}
+
+	plaintext, err := r.aead.Open(ciphertext[:0], chunkNonce(r.aead, r.counter), ciphertext, []byte{flag})
+	if err != nil {
+		return ErrInvalidData
+	}
+
+	r.buffer = plaintext
+	r.counter++
+	r.final = flag == flagFinal
+	return nil
+}
+
+// The data key is only used for a single blob, therefore a counter is a safe nonce.
+func chunkNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+
+	return nonce
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (w *nopWriteCloser) Close() error {
+	return nil
+}
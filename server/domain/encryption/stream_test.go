@@ -0,0 +1,227 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func newTestKeyring(t *testing.T, keys ...KeyConfig) Keyring {
+	t.Helper()
+
+	entries := make([]map[string]any, 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, map[string]any{"id": k.Id, "key": k.Key})
+	}
+
+	config := viper.New()
+	config.Set("encryption.keys", entries)
+
+	keyring, err := NewKeyring(config)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	return keyring
+}
+
+func newTestKey(id string, fill byte) KeyConfig {
+	return KeyConfig{Id: id, Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, 32))}
+}
+
+func encrypt(t *testing.T, keyring Keyring, plaintext []byte) []byte {
+	t.Helper()
+
+	var result bytes.Buffer
+
+	writer, err := NewWriter(keyring, &result)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+
+	// Write in odd sizes to cover the chunk boundaries.
+	for offset := 0; offset < len(plaintext); offset += 1000 {
+		if _, err := writer.Write(plaintext[offset:min(offset+1000, len(plaintext))]); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	return result.Bytes()
+}
+
+func decrypt(keyring Keyring, ciphertext []byte) ([]byte, error) {
+	reader, err := NewReader(keyring, bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+// Returns the offsets of the chunks after the header.
+func getFrames(t *testing.T, blob []byte) [][2]int {
+	t.Helper()
+
+	offset := len(blobMagic)
+	offset += 1 + int(blob[offset])
+	offset += 2 + int(binary.BigEndian.Uint16(blob[offset:]))
+
+	frames := make([][2]int, 0)
+	for offset < len(blob) {
+		length := 5 + int(binary.BigEndian.Uint32(blob[offset+1:]))
+		frames = append(frames, [2]int{offset, offset + length})
+		offset += length
+	}
+
+	return frames
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	keyring := newTestKeyring(t, newTestKey("key-1", 1))
+
+	sizes := []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 123}
+	for _, size := range sizes {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i % 253)
+		}
+
+		ciphertext := encrypt(t, keyring, plaintext)
+		if size > 16 && bytes.Contains(ciphertext, plaintext[:16]) {
+			t.Errorf("expected the data of size %d to be encrypted", size)
+		}
+
+		actual, err := decrypt(keyring, ciphertext)
+		if err != nil {
+			t.Fatalf("failed to decrypt size %d: %v", size, err)
+		}
+
+		if !bytes.Equal(actual, plaintext) {
+			t.Errorf("expected %d bytes, got %d different bytes", size, len(actual))
+		}
+	}
+}
+
+func TestStreamWithoutKey(t *testing.T) {
+	plaintext := []byte("stored before encryption was enabled")
+
+	// Encryption is disabled, therefore the data is written unchanged.
+	ciphertext := encrypt(t, newTestKeyring(t), plaintext)
+	if !bytes.Equal(ciphertext, plaintext) {
+		t.Fatalf("expected the plaintext, got %q", ciphertext)
+	}
+
+	// Unencrypted data is still readable after encryption has been enabled.
+	actual, err := decrypt(newTestKeyring(t, newTestKey("key-1", 1)), plaintext)
+	if err != nil || !bytes.Equal(actual, plaintext) {
+		t.Errorf("expected the plaintext, got %q, %v", actual, err)
+	}
+}
+
+func TestStreamPreviousKey(t *testing.T) {
+	ciphertext := encrypt(t, newTestKeyring(t, newTestKey("key-1", 1)), []byte("data"))
+
+	// The old key is still configured after a new key has been added.
+	actual, err := decrypt(newTestKeyring(t, newTestKey("key-2", 2), newTestKey("key-1", 1)), ciphertext)
+	if err != nil || string(actual) != "data" {
+		t.Errorf("expected the data, got %q, %v", actual, err)
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	keyring := newTestKeyring(t, newTestKey("key-1", 1))
+
+	ciphertext := encrypt(t, keyring, bytes.Repeat([]byte("x"), 2*chunkSize+10))
+	frames := getFrames(t, ciphertext)
+	if len(frames) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(frames))
+	}
+
+	cuts := map[string]int{
+		"without final chunk": frames[2][0],
+		"within a chunk":      frames[1][0] + 100,
+		"within a frame":      frames[1][0] + 2,
+		"after the header":    frames[0][0],
+	}
+
+	for name, cut := range cuts {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decrypt(keyring, ciphertext[:cut]); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("expected ErrInvalidData, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStreamReordered(t *testing.T) {
+	keyring := newTestKeyring(t, newTestKey("key-1", 1))
+
+	plaintext := append(bytes.Repeat([]byte("a"), chunkSize), bytes.Repeat([]byte("b"), chunkSize)...)
+	ciphertext := encrypt(t, keyring, plaintext)
+
+	frames := getFrames(t, ciphertext)
+	first := ciphertext[frames[0][0]:frames[0][1]]
+	second := ciphertext[frames[1][0]:frames[1][1]]
+
+	// Both chunks have the same size, therefore only the nonce detects the swap.
+	var reordered bytes.Buffer
+	reordered.Write(ciphertext[:frames[0][0]])
+	reordered.Write(second)
+	reordered.Write(first)
+	reordered.Write(ciphertext[frames[1][1]:])
+
+	if _, err := decrypt(keyring, reordered.Bytes()); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("expected ErrInvalidData, got %v", err)
+	}
+}
+
+func TestStreamModified(t *testing.T) {
+	keyring := newTestKeyring(t, newTestKey("key-1", 1))
+
+	ciphertext := encrypt(t, keyring, []byte("data"))
+	frames := getFrames(t, ciphertext)
+
+	t.Run("ciphertext", func(t *testing.T) {
+		modified := bytes.Clone(ciphertext)
+		modified[frames[0][0]+6] ^= 1
+
+		if _, err := decrypt(keyring, modified); !errors.Is(err, ErrInvalidData) {
+			t.Errorf("expected ErrInvalidData, got %v", err)
+		}
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		// Marking the final chunk as a normal chunk must be detected, because the flag is authenticated.
+		modified := bytes.Clone(ciphertext)
+		modified[frames[0][0]] = flagChunk
+
+		if _, err := decrypt(keyring, modified); !errors.Is(err, ErrInvalidData) {
+			t.Errorf("expected ErrInvalidData, got %v", err)
+		}
+	})
+}
+
+func TestStreamWrongKey(t *testing.T) {
+	ciphertext := encrypt(t, newTestKeyring(t, newTestKey("key-1", 1)), []byte("data"))
+
+	t.Run("same ID with another secret", func(t *testing.T) {
+		if _, err := decrypt(newTestKeyring(t, newTestKey("key-1", 2)), ciphertext); !errors.Is(err, ErrInvalidData) {
+			t.Errorf("expected ErrInvalidData, got %v", err)
+		}
+	})
+
+	t.Run("removed key", func(t *testing.T) {
+		if _, err := decrypt(newTestKeyring(t, newTestKey("key-2", 2)), ciphertext); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("expected ErrUnknownKey, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// Encrypted values have the format "enc:<keyId>:<base64 ciphertext>".
+const valuePrefix = "enc:"
+
+// EncryptString encrypts the value with the current key or returns the value unchanged if encryption is disabled.
+func EncryptString(keyring Keyring, value string) (string, error) {
+	key := keyring.Current()
+	if key == nil {
+		return value, nil
+	}
+
+	ciphertext, err := key.Seal([]byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return ValuePrefix(key) + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptString decrypts the value. Values that have been stored before encryption was enabled are returned unchanged.
+func DecryptString(keyring Keyring, value string) (string, error) {
+	if !strings.HasPrefix(value, valuePrefix) {
+		return value, nil
+	}
+
+	keyId, encoded, ok := strings.Cut(value[len(valuePrefix):], ":")
+	if !ok {
+		return "", ErrInvalidData
+	}
+
+	key, err := keyring.Get(keyId)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidData
+	}
+
+	plaintext, err := key.Open(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// ValuePrefix returns the prefix of all values that have been encrypted with the given key.
+func ValuePrefix(key *Key) string {
+	return valuePrefix + key.Id + ":"
+}
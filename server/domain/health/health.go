@@ -0,0 +1,156 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"wh/domain/publish"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type Status = string
+
+const (
+	// StatusOk The dependency works.
+	StatusOk Status = "ok"
+
+	// StatusFailed The dependency does not work.
+	StatusFailed Status = "failed"
+)
+
+// ErrNotServing The gRPC server does not accept tunnels anymore.
+var ErrNotServing = errors.New("NotServing")
+
+type Check struct {
+	// The name of the dependency.
+	Name string `json:"name"`
+
+	// The result of the check.
+	Status Status `json:"status"`
+
+	// The reason why the check failed.
+	Error string `json:"error,omitempty"`
+}
+
+type health struct {
+	buckets         publish.Buckets
+	bucketsChecked  time.Time
+	bucketsInterval time.Duration
+	bucketsLock     sync.Mutex
+	bucketsResult   error
+	grpc            healthpb.HealthServer
+	now             func() time.Time
+	store           publish.Store
+	timeout         time.Duration
+}
+
+type Health interface {
+	// Ready checks the dependencies and returns whether all of them work.
+	Ready(ctx context.Context) ([]Check, bool)
+}
+
+func NewHealth(store publish.Store, buckets publish.Buckets, grpc healthpb.HealthServer, config *viper.Viper) Health {
+	return &health{
+		buckets:         buckets,
+		bucketsInterval: config.GetDuration("health.bucketsInterval"),
+		grpc:            grpc,
+		now:             time.Now,
+		store:           store,
+		timeout:         config.GetDuration("health.timeout"),
+	}
+}
+
+func (h *health) Ready(ctx context.Context) ([]Check, bool) {
+	checks := []Check{
+		h.run(ctx, "store", h.store.Ping),
+		h.run(ctx, "buckets", h.checkBuckets),
+		h.run(ctx, "grpc", h.checkGrpc),
+	}
+
+	ready := true
+	for _, c := range checks {
+		if c.Status != StatusOk {
+			ready = false
+		}
+	}
+
+	return checks, ready
+}
+
+func (h *health) run(ctx context.Context, name string, check func(ctx context.Context) error) Check {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	// Not all dependencies support a context, therefore do not wait for them longer than the timeout.
+	result := make(chan error, 1)
+	go func() {
+		result <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		return Check{Name: name, Status: StatusFailed, Error: err.Error()}
+	}
+
+	return Check{Name: name, Status: StatusOk}
+}
+
+// Writes and deletes a small blob, because a bucket can be readable, but not writable.
+// The probes can be called without authentication, therefore the result is reused for a while.
+func (h *health) checkBuckets(ctx context.Context) error {
+	h.bucketsLock.Lock()
+	defer h.bucketsLock.Unlock()
+
+	now := h.now()
+	if !h.bucketsChecked.IsZero() && now.Sub(h.bucketsChecked) < h.bucketsInterval {
+		return h.bucketsResult
+	}
+
+	h.bucketsResult = h.probeBuckets()
+	h.bucketsChecked = now
+
+	return h.bucketsResult
+}
+
+func (h *health) probeBuckets() error {
+	requestId := "health-" + uuid.New().String()
+
+	writer, err := h.buckets.OpenRequestWriter(requestId)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write([]byte("ok"))
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+
+	if deleteErr := h.buckets.Delete(requestId); err == nil {
+		err = deleteErr
+	}
+
+	return err
+}
+
+func (h *health) checkGrpc(ctx context.Context) error {
+	response, err := h.grpc.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+
+	if response.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return ErrNotServing
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+	"wh/domain/publish"
+
+	"github.com/spf13/viper"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+type failingStore struct {
+	publish.Store
+}
+
+func (s failingStore) Ping(ctx context.Context) error {
+	return errUnavailable
+}
+
+// Counts the probes and fails while the bucket is not writable.
+type countingBuckets struct {
+	publish.Buckets
+
+	err    error
+	probes int
+}
+
+func (b *countingBuckets) OpenRequestWriter(requestId string) (io.WriteCloser, error) {
+	b.probes++
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return b.Buckets.OpenRequestWriter(requestId)
+}
+
+func newTestHealth(store publish.Store, buckets publish.Buckets, grpc healthpb.HealthServer) *health {
+	config := viper.New()
+	config.Set("health.bucketsInterval", 30*time.Second)
+	config.Set("health.timeout", time.Second)
+
+	return NewHealth(store, buckets, grpc, config).(*health)
+}
+
+func TestReady(t *testing.T) {
+	notServing := grpchealth.NewServer()
+	notServing.Shutdown()
+
+	tests := []struct {
+		name     string
+		store    publish.Store
+		buckets  publish.Buckets
+		grpc     healthpb.HealthServer
+		expected map[string]Status
+	}{
+		{
+			name:     "ok",
+			store:    publish.NewMemoryStore(viper.New()),
+			buckets:  publish.NewMemoryBucket(viper.New()),
+			grpc:     grpchealth.NewServer(),
+			expected: map[string]Status{"store": StatusOk, "buckets": StatusOk, "grpc": StatusOk},
+		},
+		{
+			name:     "failing store",
+			store:    failingStore{publish.NewMemoryStore(viper.New())},
+			buckets:  publish.NewMemoryBucket(viper.New()),
+			grpc:     grpchealth.NewServer(),
+			expected: map[string]Status{"store": StatusFailed, "buckets": StatusOk, "grpc": StatusOk},
+		},
+		{
+			name:     "failing buckets",
+			store:    publish.NewMemoryStore(viper.New()),
+			buckets:  &countingBuckets{Buckets: publish.NewMemoryBucket(viper.New()), err: errUnavailable},
+			grpc:     grpchealth.NewServer(),
+			expected: map[string]Status{"store": StatusOk, "buckets": StatusFailed, "grpc": StatusOk},
+		},
+		{
+			name:     "not serving",
+			store:    publish.NewMemoryStore(viper.New()),
+			buckets:  publish.NewMemoryBucket(viper.New()),
+			grpc:     notServing,
+			expected: map[string]Status{"store": StatusOk, "buckets": StatusOk, "grpc": StatusFailed},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checks, ready := newTestHealth(test.store, test.buckets, test.grpc).Ready(context.Background())
+
+			if len(checks) != len(test.expected) {
+				t.Fatalf("expected %d checks, got %v", len(test.expected), checks)
+			}
+
+			expectedReady := true
+			for _, c := range checks {
+				if c.Status != test.expected[c.Name] {
+					t.Errorf("expected %s to be %s, got %s", c.Name, test.expected[c.Name], c.Status)
+				}
+
+				if (c.Status == StatusFailed) != (c.Error != "") {
+					t.Errorf("expected the error only for failed checks, got %+v", c)
+				}
+
+				expectedReady = expectedReady && test.expected[c.Name] == StatusOk
+			}
+
+			if ready != expectedReady {
+				t.Errorf("expected ready %v, got %v", expectedReady, ready)
+			}
+		})
+	}
+}
+
+func TestReadyReusesBucketsResult(t *testing.T) {
+	buckets := &countingBuckets{Buckets: publish.NewMemoryBucket(viper.New()), err: errUnavailable}
+
+	h := newTestHealth(publish.NewMemoryStore(viper.New()), buckets, grpchealth.NewServer())
+
+	now := time.Now()
+	h.now = func() time.Time { return now }
+
+	if _, ready := h.Ready(context.Background()); ready {
+		t.Fatal("expected the failing bucket to be reported")
+	}
+
+	// The bucket has recovered, but the result is reused within the interval.
+	buckets.err = nil
+	now = now.Add(29 * time.Second)
+
+	if _, ready := h.Ready(context.Background()); ready || buckets.probes != 1 {
+		t.Fatalf("expected the cached result after %d probes, got ready %v", buckets.probes, ready)
+	}
+
+	now = now.Add(time.Second)
+
+	if _, ready := h.Ready(context.Background()); !ready || buckets.probes != 2 {
+		t.Errorf("expected a new probe after %d probes, got ready %v", buckets.probes, ready)
+	}
+}
@@ -0,0 +1,206 @@
+package ipfilter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ErrDenied The client IP is not allowed to call the endpoint.
+var ErrDenied = errors.New("Denied")
+
+type rules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+type ipFilter struct {
+	endpoints      map[string]*rules
+	forwardedFor   forwardedFor
+	trustedProxies []netip.Prefix
+}
+
+type IPFilter interface {
+	// ClientIP resolves the IP of the original client, even if the request has been forwarded by trusted proxies.
+	ClientIP(request *http.Request) (netip.Addr, bool)
+
+	// Check validates whether the request is allowed to call the endpoint.
+	Check(endpoint string, request *http.Request) error
+}
+
+func NewIPFilter(config *viper.Viper) (IPFilter, error) {
+	trustedProxies, err := parsePrefixes(config.GetStringSlice("ipFilter.trustedProxies"))
+	if err != nil {
+		return nil, err
+	}
+
+	forwardedFor, err := newForwardedFor(config.GetString("ipFilter.forwardedHeader"))
+	if err != nil {
+		return nil, err
+	}
+
+	presets, err := loadPresets(config.GetString("ipFilter.presetsFile"))
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string]*rules)
+
+	for endpoint := range config.GetStringMap("endpoints") {
+		section := config.Sub("endpoints." + endpoint + ".ipFilter")
+		if section == nil {
+			continue
+		}
+
+		allow, err := parsePrefixes(section.GetStringSlice("allow"))
+		if err != nil {
+			return nil, err
+		}
+
+		deny, err := parsePrefixes(section.GetStringSlice("deny"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range section.GetStringSlice("presets") {
+			preset, ok := presets[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown IP preset '%s' for endpoint '%s'", name, endpoint)
+			}
+
+			allow = append(allow, preset...)
+		}
+
+		endpoints[endpoint] = &rules{allow: allow, deny: deny}
+	}
+
+	return &ipFilter{endpoints: endpoints, forwardedFor: forwardedFor, trustedProxies: trustedProxies}, nil
+}
+
+func (f ipFilter) Check(endpoint string, request *http.Request) error {
+	// Viper keys are not case sensitive.
+	r, ok := f.endpoints[strings.ToLower(endpoint)]
+	if !ok {
+		return nil
+	}
+
+	ip, ok := f.ClientIP(request)
+	if !ok {
+		if len(r.allow) > 0 {
+			return fmt.Errorf("%w: client IP cannot be resolved", ErrDenied)
+		}
+
+		return nil
+	}
+
+	if contains(r.deny, ip) {
+		return fmt.Errorf("%w: %s is on the deny list", ErrDenied, ip)
+	}
+
+	if len(r.allow) > 0 && !contains(r.allow, ip) {
+		return fmt.Errorf("%w: %s is not on the allow list", ErrDenied, ip)
+	}
+
+	return nil
+}
+
+func (f ipFilter) ClientIP(request *http.Request) (netip.Addr, bool) {
+	remote, ok := parseAddr(request.RemoteAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+
+	// Only trust the headers when they have been set by our own proxies.
+	if !contains(f.trustedProxies, remote) {
+		return remote, true
+	}
+
+	hops := f.forwardedFor(request.Header)
+
+	// The last hops have been added by our own proxies, the first untrusted hop is the client.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseAddr(hops[i])
+		if !ok {
+			return netip.Addr{}, false
+		}
+
+		if !contains(f.trustedProxies, hop) {
+			return hop, true
+		}
+
+		remote = hop
+	}
+
+	return remote, true
+}
+
+func loadPresets(file string) (map[string][]netip.Prefix, error) {
+	result := make(map[string][]netip.Prefix)
+	if file == "" {
+		return result, nil
+	}
+
+	// A missing file is an error, the presets are disabled with an empty file name.
+	jsonData, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IP presets: %v", err)
+	}
+
+	presets := make(map[string][]string)
+	if err := json.Unmarshal(jsonData, &presets); err != nil {
+		return nil, fmt.Errorf("failed to convert IP presets from JSON: %v", err)
+	}
+
+	for name, values := range presets {
+		prefixes, err := parsePrefixes(values)
+		if err != nil {
+			return nil, err
+		}
+
+		result[strings.ToLower(name)] = prefixes
+	}
+
+	return result, nil
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	result := make([]netip.Prefix, 0, len(values))
+
+	for _, value := range values {
+		// Also allow single addresses, which is more natural for most providers.
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP address '%s': %v", value, err)
+			}
+
+			result = append(result, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP range '%s': %v", value, err)
+		}
+
+		result = append(result, prefix.Masked())
+	}
+
+	return result, nil
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,181 @@
+package ipfilter
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func newTestFilter(t *testing.T, forwardedHeader string) IPFilter {
+	config := viper.New()
+	config.Set("ipFilter.forwardedHeader", forwardedHeader)
+	config.Set("ipFilter.trustedProxies", []string{"10.0.0.0/8", "fd00::/8"})
+	config.Set("endpoints.github.ipFilter.allow", []string{"203.0.113.0/24", "2001:db8::/32"})
+	config.Set("endpoints.github.ipFilter.deny", []string{"203.0.113.7"})
+	config.Set("endpoints.open.ipFilter.deny", []string{"198.51.100.1"})
+
+	filter, err := NewIPFilter(config)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	return filter
+}
+
+func newTestRequest(remote string, headers map[string][]string) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	request.RemoteAddr = remote
+
+	for name, values := range headers {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+
+	return request
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		remote   string
+		headers  map[string][]string
+		expected string
+	}{
+		{name: "direct", remote: "203.0.113.1:1234", expected: "203.0.113.1"},
+		{name: "untrusted remote with spoofed header", remote: "198.51.100.1:1234", headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1"}}, expected: "198.51.100.1"},
+		{name: "untrusted remote with spoofed forwarded", header: "forwarded", remote: "198.51.100.1:1234", headers: map[string][]string{"Forwarded": {"for=203.0.113.1"}}, expected: "198.51.100.1"},
+		{name: "trusted proxy", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1"}}, expected: "203.0.113.1"},
+		{name: "multiple trusted hops", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1, 10.0.0.3, 10.0.0.2"}}, expected: "203.0.113.1"},
+		{name: "multiple header lines", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1", "10.0.0.2"}}, expected: "203.0.113.1"},
+		{name: "spoofed first hop", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1, 198.51.100.1"}}, expected: "198.51.100.1"},
+		{name: "only trusted hops", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, expected: "10.0.0.3"},
+		{name: "without header", remote: "10.0.0.1:1234", expected: "10.0.0.1"},
+		{name: "forwarded ignored for xff", remote: "10.0.0.1:1234", headers: map[string][]string{"Forwarded": {"for=203.0.113.1"}, "X-Forwarded-For": {"198.51.100.1"}}, expected: "198.51.100.1"},
+		{name: "xff ignored for forwarded", header: "forwarded", remote: "10.0.0.1:1234", headers: map[string][]string{"Forwarded": {"for=198.51.100.1"}, "X-Forwarded-For": {"203.0.113.1"}}, expected: "198.51.100.1"},
+		{name: "forwarded with trusted hops", header: "forwarded", remote: "10.0.0.1:1234", headers: map[string][]string{"Forwarded": {"for=203.0.113.1;proto=https, for=10.0.0.2"}}, expected: "203.0.113.1"},
+		{name: "forwarded ipv6 with port", header: "forwarded", remote: "[fd00::1]:1234", headers: map[string][]string{"Forwarded": {`for="[2001:db8::1]:4711"`}}, expected: "2001:db8::1"},
+		{name: "ipv6 remote", remote: "[2001:db8::1]:1234", expected: "2001:db8::1"},
+		{name: "ipv6 hop with brackets", remote: "[fd00::1]:1234", headers: map[string][]string{"X-Forwarded-For": {"[2001:db8::1]"}}, expected: "2001:db8::1"},
+		{name: "ipv6 hop with port", remote: "[fd00::1]:1234", headers: map[string][]string{"X-Forwarded-For": {"[2001:db8::1]:4711, fd00::2"}}, expected: "2001:db8::1"},
+		{name: "ipv4 hop with port", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1:4711"}}, expected: "203.0.113.1"},
+		{name: "ipv4 mapped", remote: "[::ffff:203.0.113.1]:1234", expected: "203.0.113.1"},
+		{name: "unparsable hop", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1, unknown"}}, expected: ""},
+		{name: "obfuscated forwarded hop", header: "forwarded", remote: "10.0.0.1:1234", headers: map[string][]string{"Forwarded": {"for=_hidden"}}, expected: ""},
+		{name: "unparsable remote", remote: "pipe", expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := newTestFilter(t, test.header)
+
+			ip, ok := filter.ClientIP(newTestRequest(test.remote, test.headers))
+			if test.expected == "" {
+				if ok {
+					t.Errorf("expected no client IP, got %s", ip)
+				}
+
+				return
+			}
+
+			if !ok || ip.String() != test.expected {
+				t.Errorf("expected %s, got %s (%v)", test.expected, ip, ok)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		remote   string
+		headers  map[string][]string
+		denied   bool
+	}{
+		{name: "allowed", endpoint: "github", remote: "203.0.113.1:1234"},
+		{name: "allowed ipv6", endpoint: "github", remote: "[2001:db8::1]:1234"},
+		{name: "not allowed", endpoint: "github", remote: "198.51.100.1:1234", denied: true},
+		{name: "deny wins over allow", endpoint: "github", remote: "203.0.113.7:1234", denied: true},
+		{name: "endpoint not case sensitive", endpoint: "GitHub", remote: "198.51.100.1:1234", denied: true},
+		{name: "spoofed header", endpoint: "github", remote: "198.51.100.1:1234", headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1"}}, denied: true},
+		{name: "spoofed forwarded behind proxy", endpoint: "github", remote: "10.0.0.1:1234", headers: map[string][]string{"Forwarded": {"for=203.0.113.1"}, "X-Forwarded-For": {"198.51.100.1"}}, denied: true},
+		{name: "forwarded by proxy", endpoint: "github", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1"}}},
+		{name: "denied by proxy", endpoint: "github", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, denied: true},
+		{name: "unresolved with allow list", endpoint: "github", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"unknown"}}, denied: true},
+		{name: "unresolved without allow list", endpoint: "open", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"unknown"}}},
+		{name: "deny list only", endpoint: "open", remote: "198.51.100.1:1234", denied: true},
+		{name: "other address with deny list only", endpoint: "open", remote: "203.0.113.1:1234"},
+		{name: "endpoint without filter", endpoint: "users", remote: "198.51.100.1:1234"},
+	}
+
+	filter := newTestFilter(t, "xff")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := filter.Check(test.endpoint, newTestRequest(test.remote, test.headers))
+
+			if test.denied && !errors.Is(err, ErrDenied) {
+				t.Errorf("expected the request to be denied, got %v", err)
+			} else if !test.denied && err != nil {
+				t.Errorf("expected the request to be allowed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPresets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "presets.json")
+	if err := os.WriteFile(file, []byte(`{"GitHub": ["192.30.252.0/22"]}`), 0644); err != nil {
+		t.Fatalf("failed to write presets: %v", err)
+	}
+
+	config := viper.New()
+	config.Set("ipFilter.presetsFile", file)
+	config.Set("endpoints.github.ipFilter.presets", []string{"github"})
+
+	filter, err := NewIPFilter(config)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	if err := filter.Check("github", newTestRequest("192.30.252.1:1234", nil)); err != nil {
+		t.Errorf("expected the preset to be allowed, got %v", err)
+	}
+
+	if err := filter.Check("github", newTestRequest("198.51.100.1:1234", nil)); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected other addresses to be denied, got %v", err)
+	}
+}
+
+func TestNewIPFilterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]any
+	}{
+		{name: "missing presets file", values: map[string]any{"ipFilter.presetsFile": filepath.Join(t.TempDir(), "missing.json")}},
+		{name: "unknown preset", values: map[string]any{"endpoints.github.ipFilter.presets": []string{"unknown"}}},
+		{name: "unknown forwarded header", values: map[string]any{"ipFilter.forwardedHeader": "x-real-ip"}},
+		{name: "invalid trusted proxy", values: map[string]any{"ipFilter.trustedProxies": []string{"10.0.0.0/33"}}},
+		{name: "invalid address", values: map[string]any{"endpoints.github.ipFilter.allow": []string{"203.0.113"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := viper.New()
+			for key, value := range test.values {
+				config.Set(key, value)
+			}
+
+			if _, err := NewIPFilter(config); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Returns the forwarded addresses from the first to the last proxy.
+type forwardedFor func(headers http.Header) []string
+
+// Only one header is evaluated, otherwise a client can send the header that the proxies do not set to spoof its IP.
+func newForwardedFor(header string) (forwardedFor, error) {
+	switch strings.ToLower(header) {
+	case "", "xff":
+		return getXForwardedFor, nil
+	case "forwarded":
+		return getForwarded, nil
+	}
+
+	return nil, fmt.Errorf("unknown forwarded header '%s', expected 'xff' or 'forwarded'", header)
+}
+
+func getXForwardedFor(headers http.Header) []string {
+	result := make([]string, 0)
+
+	for _, header := range headers.Values("X-Forwarded-For") {
+		for _, value := range strings.Split(header, ",") {
+			result = append(result, strings.TrimSpace(value))
+		}
+	}
+
+	return result
+}
+
+// The standardized header (RFC 7239).
+func getForwarded(headers http.Header) []string {
+	result := make([]string, 0)
+
+	for _, header := range headers.Values("Forwarded") {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					result = append(result, strings.Trim(value, "\""))
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+func parseAddr(value string) (netip.Addr, bool) {
+	// The value can contain a port and IPv6 addresses are then wrapped in brackets.
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "wh"
+
+type metrics struct {
+	grpcStreamsActive prometheus.Gauge
+	grpcStreamsTotal  prometheus.Counter
+	recorderErrors    *prometheus.CounterVec
+	registry          *prometheus.Registry
+	requestBytes      *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	requests          *prometheus.CounterVec
+	requestsInFlight  prometheus.Gauge
+	responseBytes     *prometheus.CounterVec
+	storeErrors       *prometheus.CounterVec
+	tunnelsActive     prometheus.Gauge
+}
+
+type Metrics interface {
+	// Handler returns the HTTP handler that exposes the metrics in the Prometheus exposition format.
+	Handler() http.Handler
+
+	// ObserveRequest records a webhook call once the response has been sent to the caller.
+	ObserveRequest(endpoint string, status int, bytesIn int64, bytesOut int64, duration time.Duration)
+
+	// RequestStarted increments the number of tunneled requests in flight.
+	RequestStarted()
+
+	// RequestEnded decrements the number of tunneled requests in flight.
+	RequestEnded()
+
+	// TunnelOpened increments the number of subscribed tunnels.
+	TunnelOpened()
+
+	// TunnelClosed decrements the number of subscribed tunnels.
+	TunnelClosed()
+
+	// StreamOpened increments the number of gRPC streams.
+	StreamOpened()
+
+	// StreamClosed decrements the number of active gRPC streams.
+	StreamClosed()
+
+	// RecorderError counts a failure to record a body.
+	RecorderError(operation string)
+
+	// StoreError counts a failure to write to the store.
+	StoreError(operation string)
+}
+
+func NewMetrics() Metrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	m := &metrics{
+		grpcStreamsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "grpc_streams_active",
+			Help:      "The number of open gRPC streams.",
+		}),
+		grpcStreamsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_streams_total",
+			Help:      "The total number of gRPC streams that have been opened.",
+		}),
+		recorderErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "recorder_errors_total",
+			Help:      "The total number of failures to record request and response bodies.",
+		}, []string{"operation"}),
+		registry: registry,
+		requestBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "request_bytes_total",
+			Help:      "The total number of body bytes received from webhook callers.",
+		}, []string{"endpoint"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "The time from receiving a webhook call until the response has been sent.",
+			// Webhooks are often slower than normal API calls, because the local server is behind a tunnel.
+			Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"endpoint"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "The total number of webhook calls by endpoint and status class.",
+		}, []string{"endpoint", "status"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "requests_in_flight",
+			Help:      "The number of requests that are currently forwarded through a tunnel.",
+		}),
+		responseBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "response_bytes_total",
+			Help:      "The total number of body bytes sent to webhook callers.",
+		}, []string{"endpoint"}),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_errors_total",
+			Help:      "The total number of failures to write to the store.",
+		}, []string{"operation"}),
+		tunnelsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "tunnels_active",
+			Help:      "The number of clients that are subscribed to an endpoint.",
+		}),
+	}
+
+	registry.MustRegister(
+		m.grpcStreamsActive,
+		m.grpcStreamsTotal,
+		m.recorderErrors,
+		m.requestBytes,
+		m.requestDuration,
+		m.requests,
+		m.requestsInFlight,
+		m.responseBytes,
+		m.storeErrors,
+		m.tunnelsActive,
+	)
+
+	return m
+}
+
+func (m *metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *metrics) ObserveRequest(endpoint string, status int, bytesIn int64, bytesOut int64, duration time.Duration) {
+	m.requests.WithLabelValues(endpoint, statusClass(status)).Inc()
+	m.requestBytes.WithLabelValues(endpoint).Add(float64(max(bytesIn, 0)))
+	m.responseBytes.WithLabelValues(endpoint).Add(float64(max(bytesOut, 0)))
+	m.requestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+}
+
+func (m *metrics) RequestStarted() {
+	m.requestsInFlight.Inc()
+}
+
+func (m *metrics) RequestEnded() {
+	m.requestsInFlight.Dec()
+}
+
+func (m *metrics) TunnelOpened() {
+	m.tunnelsActive.Inc()
+}
+
+func (m *metrics) TunnelClosed() {
+	m.tunnelsActive.Dec()
+}
+
+func (m *metrics) StreamOpened() {
+	m.grpcStreamsActive.Inc()
+	m.grpcStreamsTotal.Inc()
+}
+
+func (m *metrics) StreamClosed() {
+	m.grpcStreamsActive.Dec()
+}
+
+func (m *metrics) RecorderError(operation string) {
+	m.recorderErrors.WithLabelValues(operation).Inc()
+}
+
+func (m *metrics) StoreError(operation string) {
+	m.storeErrors.WithLabelValues(operation).Inc()
+}
+
+// Groups the status codes to keep the number of time series small, for example 2xx.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+
+	return strconv.Itoa(status/100) + "xx"
+}
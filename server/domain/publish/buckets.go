@@ -1,9 +1,11 @@
 package publish
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"wh/domain/encryption"
 
 	"github.com/spf13/viper"
 )
@@ -17,13 +19,62 @@ type Buckets interface {
 
 	OpenResponseReader(requestId string) (io.ReadCloser, error)
 
+	// OpenRequestReplacement writes a new version of the request blob. Readers see the old version until it has been committed.
+	OpenRequestReplacement(requestId string) (BlobReplacement, error)
+
+	// OpenResponseReplacement writes a new version of the response blob. Readers see the old version until it has been committed.
+	OpenResponseReplacement(requestId string) (BlobReplacement, error)
+
 	Delete(requestId string) error
 }
 
+// BlobReplacement replaces a blob atomically. Close discards the written data if it has not been committed, the existing blob is unchanged then.
+type BlobReplacement interface {
+	io.Writer
+
+	Commit() error
+
+	Close() error
+}
+
+func NewBuckets(config *viper.Viper, keyring encryption.Keyring) (Buckets, error) {
+	buckets, err := newStorage(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The data is compressed before it is encrypted, because encrypted data cannot be compressed.
+	buckets = NewEncryptedBuckets(buckets, keyring)
+
+	// Also wrap the buckets when compression is disabled to read blobs that have been compressed before.
+	return NewCompressedBuckets(buckets, config.GetString("storage.compression"))
+}
+
+func newStorage(config *viper.Viper) (Buckets, error) {
+	storageType := config.GetString("storage.type")
+
+	switch storageType {
+	case "file":
+		return NewFileBucket(config), nil
+	case "memory":
+		return NewMemoryBucket(config), nil
+	case "s3":
+		return NewS3Bucket(config)
+	}
+
+	return nil, fmt.Errorf("unknown storage type '%s'", storageType)
+}
+
 type fileBucket struct {
 	basePath string
 }
 
+type fileReplacement struct {
+	closed bool
+	file   *os.File
+	path   string
+}
+
 func NewFileBucket(config *viper.Viper) Buckets {
 	basePath := config.GetString("dataFolder")
 
@@ -66,6 +117,14 @@ func (f fileBucket) OpenResponseReader(requestId string) (io.ReadCloser, error)
 	return os.Open(fullPath)
 }
 
+func (f fileBucket) OpenRequestReplacement(requestId string) (BlobReplacement, error) {
+	return f.openReplacement(requestId, "request.blob")
+}
+
+func (f fileBucket) OpenResponseReplacement(requestId string) (BlobReplacement, error) {
+	return f.openReplacement(requestId, "response.blob")
+}
+
 func (f fileBucket) Delete(requestId string) error {
 	folder := filepath.Join(f.basePath, "dumps", requestId)
 
@@ -83,3 +142,57 @@ func (f fileBucket) getFilePath(requestId string, file string) (string, error) {
 	fullPath := filepath.Join(folder, file)
 	return fullPath, nil
 }
+
+func (f fileBucket) openReplacement(requestId string, file string) (BlobReplacement, error) {
+	fullPath, err := f.getFilePath(requestId, file)
+	if err != nil {
+		return nil, err
+	}
+
+	// The temporary file is created in the same folder, because a rename is only atomic within the same file system.
+	temp, err := os.CreateTemp(filepath.Dir(fullPath), file+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileReplacement{file: temp, path: fullPath}, nil
+}
+
+func (r *fileReplacement) Write(p []byte) (int, error) {
+	return r.file.Write(p)
+}
+
+func (r *fileReplacement) Commit() error {
+	if r.closed {
+		return os.ErrClosed
+	}
+
+	r.closed = true
+
+	// The data has to be on the disk before the rename, otherwise a crash can leave an empty blob behind.
+	err := r.file.Sync()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(r.file.Name(), r.path)
+	}
+
+	if err != nil {
+		_ = os.Remove(r.file.Name())
+	}
+
+	return err
+}
+
+func (r *fileReplacement) Close() error {
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	_ = r.file.Close()
+
+	return os.Remove(r.file.Name())
+}
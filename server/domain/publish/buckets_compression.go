@@ -0,0 +1,251 @@
+package publish
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type codec = byte
+
+const (
+	codecNone codec = iota
+	codecGzip
+	codecZstd
+)
+
+// Each blob starts with the magic bytes and the codec. Blobs without the header have been written before compression was introduced.
+var blobMagic = []byte{0, 'W', 'H', 'B'}
+
+type compressedBuckets struct {
+	inner Buckets
+	codec codec
+}
+
+type compressedWriter struct {
+	compressor io.WriteCloser
+	inner      io.WriteCloser
+}
+
+type compressedReplacement struct {
+	compressor io.WriteCloser
+	inner      BlobReplacement
+}
+
+type compressedReader struct {
+	decompressor io.ReadCloser
+	inner        io.ReadCloser
+}
+
+func NewCompressedBuckets(inner Buckets, compression string) (Buckets, error) {
+	codec, err := parseCodec(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compressedBuckets{inner: inner, codec: codec}, nil
+}
+
+func (c compressedBuckets) OpenRequestWriter(requestId string) (io.WriteCloser, error) {
+	writer, err := c.inner.OpenRequestWriter(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.openWriter(writer)
+}
+
+func (c compressedBuckets) OpenRequestReader(requestId string) (io.ReadCloser, error) {
+	reader, err := c.inner.OpenRequestReader(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return openDecompressedReader(reader)
+}
+
+func (c compressedBuckets) OpenResponseWriter(requestId string) (io.WriteCloser, error) {
+	writer, err := c.inner.OpenResponseWriter(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.openWriter(writer)
+}
+
+func (c compressedBuckets) OpenResponseReader(requestId string) (io.ReadCloser, error) {
+	reader, err := c.inner.OpenResponseReader(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return openDecompressedReader(reader)
+}
+
+func (c compressedBuckets) OpenRequestReplacement(requestId string) (BlobReplacement, error) {
+	replacement, err := c.inner.OpenRequestReplacement(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.openReplacement(replacement)
+}
+
+func (c compressedBuckets) OpenResponseReplacement(requestId string) (BlobReplacement, error) {
+	replacement, err := c.inner.OpenResponseReplacement(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.openReplacement(replacement)
+}
+
+func (c compressedBuckets) Delete(requestId string) error {
+	return c.inner.Delete(requestId)
+}
+
+func (c compressedBuckets) openWriter(inner io.WriteCloser) (io.WriteCloser, error) {
+	compressor, err := c.openCompressor(inner)
+	if err != nil {
+		_ = inner.Close()
+		return nil, err
+	}
+
+	if compressor == nil {
+		return inner, nil
+	}
+
+	return &compressedWriter{compressor: compressor, inner: inner}, nil
+}
+
+func (c compressedBuckets) openReplacement(inner BlobReplacement) (BlobReplacement, error) {
+	compressor, err := c.openCompressor(inner)
+	if err != nil {
+		_ = inner.Close()
+		return nil, err
+	}
+
+	return &compressedReplacement{compressor: compressor, inner: inner}, nil
+}
+
+// Writes the header and returns the compressor, which is nil if the blob is not compressed.
+func (c compressedBuckets) openCompressor(inner io.Writer) (io.WriteCloser, error) {
+	// Also write the header for uncompressed blobs, otherwise a body that starts with the magic bytes cannot be read.
+	header := append(append([]byte{}, blobMagic...), c.codec)
+	if _, err := inner.Write(header); err != nil {
+		return nil, err
+	}
+
+	switch c.codec {
+	case codecGzip:
+		return gzip.NewWriter(inner), nil
+	case codecZstd:
+		return zstd.NewWriter(inner, zstd.WithEncoderConcurrency(1))
+	}
+
+	return nil, nil
+}
+
+func openDecompressedReader(inner io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(inner)
+
+	header, err := buffered.Peek(len(blobMagic) + 1)
+	if err != nil && err != io.EOF {
+		_ = inner.Close()
+		return nil, err
+	}
+
+	// Blobs that have been written before compression was enabled.
+	if len(header) <= len(blobMagic) || !bytes.Equal(header[:len(blobMagic)], blobMagic) {
+		return &compressedReader{decompressor: io.NopCloser(buffered), inner: inner}, nil
+	}
+
+	_, _ = buffered.Discard(len(header))
+
+	var decompressor io.ReadCloser
+	switch header[len(blobMagic)] {
+	case codecNone:
+		decompressor = io.NopCloser(buffered)
+	case codecGzip:
+		decompressor, err = gzip.NewReader(buffered)
+	case codecZstd:
+		var decoder *zstd.Decoder
+		decoder, err = zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err == nil {
+			decompressor = decoder.IOReadCloser()
+		}
+	default:
+		err = fmt.Errorf("unknown codec %d", header[len(blobMagic)])
+	}
+
+	if err != nil {
+		_ = inner.Close()
+		return nil, err
+	}
+
+	return &compressedReader{decompressor: decompressor, inner: inner}, nil
+}
+
+func (w *compressedWriter) Write(p []byte) (int, error) {
+	return w.compressor.Write(p)
+}
+
+func (w *compressedWriter) Close() error {
+	// The compressor writes the remaining bytes when closed, but does not close the inner writer.
+	err := w.compressor.Close()
+
+	if innerErr := w.inner.Close(); err == nil {
+		err = innerErr
+	}
+
+	return err
+}
+
+func (r *compressedReplacement) Write(p []byte) (int, error) {
+	if r.compressor == nil {
+		return r.inner.Write(p)
+	}
+
+	return r.compressor.Write(p)
+}
+
+func (r *compressedReplacement) Commit() error {
+	if r.compressor != nil {
+		if err := r.compressor.Close(); err != nil {
+			return err
+		}
+	}
+
+	return r.inner.Commit()
+}
+
+func (r *compressedReplacement) Close() error {
+	return r.inner.Close()
+}
+
+func (r *compressedReader) Read(p []byte) (int, error) {
+	return r.decompressor.Read(p)
+}
+
+func (r *compressedReader) Close() error {
+	_ = r.decompressor.Close()
+
+	return r.inner.Close()
+}
+
+func parseCodec(compression string) (codec, error) {
+	switch compression {
+	case "", "none":
+		return codecNone, nil
+	case "gzip":
+		return codecGzip, nil
+	case "zstd":
+		return codecZstd, nil
+	}
+
+	return codecNone, fmt.Errorf("unknown compression '%s'", compression)
+}
@@ -0,0 +1,127 @@
+package publish
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func writeBlob(t *testing.T, b Buckets, requestId string, data []byte) {
+	t.Helper()
+
+	writer, err := b.OpenRequestWriter(requestId)
+	if err != nil {
+		t.Fatalf("failed to open writer: %v", err)
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close writer: %v", err)
+	}
+}
+
+func readBlob(t *testing.T, b Buckets, requestId string) []byte {
+	t.Helper()
+
+	reader, err := b.OpenRequestReader(requestId)
+	if err != nil {
+		t.Fatalf("failed to open reader: %v", err)
+	}
+
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	return data
+}
+
+func TestCompressedBucketsRoundTrip(t *testing.T) {
+	bodies := map[string][]byte{
+		"empty":       {},
+		"text":        bytes.Repeat([]byte(`{"key":"value"}`), 1000),
+		"magic bytes": append(append([]byte{}, blobMagic...), codecGzip, 'x', 'y'),
+	}
+
+	for _, compression := range []string{"none", "gzip", "zstd"} {
+		for name, body := range bodies {
+			t.Run(compression+" "+name, func(t *testing.T) {
+				b, err := NewCompressedBuckets(NewMemoryBucket(viper.New()), compression)
+				if err != nil {
+					t.Fatalf("failed to create buckets: %v", err)
+				}
+
+				writeBlob(t, b, "request-1", body)
+
+				if actual := readBlob(t, b, "request-1"); !bytes.Equal(actual, body) {
+					t.Errorf("expected %q, got %q", body, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestCompressedBucketsWriteHeader(t *testing.T) {
+	inner := NewMemoryBucket(viper.New())
+
+	b, err := NewCompressedBuckets(inner, "none")
+	if err != nil {
+		t.Fatalf("failed to create buckets: %v", err)
+	}
+
+	writeBlob(t, b, "request-1", []byte("body"))
+
+	raw := readBlob(t, inner, "request-1")
+	expected := append(append([]byte{}, blobMagic...), codecNone, 'b', 'o', 'd', 'y')
+
+	if !bytes.Equal(raw, expected) {
+		t.Errorf("expected the header for uncompressed blobs, got %q", raw)
+	}
+}
+
+func TestCompressedBucketsReadLegacy(t *testing.T) {
+	bodies := map[string][]byte{
+		"empty":   {},
+		"short":   {0, 'W'},
+		"text":    []byte(`{"key":"value"}`),
+		"similar": {0, 'W', 'H', 'X', 1, 2, 3},
+	}
+
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			inner := NewMemoryBucket(viper.New())
+
+			// Blobs that have been written before compression was introduced do not have a header.
+			writeBlob(t, inner, "request-1", body)
+
+			b, err := NewCompressedBuckets(inner, "zstd")
+			if err != nil {
+				t.Fatalf("failed to create buckets: %v", err)
+			}
+
+			if actual := readBlob(t, b, "request-1"); !bytes.Equal(actual, body) {
+				t.Errorf("expected %q, got %q", body, actual)
+			}
+		})
+	}
+}
+
+func TestCompressedBucketsReadOtherCodec(t *testing.T) {
+	inner := NewMemoryBucket(viper.New())
+
+	gzipBuckets, _ := NewCompressedBuckets(inner, "gzip")
+	writeBlob(t, gzipBuckets, "request-1", []byte("compressed with gzip"))
+
+	// The codec is read from the header, therefore the configuration can be changed.
+	zstdBuckets, _ := NewCompressedBuckets(inner, "zstd")
+	if actual := readBlob(t, zstdBuckets, "request-1"); string(actual) != "compressed with gzip" {
+		t.Errorf("unexpected body %q", actual)
+	}
+}
@@ -0,0 +1,157 @@
+package publish
+
+import (
+	"io"
+	"wh/domain/encryption"
+)
+
+type encryptedBuckets struct {
+	inner   Buckets
+	keyring encryption.Keyring
+}
+
+type encryptedWriter struct {
+	encryptor io.WriteCloser
+	inner     io.WriteCloser
+}
+
+type encryptedReplacement struct {
+	encryptor io.WriteCloser
+	inner     BlobReplacement
+}
+
+type encryptedReader struct {
+	decryptor io.Reader
+	inner     io.ReadCloser
+}
+
+func NewEncryptedBuckets(inner Buckets, keyring encryption.Keyring) Buckets {
+	return &encryptedBuckets{inner: inner, keyring: keyring}
+}
+
+func (e encryptedBuckets) OpenRequestWriter(requestId string) (io.WriteCloser, error) {
+	writer, err := e.inner.OpenRequestWriter(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.openWriter(writer)
+}
+
+func (e encryptedBuckets) OpenRequestReader(requestId string) (io.ReadCloser, error) {
+	reader, err := e.inner.OpenRequestReader(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.openReader(reader)
+}
+
+func (e encryptedBuckets) OpenResponseWriter(requestId string) (io.WriteCloser, error) {
+	writer, err := e.inner.OpenResponseWriter(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.openWriter(writer)
+}
+
+func (e encryptedBuckets) OpenResponseReader(requestId string) (io.ReadCloser, error) {
+	reader, err := e.inner.OpenResponseReader(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.openReader(reader)
+}
+
+func (e encryptedBuckets) OpenRequestReplacement(requestId string) (BlobReplacement, error) {
+	replacement, err := e.inner.OpenRequestReplacement(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.openReplacement(replacement)
+}
+
+func (e encryptedBuckets) OpenResponseReplacement(requestId string) (BlobReplacement, error) {
+	replacement, err := e.inner.OpenResponseReplacement(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.openReplacement(replacement)
+}
+
+func (e encryptedBuckets) Delete(requestId string) error {
+	return e.inner.Delete(requestId)
+}
+
+func (e encryptedBuckets) openWriter(inner io.WriteCloser) (io.WriteCloser, error) {
+	encryptor, err := encryption.NewWriter(e.keyring, inner)
+	if err != nil {
+		_ = inner.Close()
+		return nil, err
+	}
+
+	return &encryptedWriter{encryptor: encryptor, inner: inner}, nil
+}
+
+func (e encryptedBuckets) openReplacement(inner BlobReplacement) (BlobReplacement, error) {
+	encryptor, err := encryption.NewWriter(e.keyring, inner)
+	if err != nil {
+		_ = inner.Close()
+		return nil, err
+	}
+
+	return &encryptedReplacement{encryptor: encryptor, inner: inner}, nil
+}
+
+func (e encryptedBuckets) openReader(inner io.ReadCloser) (io.ReadCloser, error) {
+	decryptor, err := encryption.NewReader(e.keyring, inner)
+	if err != nil {
+		_ = inner.Close()
+		return nil, err
+	}
+
+	return &encryptedReader{decryptor: decryptor, inner: inner}, nil
+}
+
+func (w *encryptedWriter) Write(p []byte) (int, error) {
+	return w.encryptor.Write(p)
+}
+
+func (w *encryptedWriter) Close() error {
+	err := w.encryptor.Close()
+
+	if innerErr := w.inner.Close(); err == nil {
+		err = innerErr
+	}
+
+	return err
+}
+
+func (r *encryptedReplacement) Write(p []byte) (int, error) {
+	return r.encryptor.Write(p)
+}
+
+func (r *encryptedReplacement) Commit() error {
+	// Writes the last chunk, the blob is not replaced if it cannot be written.
+	if err := r.encryptor.Close(); err != nil {
+		return err
+	}
+
+	return r.inner.Commit()
+}
+
+func (r *encryptedReplacement) Close() error {
+	return r.inner.Close()
+}
+
+func (r *encryptedReader) Read(p []byte) (int, error) {
+	return r.decryptor.Read(p)
+}
+
+func (r *encryptedReader) Close() error {
+	return r.inner.Close()
+}
@@ -0,0 +1,141 @@
+package publish
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+type memoryBucket struct {
+	blobs   map[string][]byte
+	lock    sync.Mutex
+	maxSize int
+	order   []string
+	size    int
+}
+
+type memoryWriter struct {
+	buffer bytes.Buffer
+	bucket *memoryBucket
+	key    string
+}
+
+// The blob is only stored on commit, therefore readers never see a partial blob.
+type memoryReplacement struct {
+	*memoryWriter
+}
+
+func NewMemoryBucket(config *viper.Viper) Buckets {
+	maxSize := config.GetInt("log.maxSize")
+
+	return &memoryBucket{
+		blobs:   make(map[string][]byte),
+		maxSize: maxSize,
+		order:   make([]string, 0),
+	}
+}
+
+func (m *memoryBucket) OpenRequestWriter(requestId string) (io.WriteCloser, error) {
+	return &memoryWriter{bucket: m, key: getBlobKey(requestId, "request.blob")}, nil
+}
+
+func (m *memoryBucket) OpenRequestReader(requestId string) (io.ReadCloser, error) {
+	return m.openReader(getBlobKey(requestId, "request.blob"))
+}
+
+func (m *memoryBucket) OpenResponseWriter(requestId string) (io.WriteCloser, error) {
+	return &memoryWriter{bucket: m, key: getBlobKey(requestId, "response.blob")}, nil
+}
+
+func (m *memoryBucket) OpenResponseReader(requestId string) (io.ReadCloser, error) {
+	return m.openReader(getBlobKey(requestId, "response.blob"))
+}
+
+func (m *memoryBucket) OpenRequestReplacement(requestId string) (BlobReplacement, error) {
+	return memoryReplacement{&memoryWriter{bucket: m, key: getBlobKey(requestId, "request.blob")}}, nil
+}
+
+func (m *memoryBucket) OpenResponseReplacement(requestId string) (BlobReplacement, error) {
+	return memoryReplacement{&memoryWriter{bucket: m, key: getBlobKey(requestId, "response.blob")}}, nil
+}
+
+func (m *memoryBucket) Delete(requestId string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.remove(getBlobKey(requestId, "request.blob"))
+	m.remove(getBlobKey(requestId, "response.blob"))
+
+	return nil
+}
+
+func (m *memoryBucket) openReader(key string) (io.ReadCloser, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	blob, ok := m.blobs[key]
+	if !ok {
+		// Same error as the file bucket.
+		return nil, os.ErrNotExist
+	}
+
+	// Blobs are never modified after they have been written, therefore we can share the slice.
+	return io.NopCloser(bytes.NewReader(blob)), nil
+}
+
+func (m *memoryBucket) store(key string, blob []byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.remove(key)
+
+	m.blobs[key] = blob
+	m.order = append(m.order, key)
+	m.size += len(blob)
+
+	// Remove the oldest blobs until we are below the limit again.
+	for m.maxSize > 0 && m.size > m.maxSize && len(m.order) > 0 {
+		m.remove(m.order[0])
+	}
+}
+
+func (m *memoryBucket) remove(key string) {
+	blob, ok := m.blobs[key]
+	if !ok {
+		return
+	}
+
+	delete(m.blobs, key)
+	m.size -= len(blob)
+
+	for i, other := range m.order {
+		if other == key {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	return w.buffer.Write(p)
+}
+
+func (w *memoryWriter) Close() error {
+	w.bucket.store(w.key, w.buffer.Bytes())
+	return nil
+}
+
+func (r memoryReplacement) Commit() error {
+	return r.memoryWriter.Close()
+}
+
+func (r memoryReplacement) Close() error {
+	return nil
+}
+
+func getBlobKey(requestId string, file string) string {
+	return requestId + "/" + file
+}
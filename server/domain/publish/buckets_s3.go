@@ -0,0 +1,218 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/spf13/viper"
+)
+
+type s3Bucket struct {
+	bucket        string
+	client        *minio.Client
+	partSize      uint64
+	prefix        string
+	uploadTimeout time.Duration
+}
+
+type s3Writer struct {
+	cancel context.CancelFunc
+	err    error
+	pipe   *io.PipeWriter
+	result chan error
+}
+
+// S3 only creates the object when the upload has been completed, therefore the object can be replaced in place.
+type s3Replacement struct {
+	*s3Writer
+
+	closed bool
+}
+
+var errReplacementDiscarded = errors.New("replacement has been discarded")
+
+func NewS3Bucket(config *viper.Viper) (Buckets, error) {
+	bucketLookup := minio.BucketLookupAuto
+	if config.GetBool("storage.s3.pathStyle") {
+		// Most S3 compatible servers like MinIO only support path style requests.
+		bucketLookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(config.GetString("storage.s3.endpoint"), &minio.Options{
+		Creds: credentials.NewStaticV4(
+			config.GetString("storage.s3.accessKey"),
+			config.GetString("storage.s3.secretKey"),
+			""),
+		Secure:       config.GetBool("storage.s3.useSSL"),
+		Region:       config.GetString("storage.s3.region"),
+		BucketLookup: bucketLookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := config.GetString("storage.s3.bucket")
+
+	exists, err := client.BucketExists(context.Background(), bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		err = client.MakeBucket(context.Background(), bucket, minio.MakeBucketOptions{
+			Region: config.GetString("storage.s3.region"),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &s3Bucket{
+		bucket:        bucket,
+		client:        client,
+		partSize:      config.GetUint64("storage.s3.partSize"),
+		prefix:        config.GetString("storage.s3.prefix"),
+		uploadTimeout: config.GetDuration("storage.s3.uploadTimeout"),
+	}, nil
+}
+
+func (s s3Bucket) OpenRequestWriter(requestId string) (io.WriteCloser, error) {
+	return s.openWriter(s.getObjectName(requestId, "request.blob")), nil
+}
+
+func (s s3Bucket) OpenRequestReader(requestId string) (io.ReadCloser, error) {
+	return s.openReader(s.getObjectName(requestId, "request.blob"))
+}
+
+func (s s3Bucket) OpenResponseWriter(requestId string) (io.WriteCloser, error) {
+	return s.openWriter(s.getObjectName(requestId, "response.blob")), nil
+}
+
+func (s s3Bucket) OpenResponseReader(requestId string) (io.ReadCloser, error) {
+	return s.openReader(s.getObjectName(requestId, "response.blob"))
+}
+
+func (s s3Bucket) OpenRequestReplacement(requestId string) (BlobReplacement, error) {
+	return &s3Replacement{s3Writer: s.openWriter(s.getObjectName(requestId, "request.blob"))}, nil
+}
+
+func (s s3Bucket) OpenResponseReplacement(requestId string) (BlobReplacement, error) {
+	return &s3Replacement{s3Writer: s.openWriter(s.getObjectName(requestId, "response.blob"))}, nil
+}
+
+func (s s3Bucket) Delete(requestId string) error {
+	for _, file := range []string{"request.blob", "response.blob"} {
+		// Deleting a non existing object is not an error in S3.
+		err := s.client.RemoveObject(context.Background(), s.bucket, s.getObjectName(requestId, file), minio.RemoveObjectOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s s3Bucket) openWriter(objectName string) *s3Writer {
+	reader, writer := io.Pipe()
+
+	// The writer stays open while the body is received, therefore the timeout must be longer than the requests.
+	ctx, cancel := context.WithTimeout(context.Background(), s.uploadTimeout)
+
+	result := make(chan error, 1)
+	go func() {
+		// The size is not known in advance, therefore the client uploads the body in parts.
+		_, err := s.client.PutObject(ctx, s.bucket, objectName, reader, -1, minio.PutObjectOptions{
+			ContentType: "application/octet-stream",
+			PartSize:    s.partSize,
+		})
+
+		// Unblock the writer if the upload has failed.
+		_ = reader.CloseWithError(err)
+		result <- err
+	}()
+
+	return &s3Writer{cancel: cancel, pipe: writer, result: result}
+}
+
+func (s s3Bucket) openReader(objectName string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(context.Background(), s.bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// The object is loaded lazily, therefore check if it exists to return the error early.
+	if _, err := object.Stat(); err != nil {
+		_ = object.Close()
+		return nil, err
+	}
+
+	return object, nil
+}
+
+func (s s3Bucket) getObjectName(requestId string, file string) string {
+	return path.Join(s.prefix, "dumps", requestId, file)
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.pipe.Write(p)
+	if err != nil {
+		w.err = err
+	}
+
+	return n, err
+}
+
+func (w *s3Writer) Close() error {
+	// Never complete the upload after a failed write, because the object would only contain a part of the body.
+	if w.err != nil {
+		w.abort(w.err)
+		return w.err
+	}
+
+	defer w.cancel()
+
+	if err := w.pipe.Close(); err != nil {
+		return err
+	}
+
+	// Wait for the upload to complete.
+	return <-w.result
+}
+
+// The client aborts the upload when the body cannot be read.
+func (w *s3Writer) abort(reason error) {
+	defer w.cancel()
+
+	_ = w.pipe.CloseWithError(reason)
+	<-w.result
+}
+
+func (r *s3Replacement) Commit() error {
+	if r.closed {
+		return os.ErrClosed
+	}
+
+	r.closed = true
+	return r.s3Writer.Close()
+}
+
+func (r *s3Replacement) Close() error {
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	r.abort(errReplacementDiscarded)
+
+	return nil
+}
@@ -0,0 +1,394 @@
+package publish
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// A minimal S3 server with path style requests, which supports the calls of the MinIO client.
+type fakeS3 struct {
+	buckets map[string]bool
+	lock    sync.Mutex
+	objects map[string][]byte
+	parts   map[string]map[int][]byte
+	uploads int
+
+	// Rejects the uploads of parts, like a storage without space.
+	failParts bool
+
+	// The number of parts of the completed multipart uploads.
+	completedParts []int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{
+		buckets: make(map[string]bool),
+		objects: make(map[string][]byte),
+		parts:   make(map[string]map[int][]byte),
+	}
+
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	return f, server
+}
+
+func newFakeS3Config(server *httptest.Server) *viper.Viper {
+	config := viper.New()
+	config.Set("storage.s3.endpoint", strings.TrimPrefix(server.URL, "http://"))
+	config.Set("storage.s3.accessKey", "access")
+	config.Set("storage.s3.secretKey", "secret")
+	config.Set("storage.s3.bucket", "webhooks")
+	config.Set("storage.s3.region", "us-east-1")
+	config.Set("storage.s3.pathStyle", true)
+	config.Set("storage.s3.partSize", 5*1024*1024)
+	config.Set("storage.s3.prefix", "test")
+	config.Set("storage.s3.uploadTimeout", time.Minute)
+
+	return config
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	if key == "" {
+		f.serveBucket(w, r, bucket)
+		return
+	}
+
+	name := bucket + "/" + key
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.uploads++
+		uploadId := strconv.Itoa(f.uploads)
+		f.parts[uploadId] = make(map[int][]byte)
+
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: uploadId})
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := f.parts[query.Get("uploadId")]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+
+		if f.failParts {
+			writeS3Error(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[partNumber] = data
+
+		w.Header().Set("ETag", etag(data))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := f.parts[query.Get("uploadId")]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+
+		numbers := make([]int, 0, len(parts))
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+
+		sort.Ints(numbers)
+
+		var data bytes.Buffer
+		for _, number := range numbers {
+			data.Write(parts[number])
+		}
+
+		f.objects[name] = data.Bytes()
+		f.completedParts = append(f.completedParts, len(parts))
+		delete(f.parts, query.Get("uploadId"))
+
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: etag(data.Bytes())})
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.parts, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+
+		f.objects[name] = data
+		w.Header().Set("ETag", etag(data))
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[name]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("ETag", etag(data))
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	switch r.Method {
+	case http.MethodHead:
+		if !f.buckets[bucket] {
+			writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		}
+	case http.MethodPut:
+		f.buckets[bucket] = true
+	case http.MethodGet:
+		// The location of the bucket.
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+		}{})
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// The client signs streamed bodies chunk by chunk, therefore the chunks have to be decoded.
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var result bytes.Buffer
+
+	reader := bufio.NewReader(r.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		sizeText, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+
+		size, err := strconv.ParseInt(sizeText, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		// The trailers after the last chunk are ignored.
+		if size == 0 {
+			return result.Bytes(), nil
+		}
+
+		if _, err := io.CopyN(&result, reader, size); err != nil {
+			return nil, err
+		}
+
+		if _, err := reader.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func writeXML(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(value)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func etag(data []byte) string {
+	hash := md5.Sum(data)
+	return `"` + hex.EncodeToString(hash[:]) + `"`
+}
+
+func TestS3BucketMultipartUpload(t *testing.T) {
+	fake, server := newFakeS3(t)
+
+	buckets, err := NewS3Bucket(newFakeS3Config(server))
+	if err != nil {
+		t.Fatalf("failed to create the bucket: %v", err)
+	}
+
+	if !fake.buckets["webhooks"] {
+		t.Fatal("expected the bucket to be created")
+	}
+
+	// Larger than two parts of 5 MB.
+	body := make([]byte, 11*1024*1024)
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+
+	writer, err := buckets.OpenRequestWriter("request-1")
+	if err != nil {
+		t.Fatalf("failed to open the writer: %v", err)
+	}
+
+	// Write in small chunks like the recorder.
+	for offset := 0; offset < len(body); offset += 4096 {
+		if _, err := writer.Write(body[offset:min(offset+4096, len(body))]); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to complete the upload: %v", err)
+	}
+
+	if len(fake.completedParts) != 1 || fake.completedParts[0] != 3 {
+		t.Fatalf("expected one upload with 3 parts, got %v", fake.completedParts)
+	}
+
+	if _, ok := fake.objects["webhooks/test/dumps/request-1/request.blob"]; !ok {
+		t.Fatal("expected the object to use the prefix")
+	}
+
+	reader, err := buckets.OpenRequestReader("request-1")
+	if err != nil {
+		t.Fatalf("failed to open the reader: %v", err)
+	}
+
+	defer reader.Close()
+
+	actual, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	if !bytes.Equal(actual, body) {
+		t.Fatalf("expected %d bytes, got %d different bytes", len(body), len(actual))
+	}
+
+	if err := buckets.Delete("request-1"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	if _, err := buckets.OpenRequestReader("request-1"); err == nil {
+		t.Fatal("expected an error for a deleted object")
+	}
+}
+
+func TestS3BucketAbortsUploadOnFailedWrite(t *testing.T) {
+	fake, server := newFakeS3(t)
+	fake.failParts = true
+
+	buckets, err := NewS3Bucket(newFakeS3Config(server))
+	if err != nil {
+		t.Fatalf("failed to create the bucket: %v", err)
+	}
+
+	writer, err := buckets.OpenRequestWriter("request-1")
+	if err != nil {
+		t.Fatalf("failed to open the writer: %v", err)
+	}
+
+	// The first part is rejected, therefore the writes fail before the body is complete.
+	chunk := make([]byte, 4096)
+
+	var writeErr error
+	for written := 0; written < 11*1024*1024 && writeErr == nil; written += len(chunk) {
+		_, writeErr = writer.Write(chunk)
+	}
+
+	if writeErr == nil {
+		t.Fatal("expected the write to fail")
+	}
+
+	if err := writer.Close(); err == nil {
+		t.Error("expected the close to report the failed upload")
+	}
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	if len(fake.objects) != 0 || len(fake.parts) != 0 {
+		t.Errorf("expected the upload to be aborted, got %d objects and %d uploads", len(fake.objects), len(fake.parts))
+	}
+}
+
+func TestS3BucketUploadTimeout(t *testing.T) {
+	fake, server := newFakeS3(t)
+
+	config := newFakeS3Config(server)
+	config.Set("storage.s3.uploadTimeout", 100*time.Millisecond)
+
+	buckets, err := NewS3Bucket(config)
+	if err != nil {
+		t.Fatalf("failed to create the bucket: %v", err)
+	}
+
+	writer, err := buckets.OpenResponseWriter("request-1")
+	if err != nil {
+		t.Fatalf("failed to open the writer: %v", err)
+	}
+
+	if _, err := writer.Write([]byte("partial")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	// The body is not completed within the timeout.
+	time.Sleep(200 * time.Millisecond)
+
+	if err := writer.Close(); err == nil {
+		t.Error("expected the upload to time out")
+	}
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	if len(fake.objects) != 0 {
+		t.Errorf("expected no object, got %d", len(fake.objects))
+	}
+}
@@ -0,0 +1,591 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+	"wh/domain/encryption"
+
+	"github.com/spf13/viper"
+)
+
+// Every store must pass these tests, so that the implementations can be exchanged by configuration.
+func testStoreConformance(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("log request", func(t *testing.T) {
+		s := newStore(t)
+
+		request := HttpRequestStart{
+			Method:       http.MethodPost,
+			Path:         "/hooks?id=1",
+			Headers:      http.Header{"Content-Type": {"application/json"}, "X-Multi": {"a", "b"}},
+			Verification: VerificationValid,
+		}
+
+		if err := s.LogRequest("request-1", "users", request, RecordingMetadata); err != nil {
+			t.Fatalf("failed to log request: %v", err)
+		}
+
+		entry, err := s.GetEntry("request-1")
+		if err != nil || entry == nil {
+			t.Fatalf("expected the entry, got %v, %v", entry, err)
+		}
+
+		if entry.RequestId != "request-1" || entry.Endpoint != "users" || entry.Status != StatusRequestStarted {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+
+		if entry.Request.Method != http.MethodPost || entry.Request.Path != "/hooks?id=1" || entry.Request.Verification != VerificationValid {
+			t.Errorf("unexpected request %+v", entry.Request)
+		}
+
+		if values := entry.Request.Headers.Values("X-Multi"); len(values) != 2 || values[1] != "b" {
+			t.Errorf("unexpected headers %v", entry.Request.Headers)
+		}
+
+		if entry.Recording != RecordingMetadata {
+			t.Errorf("expected the recording mode, got %q", entry.Recording)
+		}
+
+		if entry.Started.IsZero() || entry.Completed != nil || entry.Response != nil {
+			t.Errorf("unexpected state of a started request %+v", entry)
+		}
+	})
+
+	t.Run("log response", func(t *testing.T) {
+		s := newStore(t)
+
+		if err := s.LogRequest("request-1", "users", HttpRequestStart{Method: http.MethodGet, Path: "/", Headers: http.Header{}}, RecordingFull); err != nil {
+			t.Fatalf("failed to log request: %v", err)
+		}
+
+		forwarded := time.Now().UTC().Truncate(time.Millisecond)
+		timings := Timings{RequestForwarded: &forwarded, LocalDuration: 42 * time.Millisecond}
+		redacted := []Redaction{{Location: RedactionRequestHeader, Name: "Authorization"}}
+		response := &HttpResponseStart{Status: 201, Headers: http.Header{"X-Response": {"1"}}}
+
+		if err := s.LogResponse("request-1", ResponseLog{RequestSize: 10, Response: response, ResponseSize: 20, Error: errors.New("failed"), Status: StatusCompleted, Redacted: redacted, RequestTruncated: true, Timings: timings}); err != nil {
+			t.Fatalf("failed to log response: %v", err)
+		}
+
+		entry, err := s.GetEntry("request-1")
+		if err != nil || entry == nil {
+			t.Fatalf("expected the entry, got %v, %v", entry, err)
+		}
+
+		if entry.RequestSize != 10 || entry.ResponseSize != 20 || entry.Status != StatusCompleted || entry.Completed == nil {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+
+		if entry.Response == nil || entry.Response.Status != 201 || entry.Response.Headers.Get("X-Response") != "1" {
+			t.Errorf("unexpected response %+v", entry.Response)
+		}
+
+		if entry.Error == nil || entry.Error.Error() != "failed" {
+			t.Errorf("unexpected error %v", entry.Error)
+		}
+
+		if len(entry.Redacted) != 1 || entry.Redacted[0] != redacted[0] {
+			t.Errorf("unexpected redactions %v", entry.Redacted)
+		}
+
+		if !entry.RequestTruncated || entry.ResponseTruncated {
+			t.Errorf("unexpected truncation %v, %v", entry.RequestTruncated, entry.ResponseTruncated)
+		}
+
+		if entry.Timings.RequestForwarded == nil || !entry.Timings.RequestForwarded.Equal(forwarded) || entry.Timings.LocalDuration != timings.LocalDuration {
+			t.Errorf("unexpected timings %+v", entry.Timings)
+		}
+	})
+
+	t.Run("log response of unknown request", func(t *testing.T) {
+		s := newStore(t)
+
+		if err := s.LogResponse("unknown", ResponseLog{Status: StatusFailed}); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("get unknown entry", func(t *testing.T) {
+		s := newStore(t)
+
+		entry, err := s.GetEntry("unknown")
+		if entry != nil || err != nil {
+			t.Errorf("expected no entry, got %v, %v", entry, err)
+		}
+	})
+
+	t.Run("get entries", func(t *testing.T) {
+		s := newStore(t)
+
+		for _, requestId := range []string{"request-1", "request-2"} {
+			if err := s.LogRequest(requestId, "users", HttpRequestStart{Method: http.MethodGet, Path: "/", Headers: http.Header{}}, RecordingFull); err != nil {
+				t.Fatalf("failed to log request: %v", err)
+			}
+		}
+
+		entries, etag, err := s.GetEntries(0)
+		if err != nil {
+			t.Fatalf("failed to get entries: %v", err)
+		}
+
+		if len(entries) != 2 || etag <= 0 {
+			t.Fatalf("expected 2 entries and an etag, got %d, %d", len(entries), etag)
+		}
+
+		// Nothing has changed since the last call.
+		entries, newEtag, err := s.GetEntries(etag)
+		if err != nil {
+			t.Fatalf("failed to get entries: %v", err)
+		}
+
+		if len(entries) != 0 || newEtag != etag {
+			t.Errorf("expected no changes, got %d, %d", len(entries), newEtag)
+		}
+	})
+
+	t.Run("reserve endpoint", func(t *testing.T) {
+		s := newStore(t)
+
+		if err := s.ReserveEndpoint("users", "owner-1"); err != nil {
+			t.Fatalf("failed to reserve: %v", err)
+		}
+
+		// Reserving again is idempotent for the owner.
+		if err := s.ReserveEndpoint("users", "owner-1"); err != nil {
+			t.Errorf("expected the owner to reserve again, got %v", err)
+		}
+
+		if err := s.ReserveEndpoint("users", "owner-2"); !errors.Is(err, ErrReserved) {
+			t.Errorf("expected ErrReserved, got %v", err)
+		}
+
+		endpoint, err := s.GetEndpoint("users")
+		if err != nil || endpoint == nil || endpoint.Owner != "owner-1" || endpoint.Created.IsZero() {
+			t.Errorf("unexpected endpoint %+v, %v", endpoint, err)
+		}
+
+		endpoint, err = s.GetEndpoint("unknown")
+		if err != nil || endpoint != nil {
+			t.Errorf("expected no endpoint, got %+v, %v", endpoint, err)
+		}
+	})
+
+	t.Run("recording policies", func(t *testing.T) {
+		s := newStore(t)
+
+		policy := RecordingPolicy{Mode: RecordingMetadata, MaxBodySize: 100, SampleRate: 10}
+		if err := s.SetRecordingPolicy("users", &policy); err != nil {
+			t.Fatalf("failed to set policy: %v", err)
+		}
+
+		// Updates the existing policy.
+		policy.Mode = RecordingFull
+		if err := s.SetRecordingPolicy("users", &policy); err != nil {
+			t.Fatalf("failed to update policy: %v", err)
+		}
+
+		policies, err := s.GetRecordingPolicies()
+		if err != nil || len(policies) != 1 || policies["users"] != policy {
+			t.Errorf("unexpected policies %v, %v", policies, err)
+		}
+
+		if err := s.SetRecordingPolicy("users", nil); err != nil {
+			t.Fatalf("failed to delete policy: %v", err)
+		}
+
+		policies, err = s.GetRecordingPolicies()
+		if err != nil || len(policies) != 0 {
+			t.Errorf("expected no policies, got %v, %v", policies, err)
+		}
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		s := newStore(t)
+
+		if err := s.Ping(context.Background()); err != nil {
+			t.Errorf("expected the store to be reachable, got %v", err)
+		}
+	})
+}
+
+// Every storage must pass these tests, so that the implementations can be exchanged by configuration.
+func testBucketsConformance(t *testing.T, newBuckets func(t *testing.T) Buckets) {
+	write := func(t *testing.T, writer io.WriteCloser, err error, data []byte) {
+		t.Helper()
+
+		if err != nil {
+			t.Fatalf("failed to open writer: %v", err)
+		}
+
+		if _, err := writer.Write(data); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+
+		if err := writer.Close(); err != nil {
+			t.Fatalf("failed to close writer: %v", err)
+		}
+	}
+
+	read := func(t *testing.T, reader io.ReadCloser, err error) []byte {
+		t.Helper()
+
+		if err != nil {
+			t.Fatalf("failed to open reader: %v", err)
+		}
+
+		defer reader.Close()
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+
+		return data
+	}
+
+	t.Run("write and read", func(t *testing.T) {
+		b := newBuckets(t)
+
+		request := []byte(`{"request":true}`)
+		response := bytes.Repeat([]byte("response"), 10000)
+
+		writer, err := b.OpenRequestWriter("request-1")
+		write(t, writer, err, request)
+
+		writer, err = b.OpenResponseWriter("request-1")
+		write(t, writer, err, response)
+
+		reader, err := b.OpenRequestReader("request-1")
+		if actual := read(t, reader, err); !bytes.Equal(actual, request) {
+			t.Errorf("unexpected request body %q", actual)
+		}
+
+		reader, err = b.OpenResponseReader("request-1")
+		if actual := read(t, reader, err); !bytes.Equal(actual, response) {
+			t.Errorf("unexpected response body of %d bytes", len(actual))
+		}
+	})
+
+	t.Run("empty body", func(t *testing.T) {
+		b := newBuckets(t)
+
+		writer, err := b.OpenRequestWriter("request-1")
+		write(t, writer, err, []byte{})
+
+		reader, err := b.OpenRequestReader("request-1")
+		if actual := read(t, reader, err); len(actual) != 0 {
+			t.Errorf("expected an empty body, got %q", actual)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		b := newBuckets(t)
+
+		writer, err := b.OpenRequestWriter("request-1")
+		write(t, writer, err, []byte("first"))
+
+		writer, err = b.OpenRequestWriter("request-1")
+		write(t, writer, err, []byte("second"))
+
+		reader, err := b.OpenRequestReader("request-1")
+		if actual := read(t, reader, err); string(actual) != "second" {
+			t.Errorf("unexpected body %q", actual)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		b := newBuckets(t)
+
+		writer, err := b.OpenResponseWriter("request-1")
+		write(t, writer, err, []byte("first"))
+
+		replacement, err := b.OpenResponseReplacement("request-1")
+		if err != nil {
+			t.Fatalf("failed to open replacement: %v", err)
+		}
+
+		if _, err := replacement.Write([]byte("second")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+
+		if err := replacement.Commit(); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+
+		_ = replacement.Close()
+
+		reader, err := b.OpenResponseReader("request-1")
+		if actual := read(t, reader, err); string(actual) != "second" {
+			t.Errorf("unexpected body %q", actual)
+		}
+	})
+
+	t.Run("discard replacement", func(t *testing.T) {
+		b := newBuckets(t)
+
+		writer, err := b.OpenRequestWriter("request-1")
+		write(t, writer, err, []byte("first"))
+
+		replacement, err := b.OpenRequestReplacement("request-1")
+		if err != nil {
+			t.Fatalf("failed to open replacement: %v", err)
+		}
+
+		if _, err := replacement.Write([]byte("partial")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+
+		if err := replacement.Close(); err != nil {
+			t.Fatalf("failed to close: %v", err)
+		}
+
+		reader, err := b.OpenRequestReader("request-1")
+		if actual := read(t, reader, err); string(actual) != "first" {
+			t.Errorf("expected the existing body, got %q", actual)
+		}
+	})
+
+	t.Run("read unknown", func(t *testing.T) {
+		b := newBuckets(t)
+
+		if reader, err := b.OpenRequestReader("unknown"); err == nil {
+			_ = reader.Close()
+			t.Error("expected an error for an unknown body")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		b := newBuckets(t)
+
+		writer, err := b.OpenRequestWriter("request-1")
+		write(t, writer, err, []byte("request"))
+
+		writer, err = b.OpenResponseWriter("request-1")
+		write(t, writer, err, []byte("response"))
+
+		if err := b.Delete("request-1"); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+
+		if reader, err := b.OpenRequestReader("request-1"); err == nil {
+			_ = reader.Close()
+			t.Error("expected the request body to be deleted")
+		}
+
+		if reader, err := b.OpenResponseReader("request-1"); err == nil {
+			_ = reader.Close()
+			t.Error("expected the response body to be deleted")
+		}
+
+		// Deleting twice is not an error.
+		if err := b.Delete("request-1"); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+}
+
+var testKeys = map[string]string{
+	"key-1": "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=",
+	"key-2": "AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=",
+}
+
+// Creates a keyring with the given keys, the first key is the current one. Encryption is disabled without keys.
+func newTestKeyring(t *testing.T, ids ...string) encryption.Keyring {
+	keys := make([]map[string]any, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, map[string]any{"id": id, "key": testKeys[id]})
+	}
+
+	config := viper.New()
+	config.Set("encryption.keys", keys)
+
+	keyring, err := encryption.NewKeyring(config)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	return keyring
+}
+
+func TestMemoryStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		return NewMemoryStore(viper.New())
+	})
+}
+
+func TestSqliteStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		config := viper.New()
+		config.Set("dataFolder", t.TempDir())
+
+		s, err := NewSqliteStore(config, newTestKeyring(t))
+		if err != nil {
+			t.Skipf("SQLite is not available: %v", err)
+		}
+
+		t.Cleanup(func() { _ = s.Close() })
+		return s
+	})
+}
+
+func TestMemoryBucketConformance(t *testing.T) {
+	testBucketsConformance(t, func(t *testing.T) Buckets {
+		return NewMemoryBucket(viper.New())
+	})
+}
+
+func TestFileBucketConformance(t *testing.T) {
+	testBucketsConformance(t, func(t *testing.T) Buckets {
+		config := viper.New()
+		config.Set("dataFolder", t.TempDir())
+
+		return NewFileBucket(config)
+	})
+}
+
+func TestS3BucketConformance(t *testing.T) {
+	testBucketsConformance(t, func(t *testing.T) Buckets {
+		_, server := newFakeS3(t)
+
+		b, err := NewS3Bucket(newFakeS3Config(server))
+		if err != nil {
+			t.Fatalf("failed to create the bucket: %v", err)
+		}
+
+		return b
+	})
+}
+
+func TestCompressedBucketConformance(t *testing.T) {
+	for _, compression := range []string{"none", "gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			testBucketsConformance(t, func(t *testing.T) Buckets {
+				b, err := NewCompressedBuckets(NewMemoryBucket(viper.New()), compression)
+				if err != nil {
+					t.Fatalf("failed to create the bucket: %v", err)
+				}
+
+				return b
+			})
+		})
+	}
+}
+
+func TestEncryptedBucketConformance(t *testing.T) {
+	testBucketsConformance(t, func(t *testing.T) Buckets {
+		config := viper.New()
+		config.Set("dataFolder", t.TempDir())
+
+		return NewEncryptedBuckets(NewFileBucket(config), newTestKeyring(t))
+	})
+}
+
+// The wrappers are combined like on the server.
+func TestConfiguredBucketConformance(t *testing.T) {
+	testBucketsConformance(t, func(t *testing.T) Buckets {
+		config := viper.New()
+		config.Set("dataFolder", t.TempDir())
+		config.Set("storage.type", "file")
+		config.Set("storage.compression", "zstd")
+
+		b, err := NewBuckets(config, newTestKeyring(t))
+		if err != nil {
+			t.Fatalf("failed to create the bucket: %v", err)
+		}
+
+		return b
+	})
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	config := viper.New()
+	config.Set("log.maxEntries", 3)
+
+	s := NewMemoryStore(config)
+
+	for _, requestId := range []string{"request-1", "request-2", "request-3", "request-2", "request-4", "request-5"} {
+		if err := s.LogRequest(requestId, "users", HttpRequestStart{Method: http.MethodPost, Path: "/"}, RecordingFull); err != nil {
+			t.Fatalf("failed to log request: %v", err)
+		}
+	}
+
+	entries, _, err := s.GetEntries(0)
+	if err != nil {
+		t.Fatalf("failed to get entries: %v", err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+
+	// Logging a request again does not count twice, therefore only the oldest two are removed.
+	for requestId, expected := range map[string]bool{"request-1": false, "request-2": false, "request-3": true, "request-4": true, "request-5": true} {
+		entry, err := s.GetEntry(requestId)
+		if err != nil {
+			t.Fatalf("failed to get entry: %v", err)
+		}
+
+		if (entry != nil) != expected {
+			t.Errorf("%s: expected kept %v, got %v", requestId, expected, entry != nil)
+		}
+	}
+}
+
+func TestMemoryBucketEviction(t *testing.T) {
+	config := viper.New()
+	config.Set("log.maxSize", 10)
+
+	b := NewMemoryBucket(config)
+
+	write := func(requestId string, data string) {
+		t.Helper()
+
+		writer, err := b.OpenRequestWriter(requestId)
+		if err != nil {
+			t.Fatalf("failed to open writer: %v", err)
+		}
+
+		if _, err := writer.Write([]byte(data)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+
+		if err := writer.Close(); err != nil {
+			t.Fatalf("failed to close writer: %v", err)
+		}
+	}
+
+	exists := func(requestId string) bool {
+		reader, err := b.OpenRequestReader(requestId)
+		if err != nil {
+			return false
+		}
+
+		_ = reader.Close()
+		return true
+	}
+
+	write("request-1", "1111")
+	write("request-2", "2222")
+
+	// Replacing a blob does not count its old size.
+	write("request-1", "1111")
+
+	write("request-3", "3333")
+
+	if exists("request-2") || !exists("request-1") || !exists("request-3") {
+		t.Errorf("expected the oldest blob to be removed, got %v %v %v", exists("request-1"), exists("request-2"), exists("request-3"))
+	}
+
+	// A blob larger than the limit cannot be kept at all.
+	write("request-4", "44444444444")
+
+	for _, requestId := range []string{"request-1", "request-3", "request-4"} {
+		if exists(requestId) {
+			t.Errorf("expected %s to be removed", requestId)
+		}
+	}
+}
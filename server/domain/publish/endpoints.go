@@ -0,0 +1,48 @@
+package publish
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+)
+
+// The number of attempts to find a random endpoint that is not used yet.
+const randomEndpointAttempts = 10
+
+var (
+	endpointEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// NewRandomEndpoint creates an endpoint name that is hard to guess.
+func NewRandomEndpoint() (string, error) {
+	buffer := make([]byte, 10)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(endpointEncoding.EncodeToString(buffer)), nil
+}
+
+// Checks that the endpoint can be used as subdomain, the host names are compared in lower case.
+func isEndpointLabel(endpoint string) bool {
+	if len(endpoint) == 0 || len(endpoint) > 63 || endpoint[0] == '-' || endpoint[len(endpoint)-1] == '-' {
+		return false
+	}
+
+	for _, c := range endpoint {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Do not store the API keys in plain text, we only have to compare them.
+func getOwner(apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,208 @@
+package publish
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+var (
+	LimiterOrigin = 1002
+)
+
+// Buckets of endpoints without requests are removed after this time, because random endpoints are never used again.
+const bucketIdleTimeout = 10 * time.Minute
+
+// ErrThrottled The request exceeds the configured limits.
+var ErrThrottled = errors.New("Throttled")
+
+type ThrottledError struct {
+	// The time after which the client can retry the request.
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return ErrThrottled.Error()
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrThrottled
+}
+
+// RetryAfterSeconds returns the value for the Retry-After header, which only supports full seconds.
+func (e *ThrottledError) RetryAfterSeconds() int {
+	return int(math.Max(1, math.Ceil(e.RetryAfter.Seconds())))
+}
+
+type Limit struct {
+	// The number of requests per second. Zero means unlimited.
+	Rate float64
+
+	// The maximum burst size.
+	Burst int
+
+	// The maximum number of concurrent requests. Zero means unlimited.
+	MaxInFlight int
+}
+
+type bucket struct {
+	inFlight    int
+	lastUsed    time.Time
+	maxInFlight int
+	rate        *rate.Limiter
+}
+
+type limiter struct {
+	endpointLimit Limit
+	endpoints     map[string]*bucket
+	global        *bucket
+	lastPruned    time.Time
+	lock          sync.Mutex
+	now           func() time.Time
+	overrides     map[string]Limit
+}
+
+type Limiter interface {
+	// Acquire reserves a slot for the request. The release function must be called when the request is completed.
+	Acquire(endpoint string) (func(), error)
+}
+
+func NewLimiter(config *viper.Viper) Limiter {
+	overrides := make(map[string]Limit)
+
+	for endpoint := range config.GetStringMap("endpoints") {
+		section := config.Sub("endpoints." + endpoint + ".limits")
+		if section == nil {
+			continue
+		}
+
+		overrides[endpoint] = readLimit(section)
+	}
+
+	return &limiter{
+		endpointLimit: readLimit(config.Sub("limits.endpoint")),
+		endpoints:     make(map[string]*bucket),
+		global:        newBucket(readLimit(config.Sub("limits.global"))),
+		now:           time.Now,
+		overrides:     overrides,
+	}
+}
+
+func (l *limiter) Acquire(endpoint string) (func(), error) {
+	// Ensure that only a single thread can access the buckets
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+
+	l.prune(now)
+
+	b := l.getBucket(endpoint)
+	b.lastUsed = now
+
+	if b.isFull() || l.global.isFull() {
+		// We cannot know when the next request completes.
+		return nil, &ThrottledError{RetryAfter: time.Second}
+	}
+
+	reservation := b.reserve(now)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return nil, &ThrottledError{RetryAfter: delay}
+	}
+
+	globalReservation := l.global.reserve(now)
+	if delay := globalReservation.DelayFrom(now); delay > 0 {
+		globalReservation.CancelAt(now)
+		reservation.CancelAt(now)
+		return nil, &ThrottledError{RetryAfter: delay}
+	}
+
+	b.inFlight++
+	l.global.inFlight++
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+
+			b.inFlight--
+			l.global.inFlight--
+		})
+	}, nil
+}
+
+func (l *limiter) getBucket(endpoint string) *bucket {
+	b, ok := l.endpoints[endpoint]
+	if ok {
+		return b
+	}
+
+	limit, ok := l.overrides[strings.ToLower(endpoint)]
+	if !ok {
+		limit = l.endpointLimit
+	}
+
+	b = newBucket(limit)
+	l.endpoints[endpoint] = b
+	return b
+}
+
+// Removes the buckets that are idle and have been refilled, so that removing them does not reset any limit.
+func (l *limiter) prune(now time.Time) {
+	if now.Sub(l.lastPruned) < bucketIdleTimeout {
+		return
+	}
+
+	l.lastPruned = now
+
+	for endpoint, b := range l.endpoints {
+		if b.isIdle(now) {
+			delete(l.endpoints, endpoint)
+		}
+	}
+}
+
+func newBucket(limit Limit) *bucket {
+	r := rate.Inf
+	if limit.Rate > 0 {
+		r = rate.Limit(limit.Rate)
+	}
+
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(limit.Rate)))
+	}
+
+	return &bucket{maxInFlight: limit.MaxInFlight, rate: rate.NewLimiter(r, burst)}
+}
+
+func (b *bucket) isFull() bool {
+	return b.maxInFlight > 0 && b.inFlight >= b.maxInFlight
+}
+
+func (b *bucket) isIdle(now time.Time) bool {
+	return b.inFlight == 0 && now.Sub(b.lastUsed) >= bucketIdleTimeout && b.rate.TokensAt(now) >= float64(b.rate.Burst())
+}
+
+func (b *bucket) reserve(now time.Time) *rate.Reservation {
+	return b.rate.ReserveN(now, 1)
+}
+
+func readLimit(section *viper.Viper) Limit {
+	if section == nil {
+		return Limit{}
+	}
+
+	return Limit{
+		Rate:        section.GetFloat64("rate"),
+		Burst:       section.GetInt("burst"),
+		MaxInFlight: section.GetInt("maxInFlight"),
+	}
+}
@@ -0,0 +1,161 @@
+package publish
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Creates a limiter with a clock that only advances when the test moves it.
+func newTestLimiter(config *viper.Viper) (*limiter, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := NewLimiter(config).(*limiter)
+	l.now = func() time.Time { return now }
+
+	return l, &now
+}
+
+func acquireTest(t *testing.T, l Limiter, endpoint string) func() {
+	t.Helper()
+
+	release, err := l.Acquire(endpoint)
+	if err != nil {
+		t.Fatalf("expected %s to be accepted, got %v", endpoint, err)
+	}
+
+	return release
+}
+
+func expectThrottled(t *testing.T, l Limiter, endpoint string, retryAfter time.Duration) {
+	t.Helper()
+
+	_, err := l.Acquire(endpoint)
+
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || !errors.Is(err, ErrThrottled) {
+		t.Fatalf("expected %s to be throttled, got %v", endpoint, err)
+	}
+
+	if throttled.RetryAfter != retryAfter {
+		t.Errorf("expected retry after %v, got %v", retryAfter, throttled.RetryAfter)
+	}
+}
+
+func TestLimiterTokenBucket(t *testing.T) {
+	config := viper.New()
+	config.Set("limits.endpoint.rate", 2)
+	config.Set("limits.endpoint.burst", 3)
+
+	l, now := newTestLimiter(config)
+
+	// The burst is available at once.
+	for i := 0; i < 3; i++ {
+		acquireTest(t, l, "users")
+	}
+
+	expectThrottled(t, l, "users", 500*time.Millisecond)
+
+	// A throttled request does not consume a token.
+	*now = now.Add(500 * time.Millisecond)
+	acquireTest(t, l, "users")
+	expectThrottled(t, l, "users", 500*time.Millisecond)
+
+	*now = now.Add(time.Second)
+	acquireTest(t, l, "users")
+	acquireTest(t, l, "users")
+	expectThrottled(t, l, "users", 500*time.Millisecond)
+}
+
+func TestLimiterGlobalAndEndpointLimits(t *testing.T) {
+	config := viper.New()
+	config.Set("limits.global.rate", 3)
+	config.Set("limits.endpoint.rate", 1)
+	config.Set("endpoints.Stripe.limits.rate", 2)
+
+	l, _ := newTestLimiter(config)
+
+	// Each endpoint has its own bucket.
+	acquireTest(t, l, "users")
+	expectThrottled(t, l, "users", time.Second)
+
+	// The override of the configuration is not case sensitive.
+	acquireTest(t, l, "stripe")
+	acquireTest(t, l, "stripe")
+
+	// The global limit applies to the sum of all endpoints.
+	expectThrottled(t, l, "orders", time.Second/3)
+}
+
+func TestLimiterMaxInFlight(t *testing.T) {
+	config := viper.New()
+	config.Set("limits.global.maxInFlight", 3)
+	config.Set("limits.endpoint.maxInFlight", 2)
+
+	l, _ := newTestLimiter(config)
+
+	first := acquireTest(t, l, "users")
+	acquireTest(t, l, "users")
+
+	// It cannot be known when the next request completes.
+	expectThrottled(t, l, "users", time.Second)
+
+	acquireTest(t, l, "orders")
+	expectThrottled(t, l, "orders", time.Second)
+
+	// Releasing twice must not free two slots.
+	first()
+	first()
+
+	acquireTest(t, l, "users")
+	expectThrottled(t, l, "users", time.Second)
+}
+
+func TestLimiterPrunesIdleBuckets(t *testing.T) {
+	config := viper.New()
+	config.Set("limits.endpoint.rate", 1)
+	config.Set("limits.endpoint.maxInFlight", 1)
+
+	l, now := newTestLimiter(config)
+
+	// The first call prunes, therefore the following calls prune only after the timeout.
+	acquireTest(t, l, "random")()
+	acquireTest(t, l, "busy")
+
+	*now = now.Add(bucketIdleTimeout - time.Second)
+	acquireTest(t, l, "users")()
+
+	if len(l.endpoints) != 3 {
+		t.Fatalf("expected 3 buckets before the timeout, got %d", len(l.endpoints))
+	}
+
+	*now = now.Add(time.Second)
+	acquireTest(t, l, "orders")()
+
+	// Buckets with requests in flight or recent requests keep their limits.
+	if _, ok := l.endpoints["random"]; ok || len(l.endpoints) != 3 {
+		t.Errorf("expected only the idle bucket to be removed, got %v", l.endpoints)
+	}
+
+	expectThrottled(t, l, "busy", time.Second)
+}
+
+func TestThrottledErrorRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		expected   int
+	}{
+		{retryAfter: 0, expected: 1},
+		{retryAfter: 200 * time.Millisecond, expected: 1},
+		{retryAfter: time.Second, expected: 1},
+		{retryAfter: 1500 * time.Millisecond, expected: 2},
+	}
+
+	for _, test := range tests {
+		if actual := (&ThrottledError{RetryAfter: test.retryAfter}).RetryAfterSeconds(); actual != test.expected {
+			t.Errorf("%v: expected %d, got %d", test.retryAfter, test.expected, actual)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package publish
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 type HttpRequestStart struct {
 	// The actual request
@@ -14,6 +17,12 @@ type HttpRequestStart struct {
 
 	// The request headers.
 	Headers http.Header
+
+	// The result of the signature verification.
+	Verification Verification
+
+	// The W3C trace context of the span that forwards the request.
+	TraceContext map[string]string
 }
 
 type HttpRequestData struct {
@@ -36,6 +45,9 @@ type HttpResponseStart struct {
 
 	// The status code.
 	Status int32
+
+	// The time the client needed to receive the response start from the local server.
+	LocalDuration time.Duration
 }
 
 type HttpResponseData struct {
@@ -59,3 +71,25 @@ type HttpError struct {
 	// Indicate if the error is a timeout
 	Timeout bool
 }
+
+type Redaction struct {
+	// The part of the request that has been redacted.
+	Location RedactionLocation `json:"location"`
+
+	// The name of the header or the path of the JSON field.
+	Name string `json:"name"`
+}
+
+type Timings struct {
+	// The request has been forwarded to the client completely.
+	RequestForwarded *time.Time `json:"requestForwarded,omitempty"`
+
+	// The response start has been received from the client.
+	ResponseStarted *time.Time `json:"responseStarted,omitempty"`
+
+	// The first chunk of the response body has been received from the client.
+	FirstResponseByte *time.Time `json:"firstResponseByte,omitempty"`
+
+	// The round-trip time to the local server as measured by the client.
+	LocalDuration time.Duration `json:"localDuration,omitempty"`
+}
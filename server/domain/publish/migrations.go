@@ -0,0 +1,124 @@
+package publish
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type migration struct {
+	// The version must be unique and greater than the version of the previous migration.
+	version int
+
+	// Applies the changes. The version is only recorded if no error is returned.
+	up func(tx *sql.Tx) error
+}
+
+// Prevents that several instances, which share the database, run the migrations at the same time. Returns the function to release the lock.
+type migrationLock func(ctx context.Context, conn *sql.Conn) (func(), error)
+
+const (
+	versionTableDefinition string = `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version			INT NOT NULL
+		)`
+)
+
+// Runs all migrations with a higher version than the version stored in the database. Each migration has its own transaction.
+func migrate(db *sql.DB, bind func(query string) string, lock migrationLock, migrations []migration) error {
+	ctx := context.Background()
+
+	// Use a single connection, because the lock belongs to the session.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if lock != nil {
+		unlock, err := lock(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("failed to lock the migrations: %w", err)
+		}
+
+		defer unlock()
+	}
+
+	if _, err := conn.ExecContext(ctx, versionTableDefinition); err != nil {
+		return err
+	}
+
+	// Read the version after the lock has been acquired, another instance might have applied the migrations in the meantime.
+	var current sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&current); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if int64(m.version) <= current.Int64 {
+			continue
+		}
+
+		if err := runMigration(ctx, conn, bind, m); err != nil {
+			return fmt.Errorf("failed to run migration %d: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+func runMigration(ctx context.Context, conn *sql.Conn, bind func(query string) string, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Rollback has no effect when the transaction has been committed.
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(bind("INSERT INTO schema_version(version) VALUES (?)"), m.version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func execAll(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func bindQuestion(query string) string {
+	return query
+}
+
+// Postgres uses numbered placeholders ($1, $2, ...) instead of question marks.
+func bindNumbered(query string) string {
+	var sb strings.Builder
+
+	index := 0
+	for _, c := range query {
+		if c == '?' {
+			index++
+			sb.WriteString("$" + strconv.Itoa(index))
+		} else {
+			sb.WriteRune(c)
+		}
+	}
+
+	return sb.String()
+}
@@ -1,42 +1,134 @@
 package publish
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+	"wh/domain/metrics"
+	"wh/domain/redaction"
+	"wh/domain/tracing"
 
 	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
 	"go.uber.org/zap"
 )
 
+type TunnelInfo struct {
+	// The endpoint the client is subscribed to.
+	Endpoint string `json:"endpoint"`
+
+	// The version of the CLI, if reported by the client.
+	ClientVersion string `json:"clientVersion"`
+
+	// Identifies the API key without revealing it, because API keys do not have names.
+	ApiKeyName string `json:"apiKeyName"`
+
+	// The address of the client.
+	RemoteAddress string `json:"remoteAddress"`
+
+	// The time the client has subscribed to the endpoint.
+	Connected time.Time `json:"connected"`
+
+	// The number of requests that are currently forwarded to the client.
+	InFlight int64 `json:"inFlight"`
+
+	// The number of requests that have been forwarded to the client.
+	Total int64 `json:"total"`
+}
+
+type subscription struct {
+	disconnect func()
+	handler    func(*TunneledRequest)
+	inFlight   atomic.Int64
+	info       TunnelInfo
+	total      atomic.Int64
+}
+
 type publisher struct {
-	endpoints map[string]func(*TunneledRequest)
-	buckets   Buckets
-	lock      sync.RWMutex
-	logger    *zap.Logger
-	store     Store
+	endpoints  map[string]*subscription
+	buckets    Buckets
+	draining   bool
+	limiter    Limiter
+	lock       sync.RWMutex
+	logger     *zap.Logger
+	metrics    metrics.Metrics
+	pending    map[string]*TunneledRequest
+	policies   RecordingPolicies
+	redactor   redaction.Redactor
+	store      Store
+	subdomains bool
+	tracing    tracing.Tracing
 }
 
+var (
+	PublisherOrigin = 1003
+)
+
 // ErrAlreadyRegistered There is already a request handler.
 var ErrAlreadyRegistered = errors.New("AlreadyRegistered")
 
 // ErrNotRegistered There is no listener.
 var ErrNotRegistered = errors.New("NotRegistered")
 
+// ErrReserved The endpoint has been reserved by another API key.
+var ErrReserved = errors.New("Reserved")
+
+// ErrInvalidEndpoint The endpoint name is not valid.
+var ErrInvalidEndpoint = errors.New("InvalidEndpoint")
+
+// ErrShuttingDown The server does not accept new requests, because it is shutting down.
+var ErrShuttingDown = errors.New("ShuttingDown")
+
 type Publisher interface {
-	Subscribe(endpoint string, handler func(*TunneledRequest)) error
+	// Subscribe registers the handler for the requests of the endpoint. The disconnect function closes the tunnel of the client.
+	Subscribe(endpoint string, apiKey string, info TunnelInfo, handler func(*TunneledRequest), disconnect func()) error
+
+	SubscribeRandom(apiKey string, info TunnelInfo, handler func(*TunneledRequest), disconnect func()) (string, error)
+
+	// GetTunnels returns the connected clients, ordered by endpoint.
+	GetTunnels() []TunnelInfo
+
+	// Disconnect forcibly closes the tunnel of the endpoint.
+	Disconnect(endpoint string) error
+
+	// Shutdown rejects new requests and subscriptions.
+	Shutdown()
+
+	// Drain waits until the pending requests have been completed and recorded and disconnects all tunnels afterwards.
+	// Requests that are still pending when the context is done are terminated.
+	Drain(ctx context.Context) error
+
+	Reserve(endpoint string, apiKey string) error
 
 	Unsubscribe(endpoint string)
 
 	ForwardRequest(endpoint string, request HttpRequestStart) (*TunneledRequest, error)
+
+	Reject(endpoint string, request HttpRequestStart, response HttpResponseStart, reason error)
 }
 
-func NewPublisher(store Store, buckets Buckets, logger *zap.Logger) Publisher {
+func NewPublisher(store Store, buckets Buckets, limiter Limiter, policies RecordingPolicies, redactor redaction.Redactor, metrics metrics.Metrics, tracing tracing.Tracing, config *viper.Viper, logger *zap.Logger) Publisher {
 	return &publisher{
-		endpoints: make(map[string]func(*TunneledRequest)),
-		buckets:   buckets,
-		lock:      sync.RWMutex{},
-		logger:    logger,
-		store:     store,
+		endpoints:  make(map[string]*subscription),
+		buckets:    buckets,
+		limiter:    limiter,
+		lock:       sync.RWMutex{},
+		logger:     logger,
+		metrics:    metrics,
+		pending:    make(map[string]*TunneledRequest),
+		policies:   policies,
+		redactor:   redactor,
+		store:      store,
+		subdomains: config.GetString("http.baseDomain") != "",
+		tracing:    tracing,
 	}
 }
 
@@ -48,41 +140,225 @@ func (p *publisher) Unsubscribe(endpoint string) {
 	delete(p.endpoints, endpoint)
 }
 
-func (p *publisher) Subscribe(endpoint string, handler func(request *TunneledRequest)) error {
+func (p *publisher) Subscribe(endpoint string, apiKey string, info TunnelInfo, handler func(request *TunneledRequest), disconnect func()) error {
+	if err := p.checkEndpoint(endpoint); err != nil {
+		return err
+	}
+
+	if err := p.checkOwner(endpoint, apiKey); err != nil {
+		return err
+	}
+
 	// Ensure that only a single thread can access the map
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
+	if p.draining {
+		return ErrShuttingDown
+	}
+
 	registration, ok := p.endpoints[endpoint]
 	if ok || registration != nil {
 		return ErrAlreadyRegistered
 	}
 
-	p.endpoints[endpoint] = handler
+	info.Endpoint = endpoint
+	info.ApiKeyName = getOwner(apiKey)[:8]
+	if info.Connected.IsZero() {
+		info.Connected = time.Now()
+	}
+
+	p.endpoints[endpoint] = &subscription{disconnect: disconnect, handler: handler, info: info}
 	return nil
 }
 
+func (p *publisher) SubscribeRandom(apiKey string, info TunnelInfo, handler func(request *TunneledRequest), disconnect func()) (string, error) {
+	for i := 0; i < randomEndpointAttempts; i++ {
+		endpoint, err := NewRandomEndpoint()
+		if err != nil {
+			return "", err
+		}
+
+		err = p.Subscribe(endpoint, apiKey, info, handler, disconnect)
+		if errors.Is(err, ErrAlreadyRegistered) || errors.Is(err, ErrReserved) {
+			// Very unlikely, but just try the next name.
+			continue
+		}
+
+		return endpoint, err
+	}
+
+	return "", ErrAlreadyRegistered
+}
+
+func (p *publisher) Reserve(endpoint string, apiKey string) error {
+	if err := p.checkEndpoint(endpoint); err != nil {
+		return err
+	}
+
+	if err := p.checkOwner(endpoint, apiKey); err != nil {
+		return err
+	}
+
+	return p.store.ReserveEndpoint(endpoint, getOwner(apiKey))
+}
+
 func (p *publisher) ForwardRequest(endpoint string, request HttpRequestStart) (*TunneledRequest, error) {
 	requestId := uuid.New().String()
 
-	handler, err := p.getHandler(endpoint)
+	ctx, span := p.tracing.Start(p.tracing.Extract(context.Background(), propagation.MapCarrier(request.TraceContext)), "forward")
+	span.SetAttributes(
+		attribute.String("wh.endpoint", endpoint),
+		attribute.String("wh.request_id", requestId),
+	)
+
+	sub, err := p.getSubscription(endpoint)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
 		return nil, err
 	}
 
+	release, err := p.limiter.Acquire(endpoint)
+	if err != nil {
+		var throttled *ThrottledError
+		if errors.As(err, &throttled) {
+			headers := make(http.Header)
+			headers.Set("Retry-After", strconv.Itoa(throttled.RetryAfterSeconds()))
+
+			p.reject(endpoint, request, HttpResponseStart{Status: http.StatusTooManyRequests, Headers: headers}, err, StatusThrottled)
+		}
+
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+
+	// The tunnel continues the trace as child of the publisher.
+	request.TraceContext = p.tracing.Inject(ctx)
+
 	req := NewTunneledRequest(endpoint, requestId, request, p.logger)
 
+	if err := p.track(req); err != nil {
+		release()
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+
+	p.metrics.RequestStarted()
+	sub.inFlight.Add(1)
+	sub.total.Add(1)
+
+	// Free the slot as soon as the request is terminated.
+	req.OnResponseData(LimiterOrigin, func(msg HttpResponseData) {
+		if msg.Completed {
+			release()
+			p.metrics.RequestEnded()
+			sub.inFlight.Add(-1)
+			span.End()
+		}
+	})
+	req.OnError(LimiterOrigin, func(msg HttpError) {
+		release()
+		p.metrics.RequestEnded()
+		sub.inFlight.Add(-1)
+
+		if msg.Error != nil {
+			span.SetStatus(codes.Error, msg.Error.Error())
+		} else if msg.Timeout {
+			span.SetStatus(codes.Error, "timeout")
+		}
+		span.End()
+	})
+
 	// Record the request details and store them in a file and database.
-	rec := NewRecorder(req, p.store, p.buckets, p.logger)
-	rec.Listen(req)
+	if policy, ok := p.policies.Sample(endpoint); ok {
+		rec := NewRecorder(req, p.store, p.buckets, policy, p.redactor.GetRules(endpoint), p.metrics, p.logger)
+		rec.Listen(req)
+	}
+
+	// The listeners are called in order, therefore the request has been recorded when it is untracked.
+	req.OnResponseData(PublisherOrigin, func(msg HttpResponseData) {
+		if msg.Completed {
+			p.untrack(req)
+		}
+	})
+	req.OnError(PublisherOrigin, func(msg HttpError) {
+		p.untrack(req)
+	})
 
 	// Publish the request first, so that we can receive events.
-	handler(req)
+	sub.handler(req)
 
 	return req, nil
 }
 
-func (p *publisher) getHandler(endpoint string) (func(*TunneledRequest), error) {
+func (p *publisher) Reject(endpoint string, request HttpRequestStart, response HttpResponseStart, reason error) {
+	p.reject(endpoint, request, response, reason, StatusRejected)
+}
+
+func (p *publisher) reject(endpoint string, request HttpRequestStart, response HttpResponseStart, reason error, status Status) {
+	if p.policies.Get(endpoint).Mode == RecordingOff {
+		return
+	}
+
+	requestId := uuid.New().String()
+
+	if response.Headers == nil {
+		response.Headers = make(http.Header)
+	}
+
+	redacted := make([]Redaction, 0)
+
+	// Rejected requests are never forwarded, but their headers can still contain secrets.
+	headers, names := p.redactor.GetRules(endpoint).RedactHeaders(request.Headers)
+	for _, name := range names {
+		redacted = append(redacted, Redaction{Location: RedactionRequestHeader, Name: name})
+	}
+
+	request.Headers = headers
+
+	// Rejected requests are never forwarded, but we still want to see them in the logs.
+	if err := p.store.LogRequest(requestId, endpoint, request, RecordingMetadata); err != nil {
+		p.metrics.StoreError("logRequest")
+		p.logger.Error("Failed to record rejected request",
+			zap.Error(err),
+		)
+		return
+	}
+
+	if err := p.store.LogResponse(requestId, ResponseLog{Response: &response, Error: reason, Status: status, Redacted: redacted}); err != nil {
+		p.metrics.StoreError("logResponse")
+		p.logger.Error("Failed to record rejected request",
+			zap.Error(err),
+		)
+	}
+}
+
+// When the endpoints are routed by subdomain, the names must be valid host names.
+func (p *publisher) checkEndpoint(endpoint string) error {
+	if endpoint == "" || (p.subdomains && !isEndpointLabel(endpoint)) {
+		return ErrInvalidEndpoint
+	}
+
+	return nil
+}
+
+func (p *publisher) checkOwner(endpoint string, apiKey string) error {
+	reservation, err := p.store.GetEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if reservation != nil && reservation.Owner != getOwner(apiKey) {
+		return ErrReserved
+	}
+
+	return nil
+}
+
+func (p *publisher) getSubscription(endpoint string) (*subscription, error) {
 	// Ensure that only a single thread can access the map
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -94,3 +370,118 @@ func (p *publisher) getHandler(endpoint string) (func(*TunneledRequest), error)
 
 	return byEndpoint, nil
 }
+
+func (p *publisher) GetTunnels() []TunnelInfo {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	result := make([]TunnelInfo, 0, len(p.endpoints))
+	for _, s := range p.endpoints {
+		info := s.info
+		info.InFlight = s.inFlight.Load()
+		info.Total = s.total.Load()
+
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Endpoint < result[j].Endpoint
+	})
+
+	return result
+}
+
+func (p *publisher) Disconnect(endpoint string) error {
+	sub, err := p.getSubscription(endpoint)
+	if err != nil {
+		return err
+	}
+
+	// The tunnel unsubscribes itself when it is closed.
+	sub.disconnect()
+	return nil
+}
+
+func (p *publisher) Shutdown() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.draining = true
+}
+
+func (p *publisher) Drain(ctx context.Context) error {
+	p.Shutdown()
+
+	// Also disconnect the tunnels when the deadline has been exceeded.
+	defer p.disconnectAll()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		p.lock.RLock()
+		pending := len(p.pending)
+		p.lock.RUnlock()
+
+		if pending == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			p.terminatePending()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *publisher) track(request *TunneledRequest) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.draining {
+		return ErrShuttingDown
+	}
+
+	p.pending[request.RequestId] = request
+	return nil
+}
+
+func (p *publisher) untrack(request *TunneledRequest) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.pending, request.RequestId)
+}
+
+func (p *publisher) terminatePending() {
+	p.lock.RLock()
+	pending := make([]*TunneledRequest, 0, len(p.pending))
+	for _, request := range p.pending {
+		pending = append(pending, request)
+	}
+	p.lock.RUnlock()
+
+	// Emit the errors without the lock, because the listeners need it.
+	for _, request := range pending {
+		request.EmitError(PublisherOrigin, ErrShuttingDown, false)
+
+		// The listener of the publisher is not called for its own events.
+		p.untrack(request)
+	}
+}
+
+func (p *publisher) disconnectAll() {
+	p.lock.RLock()
+	subscriptions := make([]*subscription, 0, len(p.endpoints))
+	for _, s := range p.endpoints {
+		subscriptions = append(subscriptions, s)
+	}
+	p.lock.RUnlock()
+
+	// The tunnels unsubscribe themselves, which requires the lock.
+	for _, s := range subscriptions {
+		s.disconnect()
+	}
+}
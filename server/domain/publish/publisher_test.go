@@ -0,0 +1,270 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"wh/domain/metrics"
+	"wh/domain/redaction"
+	"wh/domain/tracing"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// The origin of the events that are emitted by the tests.
+var testOrigin = 9999
+
+func newTestPublisher(t *testing.T, config *viper.Viper) (Publisher, Store) {
+	store := NewMemoryStore(config)
+
+	policies, err := NewRecordingPolicies(store, config)
+	if err != nil {
+		t.Fatalf("failed to create policies: %v", err)
+	}
+
+	redactor, err := redaction.NewRedactor(config)
+	if err != nil {
+		t.Fatalf("failed to create redactor: %v", err)
+	}
+
+	tr, err := tracing.NewTracing(config)
+	if err != nil {
+		t.Fatalf("failed to create tracing: %v", err)
+	}
+
+	return NewPublisher(store, NewMemoryBucket(config), NewLimiter(config), policies, redactor, metrics.NewMetrics(), tr, config, zap.NewNop()), store
+}
+
+// Subscribes a client that passes the requests to the test and reports when it has been disconnected.
+func subscribeTest(t *testing.T, p Publisher, endpoint string) (chan *TunneledRequest, chan bool) {
+	requests := make(chan *TunneledRequest, 1)
+	disconnected := make(chan bool, 1)
+
+	handler := func(request *TunneledRequest) {
+		requests <- request
+	}
+
+	if err := p.Subscribe(endpoint, "", TunnelInfo{}, handler, func() { disconnected <- true }); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	return requests, disconnected
+}
+
+func forwardTest(t *testing.T, p Publisher, endpoint string) *TunneledRequest {
+	request, err := p.ForwardRequest(endpoint, HttpRequestStart{Method: http.MethodPost, Path: "/", Headers: http.Header{}})
+	if err != nil {
+		t.Fatalf("failed to forward request: %v", err)
+	}
+
+	request.EmitRequestData(testOrigin, []byte("{}"), true)
+	return request
+}
+
+func TestPublisherRejectsRequestsAfterShutdown(t *testing.T) {
+	p, _ := newTestPublisher(t, viper.New())
+	subscribeTest(t, p, "users")
+
+	p.Shutdown()
+
+	if _, err := p.ForwardRequest("users", HttpRequestStart{Method: http.MethodPost, Path: "/", Headers: http.Header{}}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected %v, got %v", ErrShuttingDown, err)
+	}
+
+	if err := p.Subscribe("orders", "", TunnelInfo{}, func(*TunneledRequest) {}, func() {}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected %v for new tunnels, got %v", ErrShuttingDown, err)
+	}
+}
+
+func TestPublisherDrainWaitsForPendingRequests(t *testing.T) {
+	p, store := newTestPublisher(t, viper.New())
+	requests, disconnected := subscribeTest(t, p, "users")
+
+	request := forwardTest(t, p, "users")
+	<-requests
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- p.Drain(context.Background())
+	}()
+
+	select {
+	case err := <-drained:
+		t.Fatalf("expected drain to wait for the pending request, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	request.EmitResponse(testOrigin, http.Header{}, http.StatusOK, 0)
+	request.EmitResponseData(testOrigin, []byte("ok"), true)
+
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("failed to drain: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected drain to return after the request has been completed")
+	}
+
+	// The request is untracked after it has been recorded.
+	entry, err := store.GetEntry(request.RequestId)
+	if err != nil || entry == nil || entry.Status != StatusCompleted || entry.Response == nil {
+		t.Errorf("expected the completed request to be recorded, got %+v, %v", entry, err)
+	}
+
+	select {
+	case <-disconnected:
+	default:
+		t.Error("expected the tunnel to be disconnected after draining")
+	}
+}
+
+func TestPublisherDrainTerminatesPendingRequestsAtDeadline(t *testing.T) {
+	p, store := newTestPublisher(t, viper.New())
+	requests, disconnected := subscribeTest(t, p, "users")
+
+	request := forwardTest(t, p, "users")
+	<-requests
+
+	errs := make(chan HttpError, 1)
+	request.OnError(testOrigin, func(msg HttpError) {
+		errs <- msg
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := p.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	select {
+	case msg := <-errs:
+		if !errors.Is(msg.Error, ErrShuttingDown) {
+			t.Errorf("expected %v, got %v", ErrShuttingDown, msg.Error)
+		}
+	default:
+		t.Fatal("expected the pending request to be terminated")
+	}
+
+	entry, err := store.GetEntry(request.RequestId)
+	if err != nil || entry == nil || entry.Error == nil {
+		t.Errorf("expected the terminated request to be recorded with the error, got %+v, %v", entry, err)
+	}
+
+	select {
+	case <-disconnected:
+	default:
+		t.Error("expected the tunnel to be disconnected at the deadline")
+	}
+}
+
+func TestPublisherReleasesSlotWhenRequestTerminates(t *testing.T) {
+	tests := []struct {
+		name      string
+		terminate func(request *TunneledRequest)
+	}{
+		{
+			name: "completed",
+			terminate: func(request *TunneledRequest) {
+				request.EmitResponse(testOrigin, http.Header{}, http.StatusOK, 0)
+				request.EmitResponseData(testOrigin, []byte("ok"), true)
+			},
+		},
+		{
+			name: "failed",
+			terminate: func(request *TunneledRequest) {
+				request.EmitError(testOrigin, errors.New("local server not reachable"), false)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := viper.New()
+			config.Set("limits.endpoint.maxInFlight", 1)
+
+			p, store := newTestPublisher(t, config)
+			requests, _ := subscribeTest(t, p, "users")
+
+			request := forwardTest(t, p, "users")
+			<-requests
+
+			_, err := p.ForwardRequest("users", HttpRequestStart{Method: http.MethodPost, Path: "/", Headers: http.Header{}})
+			if !errors.Is(err, ErrThrottled) {
+				t.Fatalf("expected %v, got %v", ErrThrottled, err)
+			}
+
+			// The throttled request is recorded, but not forwarded.
+			entries, _, err := store.GetEntries(0)
+			if err != nil || len(entries) != 2 {
+				t.Fatalf("expected the throttled request to be recorded, got %v, %v", entries, err)
+			}
+
+			test.terminate(request)
+
+			forwardTest(t, p, "users")
+			<-requests
+		})
+	}
+}
+
+func TestPublisherValidatesEndpointsForSubdomains(t *testing.T) {
+	tests := []struct {
+		name       string
+		baseDomain string
+		endpoint   string
+		valid      bool
+	}{
+		{name: "label", baseDomain: "example.com", endpoint: "users-2", valid: true},
+		{name: "uppercase", baseDomain: "example.com", endpoint: "Users", valid: false},
+		{name: "nested subdomain", baseDomain: "example.com", endpoint: "api.users", valid: false},
+		{name: "leading hyphen", baseDomain: "example.com", endpoint: "-users", valid: false},
+		{name: "too long", baseDomain: "example.com", endpoint: strings.Repeat("a", 64), valid: false},
+		{name: "empty", baseDomain: "example.com", endpoint: "", valid: false},
+		// The names are only part of the path without a base domain.
+		{name: "path", baseDomain: "", endpoint: "Users.v2", valid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := viper.New()
+			config.Set("http.baseDomain", test.baseDomain)
+
+			p, _ := newTestPublisher(t, config)
+
+			subscribeErr := p.Subscribe(test.endpoint, "", TunnelInfo{}, func(*TunneledRequest) {}, func() {})
+			reserveErr := p.Reserve(test.endpoint, "")
+
+			for _, err := range []error{subscribeErr, reserveErr} {
+				if test.valid && err != nil {
+					t.Errorf("expected the endpoint to be accepted, got %v", err)
+				}
+
+				if !test.valid && !errors.Is(err, ErrInvalidEndpoint) {
+					t.Errorf("expected %v, got %v", ErrInvalidEndpoint, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPublisherRandomEndpointsAreSubdomains(t *testing.T) {
+	config := viper.New()
+	config.Set("http.baseDomain", "example.com")
+
+	p, _ := newTestPublisher(t, config)
+
+	endpoint, err := p.SubscribeRandom("", TunnelInfo{}, func(*TunneledRequest) {}, func() {})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	if !isEndpointLabel(endpoint) {
+		t.Errorf("expected a valid label, got %s", endpoint)
+	}
+}
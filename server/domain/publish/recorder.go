@@ -1,7 +1,12 @@
 package publish
 
 import (
+	"bytes"
 	"io"
+	"net/http"
+	"time"
+	"wh/domain/metrics"
+	"wh/domain/redaction"
 
 	"go.uber.org/zap"
 )
@@ -11,31 +16,63 @@ var (
 )
 
 type recorder struct {
-	buckets        Buckets
-	store          Store
-	logger         *zap.Logger
-	request        *TunneledRequest
-	requestSize    int
-	requestWriter  io.WriteCloser
-	responseSize   int
-	responseWriter io.WriteCloser
-	response       *HttpResponseStart
-	error          error
-}
-
-func NewRecorder(request *TunneledRequest, store Store, buckets Buckets, logger *zap.Logger) *recorder {
-	if err := store.LogRequest(request.RequestId, request.Endpoint, request.Request); err != nil {
+	buckets           Buckets
+	store             Store
+	logger            *zap.Logger
+	metrics           metrics.Metrics
+	request           *TunneledRequest
+	requestBody       *bodyBuffer
+	requestCompleted  bool
+	requestSize       int
+	requestTruncated  bool
+	requestWriter     io.WriteCloser
+	responseBody      *bodyBuffer
+	responseCompleted bool
+	responseSize      int
+	responseTruncated bool
+	responseWriter    io.WriteCloser
+	response          *HttpResponseStart
+	error             error
+	policy            RecordingPolicy
+	redacted          []Redaction
+	rules             *redaction.Rules
+	timings           Timings
+}
+
+// Bodies with redaction rules are buffered, because the JSON can only be redacted as a whole.
+type bodyBuffer struct {
+	data    bytes.Buffer
+	dropped bool
+}
+
+func NewRecorder(request *TunneledRequest, store Store, buckets Buckets, policy RecordingPolicy, rules *redaction.Rules, metrics metrics.Metrics, logger *zap.Logger) *recorder {
+	l := &recorder{
+		buckets:  buckets,
+		logger:   logger,
+		metrics:  metrics,
+		policy:   policy,
+		redacted: make([]Redaction, 0),
+		request:  request,
+		rules:    rules,
+		store:    store,
+	}
+
+	// Never modify the actual request, because it is forwarded to the client.
+	logged := request.Request
+	logged.Headers = l.redactHeaders(logged.Headers, RedactionRequestHeader)
+
+	if l.recordsBody() && rules.RedactsBody() {
+		l.requestBody = &bodyBuffer{}
+	}
+
+	if err := store.LogRequest(request.RequestId, request.Endpoint, logged, policy.Mode); err != nil {
+		l.metrics.StoreError("logRequest")
 		logger.Error("Failed to record request",
 			zap.Error(err),
 		)
 	}
 
-	return &recorder{
-		buckets: buckets,
-		logger:  logger,
-		request: request,
-		store:   store,
-	}
+	return l
 }
 
 func (l *recorder) Listen(request *TunneledRequest) {
@@ -46,11 +83,21 @@ func (l *recorder) Listen(request *TunneledRequest) {
 }
 
 func (l *recorder) OnRequestData(msg HttpRequestData) {
+	if !l.recordsBody() {
+		l.requestSize += len(msg.Data)
+	}
+
 	data := msg.Data
-	if len(data) > 0 {
+	if l.requestBody == nil {
+		data = l.limitBody(data, l.requestSize, &l.requestTruncated)
+	}
+	if len(data) > 0 && l.requestBody != nil {
+		l.requestBody.append(data, l.rules.MaxBodySize)
+	} else if len(data) > 0 {
 		if l.requestWriter == nil {
 			writer, err := l.buckets.OpenRequestWriter(l.request.RequestId)
 			if err != nil {
+				l.metrics.RecorderError("open")
 				l.logger.Error("Failed to open response writer",
 					zap.Error(err),
 				)
@@ -62,6 +109,7 @@ func (l *recorder) OnRequestData(msg HttpRequestData) {
 		n, err := l.requestWriter.Write(data)
 		if err != nil {
 			l.requestSize = -1
+			l.metrics.RecorderError("write")
 			l.logger.Error("Failed to write to request writer",
 				zap.Error(err),
 			)
@@ -71,20 +119,46 @@ func (l *recorder) OnRequestData(msg HttpRequestData) {
 	}
 
 	if msg.Completed {
+		l.requestCompleted = true
 		l.closeRequestWriter()
 	}
 }
 
 func (l *recorder) OnResponseStart(msg HttpResponseStart) {
+	if l.recordsBody() && l.rules.RedactsBody() {
+		l.responseBody = &bodyBuffer{}
+	}
+
+	msg.Headers = l.redactHeaders(msg.Headers, RedactionResponseHeader)
+
+	now := time.Now()
+	l.timings.ResponseStarted = &now
+	l.timings.LocalDuration = msg.LocalDuration
+
 	l.response = &msg
 }
 
 func (l *recorder) OnResponseData(msg HttpResponseData) {
+	if len(msg.Data) > 0 && l.timings.FirstResponseByte == nil {
+		now := time.Now()
+		l.timings.FirstResponseByte = &now
+	}
+
+	if !l.recordsBody() {
+		l.responseSize += len(msg.Data)
+	}
+
 	data := msg.Data
-	if len(data) > 0 {
+	if l.responseBody == nil {
+		data = l.limitBody(data, l.responseSize, &l.responseTruncated)
+	}
+	if len(data) > 0 && l.responseBody != nil {
+		l.responseBody.append(data, l.rules.MaxBodySize)
+	} else if len(data) > 0 {
 		if l.responseWriter == nil {
 			writer, err := l.buckets.OpenResponseWriter(l.request.RequestId)
 			if err != nil {
+				l.metrics.RecorderError("open")
 				l.logger.Error("Failed to open response writer",
 					zap.Error(err),
 				)
@@ -96,6 +170,7 @@ func (l *recorder) OnResponseData(msg HttpResponseData) {
 		n, err := l.responseWriter.Write(data)
 		if err != nil {
 			l.responseSize = -1
+			l.metrics.RecorderError("write")
 			l.logger.Error("Failed to write to response writer",
 				zap.Error(err),
 			)
@@ -105,6 +180,7 @@ func (l *recorder) OnResponseData(msg HttpResponseData) {
 	}
 
 	if msg.Completed {
+		l.responseCompleted = true
 		l.complete(nil)
 	}
 }
@@ -117,14 +193,30 @@ func (l *recorder) complete(requestError error) {
 	l.closeRequestWriter()
 	l.closeResponseWriter()
 
-	err := l.store.LogResponse(
-		l.request.RequestId,
-		l.requestSize,
-		l.response,
-		l.responseSize,
-		requestError,
-		l.request.Status)
+	// Keep the bodies of failed requests, because they are useful for debugging, but mark them as incomplete.
+	if l.recordsBody() && !l.requestCompleted && l.requestSize > 0 {
+		l.requestTruncated = true
+	}
+
+	if l.recordsBody() && !l.responseCompleted && l.responseSize > 0 {
+		l.responseTruncated = true
+	}
+
+	l.timings.RequestForwarded = l.request.Forwarded()
+
+	err := l.store.LogResponse(l.request.RequestId, ResponseLog{
+		RequestSize:       l.requestSize,
+		Response:          l.response,
+		ResponseSize:      l.responseSize,
+		Error:             requestError,
+		Status:            l.request.Status,
+		Redacted:          l.redacted,
+		RequestTruncated:  l.requestTruncated,
+		ResponseTruncated: l.responseTruncated,
+		Timings:           l.timings,
+	})
 	if err != nil {
+		l.metrics.StoreError("logResponse")
 		l.logger.Error("Failed to update request",
 			zap.Error(err),
 		)
@@ -132,6 +224,11 @@ func (l *recorder) complete(requestError error) {
 }
 
 func (l *recorder) closeRequestWriter() {
+	if l.requestBody != nil {
+		l.requestSize = l.writeRedactedBody(l.requestBody, RedactionRequestBody, l.buckets.OpenRequestWriter, &l.requestTruncated)
+		l.requestBody = nil
+	}
+
 	if l.requestWriter == nil {
 		return
 	}
@@ -141,6 +238,7 @@ func (l *recorder) closeRequestWriter() {
 	}()
 
 	if err := l.requestWriter.Close(); err != nil {
+		l.metrics.RecorderError("close")
 		l.logger.Error("Failed to close request writer",
 			zap.Error(err),
 		)
@@ -148,6 +246,11 @@ func (l *recorder) closeRequestWriter() {
 }
 
 func (l *recorder) closeResponseWriter() {
+	if l.responseBody != nil {
+		l.responseSize = l.writeRedactedBody(l.responseBody, RedactionResponseBody, l.buckets.OpenResponseWriter, &l.responseTruncated)
+		l.responseBody = nil
+	}
+
 	if l.responseWriter == nil {
 		return
 	}
@@ -157,8 +260,114 @@ func (l *recorder) closeResponseWriter() {
 	}()
 
 	if err := l.responseWriter.Close(); err != nil {
+		l.metrics.RecorderError("close")
 		l.logger.Error("Failed to close response writer",
 			zap.Error(err),
 		)
 	}
 }
+
+func (l *recorder) redactHeaders(headers http.Header, location RedactionLocation) http.Header {
+	result, names := l.rules.RedactHeaders(headers)
+
+	for _, name := range names {
+		l.redacted = append(l.redacted, Redaction{Location: location, Name: name})
+	}
+
+	return result
+}
+
+func (l *recorder) writeRedactedBody(body *bodyBuffer, location RedactionLocation, open func(requestId string) (io.WriteCloser, error), truncated *bool) int {
+	// We cannot guarantee that the secrets are removed, therefore the body is not recorded at all.
+	if body.dropped {
+		l.redacted = append(l.redacted, Redaction{Location: location, Name: "$"})
+		return 0
+	}
+
+	if body.data.Len() == 0 {
+		return 0
+	}
+
+	data, paths, err := l.rules.RedactBody(body.data.Bytes())
+	if err != nil {
+		l.redacted = append(l.redacted, Redaction{Location: location, Name: "$"})
+		return 0
+	}
+
+	for _, path := range paths {
+		l.redacted = append(l.redacted, Redaction{Location: location, Name: path})
+	}
+
+	// The body can only be limited after the redaction, because truncated JSON cannot be parsed.
+	data = l.limitBody(data, 0, truncated)
+	if len(data) == 0 {
+		return 0
+	}
+
+	writer, err := open(l.request.RequestId)
+	if err != nil {
+		l.metrics.RecorderError("open")
+		l.logger.Error("Failed to open body writer",
+			zap.Error(err),
+		)
+		return 0
+	}
+
+	defer func() {
+		if err := writer.Close(); err != nil {
+			l.metrics.RecorderError("close")
+			l.logger.Error("Failed to close body writer",
+				zap.Error(err),
+			)
+		}
+	}()
+
+	n, err := writer.Write(data)
+	if err != nil {
+		l.metrics.RecorderError("write")
+		l.logger.Error("Failed to write body",
+			zap.Error(err),
+		)
+		return -1
+	}
+
+	return n
+}
+
+// In metadata mode only the size of the bodies is counted.
+func (l *recorder) recordsBody() bool {
+	return l.policy.Mode != RecordingMetadata
+}
+
+// Returns the part of the data that is recorded according to the policy.
+func (l *recorder) limitBody(data []byte, written int, truncated *bool) []byte {
+	if !l.recordsBody() {
+		return nil
+	}
+
+	if l.policy.MaxBodySize <= 0 {
+		return data
+	}
+
+	remaining := max(l.policy.MaxBodySize-written, 0)
+	if len(data) > remaining {
+		*truncated = true
+		return data[:remaining]
+	}
+
+	return data
+}
+
+func (b *bodyBuffer) append(data []byte, maxSize int) {
+	if b.dropped {
+		return
+	}
+
+	if maxSize > 0 && b.data.Len()+len(data) > maxSize {
+		b.data.Reset()
+		b.dropped = true
+		return
+	}
+
+	b.data.Write(data)
+}
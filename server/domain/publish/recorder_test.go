@@ -0,0 +1,240 @@
+package publish
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"wh/domain/metrics"
+	"wh/domain/redaction"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type testRecorder struct {
+	*recorder
+
+	buckets Buckets
+	store   Store
+}
+
+func newTestRecorder(t *testing.T, headers http.Header, policy RecordingPolicy, rules *redaction.Rules) *testRecorder {
+	store := NewMemoryStore(viper.New())
+	buckets := NewMemoryBucket(viper.New())
+
+	request := NewTunneledRequest("users", "request-1", HttpRequestStart{Method: http.MethodPost, Path: "/", Headers: headers}, zap.NewNop())
+
+	return &testRecorder{
+		recorder: NewRecorder(request, store, buckets, policy, rules, metrics.NewMetrics(), zap.NewNop()),
+		buckets:  buckets,
+		store:    store,
+	}
+}
+
+func newTestRules(t *testing.T, maxBodySize int) *redaction.Rules {
+	config := viper.New()
+	config.Set("request.maxSize", maxBodySize)
+	config.Set("redaction.json", []string{"$.card.number"})
+
+	redactor, err := redaction.NewRedactor(config)
+	if err != nil {
+		t.Fatalf("failed to create redactor: %v", err)
+	}
+
+	return redactor.GetRules("users")
+}
+
+func (r *testRecorder) entry(t *testing.T) *StoreEntry {
+	t.Helper()
+
+	entry, err := r.store.GetEntry("request-1")
+	if err != nil || entry == nil {
+		t.Fatalf("expected the entry, got %v, %v", entry, err)
+	}
+
+	return entry
+}
+
+func (r *testRecorder) requestBody(t *testing.T) string {
+	t.Helper()
+
+	return r.readBody(t, r.buckets.OpenRequestReader)
+}
+
+func (r *testRecorder) responseBody(t *testing.T) string {
+	t.Helper()
+
+	return r.readBody(t, r.buckets.OpenResponseReader)
+}
+
+func (r *testRecorder) readBody(t *testing.T, open func(requestId string) (io.ReadCloser, error)) string {
+	t.Helper()
+
+	reader, err := open("request-1")
+	if err != nil {
+		return ""
+	}
+
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read the body: %v", err)
+	}
+
+	return string(data)
+}
+
+func TestRecorderRedactsBodyRegardlessOfContentType(t *testing.T) {
+	for _, contentType := range []string{"application/json", "text/plain", "application/x-www-form-urlencoded", ""} {
+		t.Run(contentType, func(t *testing.T) {
+			headers := http.Header{}
+			if contentType != "" {
+				headers.Set("Content-Type", contentType)
+			}
+
+			rec := newTestRecorder(t, headers, RecordingPolicy{Mode: RecordingFull}, newTestRules(t, 1000))
+			rec.OnRequestData(HttpRequestData{Data: []byte(`{"card":{"number":`)})
+			rec.OnRequestData(HttpRequestData{Data: []byte(`"4242"}}`), Completed: true})
+			rec.OnResponseStart(HttpResponseStart{Status: http.StatusOK, Headers: http.Header{}})
+			rec.OnResponseData(HttpResponseData{Completed: true})
+
+			expected := `{"card":{"number":"[REDACTED]"}}`
+			if actual := rec.requestBody(t); actual != expected {
+				t.Errorf("expected %s, got %s", expected, actual)
+			}
+
+			entry := rec.entry(t)
+			if entry.RequestSize != len(expected) || len(entry.Redacted) != 1 || entry.Redacted[0].Name != "$.card.number" {
+				t.Errorf("expected the redacted field, got %d, %v", entry.RequestSize, entry.Redacted)
+			}
+		})
+	}
+}
+
+func TestRecorderDropsBodiesThatCannotBeRedacted(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+	}{
+		{name: "form data", chunks: []string{"card[number]=4242"}},
+		{name: "newline delimited", chunks: []string{"{\"id\":1}\n", "{\"card\":{\"number\":\"4242\"}}"}},
+		// The buffer is limited by the rules and dropped as soon as the body is larger.
+		{name: "too large", chunks: []string{`{"card":{"number":"4242"},`, `"padding":"0123456789"}`}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := newTestRecorder(t, http.Header{"Content-Type": {"application/json"}}, RecordingPolicy{Mode: RecordingFull}, newTestRules(t, 40))
+
+			for i, chunk := range test.chunks {
+				rec.OnRequestData(HttpRequestData{Data: []byte(chunk), Completed: i == len(test.chunks)-1})
+			}
+
+			rec.OnResponseStart(HttpResponseStart{Status: http.StatusOK, Headers: http.Header{}})
+			rec.OnResponseData(HttpResponseData{Completed: true})
+
+			if actual := rec.requestBody(t); actual != "" {
+				t.Errorf("expected no recorded body, got %s", actual)
+			}
+
+			entry := rec.entry(t)
+			if entry.RequestSize != 0 || len(entry.Redacted) != 1 || entry.Redacted[0] != (Redaction{Location: RedactionRequestBody, Name: "$"}) {
+				t.Errorf("expected the whole body to be redacted, got %d, %v", entry.RequestSize, entry.Redacted)
+			}
+		})
+	}
+}
+
+func TestBodyBufferDrop(t *testing.T) {
+	b := &bodyBuffer{}
+
+	b.append([]byte("0123"), 10)
+	b.append([]byte("456789"), 10)
+
+	if b.dropped || b.data.String() != "0123456789" {
+		t.Fatalf("expected the data up to the limit, got %q", b.data.String())
+	}
+
+	b.append([]byte("a"), 10)
+
+	if !b.dropped || b.data.Len() != 0 {
+		t.Fatalf("expected the buffer to be dropped, got %q", b.data.String())
+	}
+
+	// Later data is ignored, even if it would fit.
+	b.append([]byte("b"), 0)
+
+	if b.data.Len() != 0 {
+		t.Errorf("expected the buffer to stay empty, got %q", b.data.String())
+	}
+}
+
+func TestRecorderKeepsPartialBodiesOfFailedRequests(t *testing.T) {
+	tests := []struct {
+		name              string
+		emit              func(rec *testRecorder)
+		request           string
+		response          string
+		requestTruncated  bool
+		responseTruncated bool
+	}{
+		{
+			name: "request interrupted",
+			emit: func(rec *testRecorder) {
+				rec.OnRequestData(HttpRequestData{Data: []byte("partial ")})
+				rec.OnRequestData(HttpRequestData{Data: []byte("request")})
+			},
+			request:          "partial request",
+			requestTruncated: true,
+		},
+		{
+			name: "response interrupted",
+			emit: func(rec *testRecorder) {
+				rec.OnRequestData(HttpRequestData{Data: []byte("request"), Completed: true})
+				rec.OnResponseStart(HttpResponseStart{Status: http.StatusOK, Headers: http.Header{}})
+				rec.OnResponseData(HttpResponseData{Data: []byte("partial response")})
+			},
+			request:           "request",
+			response:          "partial response",
+			responseTruncated: true,
+		},
+		{
+			name: "no body",
+			emit: func(rec *testRecorder) {
+				rec.OnRequestData(HttpRequestData{Completed: true})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := newTestRecorder(t, http.Header{}, RecordingPolicy{Mode: RecordingFull}, nil)
+
+			test.emit(rec)
+			rec.OnError(HttpError{Error: errors.New("connection reset")})
+
+			if actual := rec.requestBody(t); actual != test.request {
+				t.Errorf("expected the request body %q, got %q", test.request, actual)
+			}
+
+			if actual := rec.responseBody(t); actual != test.response {
+				t.Errorf("expected the response body %q, got %q", test.response, actual)
+			}
+
+			entry := rec.entry(t)
+			if entry.RequestSize != len(test.request) || entry.ResponseSize != len(test.response) {
+				t.Errorf("expected the sizes %d and %d, got %d and %d", len(test.request), len(test.response), entry.RequestSize, entry.ResponseSize)
+			}
+
+			if entry.RequestTruncated != test.requestTruncated || entry.ResponseTruncated != test.responseTruncated {
+				t.Errorf("expected truncated %v and %v, got %v and %v", test.requestTruncated, test.responseTruncated, entry.RequestTruncated, entry.ResponseTruncated)
+			}
+
+			if entry.Error == nil {
+				t.Error("expected the error to be recorded")
+			}
+		})
+	}
+}
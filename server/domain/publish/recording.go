@@ -0,0 +1,285 @@
+package publish
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+type RecordingMode = string
+
+const (
+	// RecordingFull Records the metadata and the bodies.
+	RecordingFull RecordingMode = "full"
+
+	// RecordingMetadata Records the method, path, headers, status, timings and the body sizes, but no bodies.
+	RecordingMetadata RecordingMode = "metadata"
+
+	// RecordingOff Records nothing.
+	RecordingOff RecordingMode = "off"
+)
+
+// How much is recorded by each mode.
+var recordingLevels = map[RecordingMode]int{
+	RecordingOff:      0,
+	RecordingMetadata: 1,
+	RecordingFull:     2,
+}
+
+// ErrInvalidRecordingPolicy The recording policy is not valid.
+var ErrInvalidRecordingPolicy = errors.New("InvalidRecordingPolicy")
+
+type RecordingPolicy struct {
+	// What is recorded.
+	Mode RecordingMode `json:"mode"`
+
+	// The maximum number of recorded body bytes. Zero means unlimited.
+	MaxBodySize int `json:"maxBodySize"`
+
+	// Only one of N requests is recorded. Zero and one record every request.
+	SampleRate int `json:"sampleRate"`
+}
+
+type RecordingPolicyEntry struct {
+	Endpoint string
+	Policy   RecordingPolicy
+}
+
+type recordingPolicies struct {
+	configured map[string]RecordingPolicy
+	counters   map[string]int
+	global     RecordingPolicy
+	lock       sync.Mutex
+	sessions   map[string]RecordingPolicy
+	store      Store
+	stored     map[string]RecordingPolicy
+}
+
+type RecordingPolicies interface {
+	// Get returns the effective policy of the endpoint.
+	Get(endpoint string) RecordingPolicy
+
+	// GetDefault returns the global policy from the configuration.
+	GetDefault() RecordingPolicy
+
+	// GetStored returns the policies that have been configured in the UI, ordered by endpoint.
+	GetStored() []RecordingPolicyEntry
+
+	// Set stores the policy of the endpoint. A nil policy restores the configured policy.
+	Set(endpoint string, policy *RecordingPolicy) error
+
+	// SetSession restricts the policy while a client is subscribed to the endpoint. A nil policy removes the restriction.
+	SetSession(endpoint string, policy *RecordingPolicy)
+
+	// Sample returns the effective policy and whether the next request of the endpoint is recorded.
+	Sample(endpoint string) (RecordingPolicy, bool)
+}
+
+func NewRecordingPolicies(store Store, config *viper.Viper) (RecordingPolicies, error) {
+	global, err := readRecordingPolicy(config.Sub("recording"), RecordingPolicy{Mode: RecordingFull})
+	if err != nil {
+		return nil, err
+	}
+
+	configured := make(map[string]RecordingPolicy)
+
+	for endpoint := range config.GetStringMap("endpoints") {
+		section := config.Sub("endpoints." + endpoint + ".recording")
+		if section == nil {
+			continue
+		}
+
+		// The endpoint policy only overrides the values that are set.
+		policy, err := readRecordingPolicy(section, global)
+		if err != nil {
+			return nil, err
+		}
+
+		configured[endpoint] = policy
+	}
+
+	stored, err := store.GetRecordingPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingPolicies{
+		configured: configured,
+		counters:   make(map[string]int),
+		global:     global,
+		sessions:   make(map[string]RecordingPolicy),
+		store:      store,
+		stored:     stored,
+	}, nil
+}
+
+func (p *recordingPolicies) Get(endpoint string) RecordingPolicy {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.get(endpoint)
+}
+
+func (p *recordingPolicies) GetDefault() RecordingPolicy {
+	return p.global
+}
+
+func (p *recordingPolicies) GetStored() []RecordingPolicyEntry {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	result := make([]RecordingPolicyEntry, 0, len(p.stored))
+	for endpoint, policy := range p.stored {
+		result = append(result, RecordingPolicyEntry{Endpoint: endpoint, Policy: policy})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Endpoint < result[j].Endpoint
+	})
+
+	return result
+}
+
+func (p *recordingPolicies) Set(endpoint string, policy *RecordingPolicy) error {
+	if endpoint == "" {
+		return ErrInvalidEndpoint
+	}
+
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Hold the lock while writing to keep the cache and the store consistent.
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := p.store.SetRecordingPolicy(endpoint, policy); err != nil {
+		return err
+	}
+
+	if policy == nil {
+		delete(p.stored, endpoint)
+	} else {
+		p.stored[endpoint] = *policy
+	}
+
+	return nil
+}
+
+func (p *recordingPolicies) SetSession(endpoint string, policy *RecordingPolicy) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if policy == nil {
+		delete(p.sessions, endpoint)
+	} else {
+		p.sessions[endpoint] = *policy
+	}
+}
+
+func (p *recordingPolicies) Sample(endpoint string) (RecordingPolicy, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	policy := p.get(endpoint)
+	if policy.Mode == RecordingOff {
+		return policy, false
+	}
+
+	if policy.SampleRate <= 1 {
+		return policy, true
+	}
+
+	// Record the first request, so that the user sees something immediately.
+	counter := p.counters[endpoint]
+	p.counters[endpoint] = (counter + 1) % policy.SampleRate
+
+	return policy, counter == 0
+}
+
+// The policy from the UI wins over the configuration. The client can only record less than the administrator allows.
+func (p *recordingPolicies) get(endpoint string) RecordingPolicy {
+	policy := p.getConfigured(endpoint)
+
+	if session, ok := p.sessions[endpoint]; ok {
+		return policy.restrict(session)
+	}
+
+	return policy
+}
+
+func (p *recordingPolicies) getConfigured(endpoint string) RecordingPolicy {
+	if policy, ok := p.stored[endpoint]; ok {
+		return policy
+	}
+
+	// Viper keys are not case sensitive.
+	if policy, ok := p.configured[strings.ToLower(endpoint)]; ok {
+		return policy
+	}
+
+	return p.global
+}
+
+// Combines both policies, so that the result records nothing that one of them does not allow.
+func (p RecordingPolicy) restrict(other RecordingPolicy) RecordingPolicy {
+	result := p
+
+	if recordingLevels[other.Mode] < recordingLevels[result.Mode] {
+		result.Mode = other.Mode
+	}
+
+	// Zero means unlimited.
+	if other.MaxBodySize > 0 && (result.MaxBodySize == 0 || other.MaxBodySize < result.MaxBodySize) {
+		result.MaxBodySize = other.MaxBodySize
+	}
+
+	if other.SampleRate > result.SampleRate {
+		result.SampleRate = other.SampleRate
+	}
+
+	return result
+}
+
+// Validate checks the mode and the limits of the policy.
+func (p RecordingPolicy) Validate() error {
+	if p.Mode != RecordingFull && p.Mode != RecordingMetadata && p.Mode != RecordingOff {
+		return ErrInvalidRecordingPolicy
+	}
+
+	if p.MaxBodySize < 0 || p.SampleRate < 0 {
+		return ErrInvalidRecordingPolicy
+	}
+
+	return nil
+}
+
+func readRecordingPolicy(section *viper.Viper, fallback RecordingPolicy) (RecordingPolicy, error) {
+	policy := fallback
+	if section == nil {
+		return policy, nil
+	}
+
+	if section.IsSet("mode") {
+		policy.Mode = strings.ToLower(section.GetString("mode"))
+	}
+
+	if section.IsSet("maxBodySize") {
+		policy.MaxBodySize = section.GetInt("maxBodySize")
+	}
+
+	if section.IsSet("sampleRate") {
+		policy.SampleRate = section.GetInt("sampleRate")
+	}
+
+	if err := policy.Validate(); err != nil {
+		return policy, err
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,144 @@
+package publish
+
+import (
+	"net/http"
+	"testing"
+	"wh/domain/metrics"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func newTestPolicies(t *testing.T, store Store, global RecordingPolicy) RecordingPolicies {
+	config := viper.New()
+	config.Set("recording.mode", global.Mode)
+	config.Set("recording.maxBodySize", global.MaxBodySize)
+	config.Set("recording.sampleRate", global.SampleRate)
+
+	policies, err := NewRecordingPolicies(store, config)
+	if err != nil {
+		t.Fatalf("failed to create policies: %v", err)
+	}
+
+	return policies
+}
+
+func TestRecordingPolicySessionRestricts(t *testing.T) {
+	tests := []struct {
+		name     string
+		admin    RecordingPolicy
+		session  RecordingPolicy
+		expected RecordingPolicy
+	}{
+		{
+			name:     "admin off wins",
+			admin:    RecordingPolicy{Mode: RecordingOff},
+			session:  RecordingPolicy{Mode: RecordingFull},
+			expected: RecordingPolicy{Mode: RecordingOff},
+		},
+		{
+			name:     "admin metadata wins",
+			admin:    RecordingPolicy{Mode: RecordingMetadata},
+			session:  RecordingPolicy{Mode: RecordingFull},
+			expected: RecordingPolicy{Mode: RecordingMetadata},
+		},
+		{
+			name:     "session off wins",
+			admin:    RecordingPolicy{Mode: RecordingFull},
+			session:  RecordingPolicy{Mode: RecordingOff},
+			expected: RecordingPolicy{Mode: RecordingOff},
+		},
+		{
+			name:     "lower admin body size wins",
+			admin:    RecordingPolicy{Mode: RecordingFull, MaxBodySize: 100},
+			session:  RecordingPolicy{Mode: RecordingFull, MaxBodySize: 1000},
+			expected: RecordingPolicy{Mode: RecordingFull, MaxBodySize: 100},
+		},
+		{
+			name:     "unlimited session body size",
+			admin:    RecordingPolicy{Mode: RecordingFull, MaxBodySize: 100},
+			session:  RecordingPolicy{Mode: RecordingFull},
+			expected: RecordingPolicy{Mode: RecordingFull, MaxBodySize: 100},
+		},
+		{
+			name:     "lower session body size wins",
+			admin:    RecordingPolicy{Mode: RecordingFull},
+			session:  RecordingPolicy{Mode: RecordingFull, MaxBodySize: 100},
+			expected: RecordingPolicy{Mode: RecordingFull, MaxBodySize: 100},
+		},
+		{
+			name:     "higher sample rate wins",
+			admin:    RecordingPolicy{Mode: RecordingFull, SampleRate: 10},
+			session:  RecordingPolicy{Mode: RecordingFull, SampleRate: 2},
+			expected: RecordingPolicy{Mode: RecordingFull, SampleRate: 10},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policies := newTestPolicies(t, NewMemoryStore(viper.New()), RecordingPolicy{Mode: RecordingFull})
+
+			// The policy from the UI is the most common way for administrators to restrict the recording.
+			if err := policies.Set("users", &test.admin); err != nil {
+				t.Fatalf("failed to set policy: %v", err)
+			}
+
+			policies.SetSession("users", &test.session)
+
+			if actual := policies.Get("users"); actual != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, actual)
+			}
+
+			policies.SetSession("users", nil)
+
+			if actual := policies.Get("users"); actual != test.admin {
+				t.Errorf("expected %+v after the session, got %+v", test.admin, actual)
+			}
+		})
+	}
+}
+
+func TestRecordingPolicySessionRestrictsConfiguration(t *testing.T) {
+	policies := newTestPolicies(t, NewMemoryStore(viper.New()), RecordingPolicy{Mode: RecordingMetadata, MaxBodySize: 50})
+	policies.SetSession("users", &RecordingPolicy{Mode: RecordingFull})
+
+	expected := RecordingPolicy{Mode: RecordingMetadata, MaxBodySize: 50}
+	if actual := policies.Get("users"); actual != expected {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestRecorderMetadataSizes(t *testing.T) {
+	store := NewMemoryStore(viper.New())
+	buckets := NewMemoryBucket(viper.New())
+
+	request := NewTunneledRequest("users", "request-1", HttpRequestStart{Method: http.MethodPost, Path: "/", Headers: http.Header{}}, zap.NewNop())
+
+	rec := NewRecorder(request, store, buckets, RecordingPolicy{Mode: RecordingMetadata}, nil, metrics.NewMetrics(), zap.NewNop())
+	rec.OnRequestData(HttpRequestData{Data: []byte("hello")})
+	rec.OnRequestData(HttpRequestData{Data: []byte(" world"), Completed: true})
+	rec.OnResponseStart(HttpResponseStart{Status: http.StatusOK, Headers: http.Header{}})
+	rec.OnResponseData(HttpResponseData{Data: []byte("ok"), Completed: true})
+
+	entry, err := store.GetEntry("request-1")
+	if err != nil || entry == nil {
+		t.Fatalf("expected the entry, got %v, %v", entry, err)
+	}
+
+	if entry.Recording != RecordingMetadata || entry.RequestSize != 11 || entry.ResponseSize != 2 {
+		t.Errorf("expected the counted sizes, got %q, %d, %d", entry.Recording, entry.RequestSize, entry.ResponseSize)
+	}
+
+	if entry.RequestTruncated || entry.ResponseTruncated {
+		t.Errorf("expected the bodies not to be marked as partial")
+	}
+
+	entry.Status = StatusCompleted
+	if HasRequestBody(entry) || HasResponseBody(entry) {
+		t.Errorf("expected no recorded bodies")
+	}
+
+	if _, err := buckets.OpenRequestReader("request-1"); err == nil {
+		t.Errorf("expected no request blob")
+	}
+}
@@ -1,8 +1,11 @@
 package publish
 
 import (
+	"context"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -15,11 +18,14 @@ type registration[T any] struct {
 
 type TunneledRequest struct {
 	lock            sync.RWMutex
+	flowLock        sync.Mutex
 	logger          *zap.Logger
 	onError         []registration[func(HttpError)]
 	onRequestData   []registration[func(HttpRequestData)]
 	onResponseData  []registration[func(HttpResponseData)]
 	onResponseStart []registration[func(HttpResponseStart)]
+	resumed         chan bool
+	forwarded       atomic.Pointer[time.Time]
 	Endpoint        string
 	Request         HttpRequestStart
 	RequestId       string
@@ -82,7 +88,7 @@ func (t *TunneledRequest) EmitRequestData(origin int, data []byte, completed boo
 	}
 }
 
-func (t *TunneledRequest) EmitResponse(origin int, headers http.Header, status int32) {
+func (t *TunneledRequest) EmitResponse(origin int, headers http.Header, status int32, localDuration time.Duration) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
@@ -93,7 +99,7 @@ func (t *TunneledRequest) EmitResponse(origin int, headers http.Header, status i
 	t.Status = StatusResponseStarted
 
 	if len(t.onResponseStart) > 0 {
-		msg := HttpResponseStart{Headers: headers, Status: status}
+		msg := HttpResponseStart{Headers: headers, Status: status, LocalDuration: localDuration}
 		for _, r := range t.onResponseStart {
 			if r.origin != origin {
 				r.action(msg)
@@ -132,6 +138,9 @@ func (t *TunneledRequest) EmitError(origin int, err error, timeout bool) {
 		return
 	}
 
+	// Do not keep the caller waiting for a client that will never resume the request.
+	t.Resume()
+
 	if timeout {
 		t.Status = StatusTimeout
 	} else {
@@ -148,6 +157,58 @@ func (t *TunneledRequest) EmitError(origin int, err error, timeout bool) {
 	}
 }
 
+// Pause stops the request body until the client has caught up.
+func (t *TunneledRequest) Pause() {
+	// Do not use the lock, because it is held while the events are sent to the tunnel.
+	t.flowLock.Lock()
+	defer t.flowLock.Unlock()
+
+	if t.resumed == nil {
+		t.resumed = make(chan bool)
+	}
+}
+
+// Resume continues the request body after a pause.
+func (t *TunneledRequest) Resume() {
+	t.flowLock.Lock()
+	defer t.flowLock.Unlock()
+
+	if t.resumed != nil {
+		close(t.resumed)
+		t.resumed = nil
+	}
+}
+
+// WaitResumed blocks while the request body is paused by the client.
+func (t *TunneledRequest) WaitResumed(ctx context.Context) error {
+	t.flowLock.Lock()
+	resumed := t.resumed
+	t.flowLock.Unlock()
+
+	if resumed == nil {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MarkForwarded records the time when the request has been sent to the client completely.
+func (t *TunneledRequest) MarkForwarded() {
+	// Do not use the lock, because it is held while the events are sent to the tunnel.
+	now := time.Now()
+	t.forwarded.Store(&now)
+}
+
+// Forwarded returns the time when the request has been sent to the client completely.
+func (t *TunneledRequest) Forwarded() *time.Time {
+	return t.forwarded.Load()
+}
+
 func (t *TunneledRequest) Cancel(origin int) {
 	t.EmitError(origin, nil, true)
 }
@@ -0,0 +1,192 @@
+package publish
+
+import (
+	"io"
+	"sync"
+	"time"
+	"wh/domain/encryption"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const rotationBatchSize = 100
+
+type keyRotator struct {
+	buckets  Buckets
+	done     chan bool
+	failed   map[string]bool
+	interval time.Duration
+	keyring  encryption.Keyring
+	logger   *zap.Logger
+	stop     func()
+	stopped  chan bool
+	store    Store
+}
+
+type KeyRotator interface {
+	// Start re-encrypts the existing data with the current key in the background.
+	Start()
+
+	// Stop waits until the current entry has been rotated and stops the background job.
+	Stop()
+}
+
+func NewKeyRotator(store Store, buckets Buckets, keyring encryption.Keyring, config *viper.Viper, logger *zap.Logger) KeyRotator {
+	interval := config.GetDuration("encryption.rotationInterval")
+
+	stopped := make(chan bool)
+
+	return &keyRotator{
+		buckets:  buckets,
+		failed:   make(map[string]bool),
+		interval: interval,
+		keyring:  keyring,
+		logger:   logger,
+		stop: sync.OnceFunc(func() {
+			close(stopped)
+		}),
+		stopped: stopped,
+		store:   store,
+	}
+}
+
+func (r *keyRotator) Start() {
+	if r.keyring.Current() == nil {
+		return
+	}
+
+	r.done = make(chan bool)
+
+	go func() {
+		defer close(r.done)
+
+		for {
+			r.rotate()
+
+			if r.interval <= 0 {
+				return
+			}
+
+			select {
+			case <-r.stopped:
+				return
+			case <-time.After(r.interval):
+			}
+		}
+	}()
+}
+
+func (r *keyRotator) Stop() {
+	r.stop()
+
+	if r.done != nil {
+		<-r.done
+	}
+}
+
+func (r *keyRotator) rotate() {
+	total := 0
+
+	for {
+		// Entries that cannot be rotated are returned again, therefore query more entries to make progress.
+		limit := rotationBatchSize + len(r.failed)
+
+		ids, err := r.store.GetEntriesToRotate(limit)
+		if err != nil {
+			r.logger.Error("Failed to query entries for key rotation.",
+				zap.Error(err),
+			)
+			return
+		}
+
+		attempted := 0
+		for _, id := range ids {
+			select {
+			case <-r.stopped:
+				return
+			default:
+			}
+
+			// Skip the entries that have failed before, for example corrupt blobs or blobs of a removed key.
+			if r.failed[id] {
+				continue
+			}
+
+			attempted++
+
+			if err := r.rotateEntry(id); err != nil {
+				r.logger.Error("Failed to rotate encryption key.",
+					zap.String("requestId", id),
+					zap.Error(err),
+				)
+
+				r.failed[id] = true
+				continue
+			}
+
+			total++
+		}
+
+		if attempted == 0 || len(ids) < limit {
+			break
+		}
+	}
+
+	if total > 0 {
+		r.logger.Info("Encrypted existing entries with current key.",
+			zap.Int("count", total),
+		)
+	}
+}
+
+func (r *keyRotator) rotateEntry(requestId string) error {
+	entry, err := r.store.GetEntry(requestId)
+	if err != nil || entry == nil {
+		return err
+	}
+
+	// The blobs are replaced first, because the store marks the entry as rotated.
+	if hasRecordedBodies(entry) && entry.RequestSize > 0 {
+		if err := rotateBlob(r.buckets.OpenRequestReader, r.buckets.OpenRequestReplacement, requestId); err != nil {
+			return err
+		}
+	}
+
+	if hasRecordedBodies(entry) && entry.ResponseSize > 0 {
+		if err := rotateBlob(r.buckets.OpenResponseReader, r.buckets.OpenResponseReplacement, requestId); err != nil {
+			return err
+		}
+	}
+
+	return r.store.RotateEntry(requestId)
+}
+
+func rotateBlob(
+	openReader func(requestId string) (io.ReadCloser, error),
+	openReplacement func(requestId string) (BlobReplacement, error),
+	requestId string,
+) error {
+	reader, err := openReader(requestId)
+	if err != nil {
+		return err
+	}
+
+	// The blob is written next to the existing one and only replaces it when it is complete, so it is never lost.
+	replacement, err := openReplacement(requestId)
+	if err != nil {
+		_ = reader.Close()
+		return err
+	}
+
+	defer replacement.Close()
+
+	// The existing blob is closed before it is replaced, some file systems cannot replace open files.
+	_, err = io.Copy(replacement, reader)
+	_ = reader.Close()
+	if err != nil {
+		return err
+	}
+
+	return replacement.Commit()
+}
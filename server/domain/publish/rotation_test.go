@@ -0,0 +1,258 @@
+package publish
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Simulates the query of the SQL store, which returns the entries until they have been rotated.
+type rotationStore struct {
+	Store
+
+	corrupt map[string]bool
+	entries map[string]*StoreEntry
+	pending map[string]bool
+	queries int
+}
+
+func (s *rotationStore) GetEntriesToRotate(limit int) ([]string, error) {
+	s.queries++
+
+	result := make([]string, 0)
+	for id := range s.pending {
+		result = append(result, id)
+	}
+
+	sort.Strings(result)
+	return result[:min(limit, len(result))], nil
+}
+
+func (s *rotationStore) GetEntry(requestId string) (*StoreEntry, error) {
+	if s.corrupt[requestId] {
+		return nil, errors.New("corrupt entry")
+	}
+
+	if entry, ok := s.entries[requestId]; ok {
+		return entry, nil
+	}
+
+	return &StoreEntry{RequestId: requestId}, nil
+}
+
+func (s *rotationStore) RotateEntry(requestId string) error {
+	delete(s.pending, requestId)
+	return nil
+}
+
+// Fails the replacement after the given number of bytes, like a full disk.
+type failingBuckets struct {
+	Buckets
+
+	limit int
+}
+
+type failingReplacement struct {
+	BlobReplacement
+
+	remaining int
+}
+
+func (b failingBuckets) OpenRequestReplacement(requestId string) (BlobReplacement, error) {
+	replacement, err := b.Buckets.OpenRequestReplacement(requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failingReplacement{BlobReplacement: replacement, remaining: b.limit}, nil
+}
+
+func (r *failingReplacement) Write(p []byte) (int, error) {
+	if len(p) <= r.remaining {
+		r.remaining -= len(p)
+		return r.BlobReplacement.Write(p)
+	}
+
+	n, _ := r.BlobReplacement.Write(p[:r.remaining])
+	r.remaining = 0
+
+	return n, errors.New("no space left on device")
+}
+
+func newTestRotator(t *testing.T, store Store, interval time.Duration) *keyRotator {
+	config := viper.New()
+	config.Set("encryption.rotationInterval", interval)
+
+	return NewKeyRotator(store, NewMemoryBucket(viper.New()), newTestKeyring(t, "key-1"), config, zap.NewNop()).(*keyRotator)
+}
+
+func TestKeyRotatorSkipsFailedEntries(t *testing.T) {
+	store := &rotationStore{corrupt: make(map[string]bool), pending: make(map[string]bool)}
+
+	// The corrupt entries are returned first by the query.
+	for i := 0; i < rotationBatchSize+5; i++ {
+		store.corrupt[entryId("a", i)] = true
+		store.pending[entryId("a", i)] = true
+	}
+
+	for i := 0; i < 10; i++ {
+		store.pending[entryId("b", i)] = true
+	}
+
+	r := newTestRotator(t, store, 0)
+	r.rotate()
+
+	if len(store.pending) != rotationBatchSize+5 {
+		t.Errorf("expected only the corrupt entries to remain, got %d", len(store.pending))
+	}
+
+	if len(r.failed) != rotationBatchSize+5 {
+		t.Errorf("expected the corrupt entries to be skipped, got %d", len(r.failed))
+	}
+
+	// The next run does not try the corrupt entries again.
+	store.pending["c"] = true
+	r.rotate()
+
+	if store.pending["c"] {
+		t.Error("expected the new entry to be rotated")
+	}
+}
+
+func TestKeyRotatorStop(t *testing.T) {
+	store := &rotationStore{pending: map[string]bool{}}
+
+	r := newTestRotator(t, store, time.Hour)
+	r.Start()
+
+	stopped := make(chan bool)
+	go func() {
+		r.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the rotator to stop without waiting for the interval")
+	}
+
+	queries := store.queries
+	time.Sleep(10 * time.Millisecond)
+
+	if store.queries != queries {
+		t.Error("expected no queries after the rotator has been stopped")
+	}
+
+	// Stopping twice is safe.
+	r.Stop()
+}
+
+func TestKeyRotatorKeepsBlobOnFailedWrite(t *testing.T) {
+	config := viper.New()
+	config.Set("dataFolder", t.TempDir())
+
+	files := NewFileBucket(config)
+	body := bytes.Repeat([]byte("body of the request "), 10000)
+
+	oldKeyring := newTestKeyring(t, "key-1")
+	writeBlob(t, NewEncryptedBuckets(files, oldKeyring), "request-1", body)
+
+	store := &rotationStore{
+		entries: map[string]*StoreEntry{"request-1": {RequestId: "request-1", RequestSize: len(body)}},
+		pending: map[string]bool{"request-1": true},
+	}
+
+	keyring := newTestKeyring(t, "key-2", "key-1")
+	current := NewEncryptedBuckets(files, keyring)
+
+	// The write fails after a part of the blob has been written.
+	failing := NewEncryptedBuckets(failingBuckets{Buckets: files, limit: len(body) / 2}, keyring)
+	r := NewKeyRotator(store, failing, keyring, viper.New(), zap.NewNop()).(*keyRotator)
+
+	if err := r.rotateEntry("request-1"); err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+
+	if !store.pending["request-1"] {
+		t.Error("expected the entry not to be marked as rotated")
+	}
+
+	if !bytes.Equal(readBlob(t, current, "request-1"), body) {
+		t.Error("expected the original blob to be unchanged")
+	}
+
+	folder, err := os.ReadDir(filepath.Join(config.GetString("dataFolder"), "dumps", "request-1"))
+	if err != nil || len(folder) != 1 {
+		t.Errorf("expected the temporary file to be removed, got %v", folder)
+	}
+
+	// The next attempt succeeds.
+	r = NewKeyRotator(store, current, keyring, viper.New(), zap.NewNop()).(*keyRotator)
+	if err := r.rotateEntry("request-1"); err != nil {
+		t.Fatalf("failed to rotate: %v", err)
+	}
+
+	if store.pending["request-1"] {
+		t.Error("expected the entry to be marked as rotated")
+	}
+
+	if !bytes.Equal(readBlob(t, current, "request-1"), body) {
+		t.Error("expected the rotated blob to contain the body")
+	}
+
+	if raw := readBlob(t, files, "request-1"); !bytes.Contains(raw[:64], []byte("key-2")) {
+		t.Error("expected the blob to be encrypted with the current key")
+	}
+}
+
+func TestFileReplacementIsAtomic(t *testing.T) {
+	config := viper.New()
+	config.Set("dataFolder", t.TempDir())
+
+	files := NewFileBucket(config)
+	writeBlob(t, files, "request-1", []byte("old"))
+
+	replacement, err := files.OpenRequestReplacement("request-1")
+	if err != nil {
+		t.Fatalf("failed to open replacement: %v", err)
+	}
+
+	if _, err := replacement.Write([]byte("new")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	// Readers see the old blob until the replacement has been committed.
+	if actual := string(readBlob(t, files, "request-1")); actual != "old" {
+		t.Errorf("expected the old blob before the commit, got %s", actual)
+	}
+
+	if err := replacement.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	if actual := string(readBlob(t, files, "request-1")); actual != "new" {
+		t.Errorf("expected the new blob after the commit, got %s", actual)
+	}
+
+	// Closing a committed replacement does not remove the blob.
+	if err := replacement.Close(); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+
+	if actual := string(readBlob(t, files, "request-1")); actual != "new" {
+		t.Errorf("expected the new blob after the close, got %s", actual)
+	}
+}
+
+func entryId(prefix string, i int) string {
+	return fmt.Sprintf("%s-%03d", prefix, i)
+}
@@ -9,8 +9,31 @@ const (
 	StatusFailed
 	StatusTimeout
 	StatusCompleted
+	StatusRejected
+	StatusThrottled
+)
+
+type Verification = int
+
+const (
+	VerificationNone Verification = iota
+	VerificationValid
+	VerificationInvalid
+)
+
+type RedactionLocation = int
+
+const (
+	RedactionRequestHeader RedactionLocation = iota
+	RedactionRequestBody
+	RedactionResponseHeader
+	RedactionResponseBody
 )
 
 func IsTerminated(status Status) bool {
-	return status == StatusFailed || status == StatusTimeout || status == StatusCompleted
+	return status == StatusFailed || status == StatusTimeout || status == StatusCompleted || IsRejected(status)
+}
+
+func IsRejected(status Status) bool {
+	return status == StatusRejected || status == StatusThrottled
 }
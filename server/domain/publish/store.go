@@ -1,14 +1,15 @@
 package publish
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
-	"path"
 	"time"
+	"wh/domain/encryption"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/spf13/viper"
 )
 
@@ -23,90 +24,144 @@ type StoreEntry struct {
 	Error        error
 	Completed    *time.Time
 	Status       Status
+	Redacted     []Redaction
+
+	// Indicates that only a part of the request body has been recorded.
+	RequestTruncated bool
+
+	// Indicates that only a part of the response body has been recorded.
+	ResponseTruncated bool
+
+	// The timestamps of the phases between start and completion.
+	Timings Timings
+
+	// The recording mode of the request. Empty for requests that have been recorded before the policies were introduced.
+	Recording RecordingMode
 }
 
-const (
-	tableDefinition string = `
-		CREATE TABLE IF NOT EXISTS requests (
-			requestId		STRING NOT NULL PRIMARY KEY,
-			started			DATETIME NOT NULL,
-			endpoint		STRING NOT NULL,
-			requestMethod	STRING NOT NULL,
-			requestPath		STRING NOT NULL,
-			requestHeaders	STRING NOT NULL,
-			requestSize		INT NOT NULL DEFAULT 0,
-			responseStatus 	INT NOT NULL DEFAULT 0,
-			responseHeaders STRING,
-			responseSize 	INT NOT NULL DEFAULT 0,
-			error			STRING,
-			completed		DATETIME,
-			status			INT NOT NULL,
-			etag 			INT NOT NULL
-		)`
-)
+// ResponseLog The outcome of a request, which is recorded when the request has been terminated.
+type ResponseLog struct {
+	RequestSize  int
+	Response     *HttpResponseStart
+	ResponseSize int
+	Error        error
+	Status       Status
+	Redacted     []Redaction
+
+	// Indicates that only a part of the request body has been recorded.
+	RequestTruncated bool
+
+	// Indicates that only a part of the response body has been recorded.
+	ResponseTruncated bool
 
+	// The timestamps of the phases between start and completion.
+	Timings Timings
+}
+
+type EndpointEntry struct {
+	Endpoint string
+	Owner    string
+	Created  time.Time
+}
+
+// HasRequestBody indicates whether a request body has been recorded. Failed requests can have a truncated body.
 func HasRequestBody(r *StoreEntry) bool {
-	return r != nil && r.RequestSize > 0 && r.Status == StatusCompleted
+	return r != nil && hasRecordedBodies(r) && r.RequestSize > 0 && IsTerminated(r.Status)
 }
 
+// HasResponseBody indicates whether a response body has been recorded. Failed requests can have a truncated body.
 func HasResponseBody(r *StoreEntry) bool {
-	return r != nil && r.ResponseSize > 0 && r.Status == StatusCompleted && r.Response != nil
+	return r != nil && hasRecordedBodies(r) && r.ResponseSize > 0 && IsTerminated(r.Status) && r.Response != nil
+}
+
+// The sizes of entries that only have metadata are the sizes of the bodies that have not been recorded.
+func hasRecordedBodies(r *StoreEntry) bool {
+	return r.Recording != RecordingMetadata
 }
 
 type record struct {
-	requestId       string
-	started         time.Time
-	endpoint        string
-	requestMethod   string
-	requestPath     string
-	requestHeaders  string
-	requestSize     int
-	responseStatus  int32
-	responseHeaders *string
-	responseSize    int
-	error           *string
-	completed       *time.Time
-	status          Status
-	etag            int64
+	requestId         string
+	started           time.Time
+	endpoint          string
+	requestMethod     string
+	requestPath       string
+	requestHeaders    string
+	requestSize       int
+	responseStatus    int32
+	responseHeaders   *string
+	responseSize      int
+	error             *string
+	completed         *time.Time
+	status            Status
+	etag              int64
+	verification      Verification
+	redacted          *string
+	requestTruncated  bool
+	responseTruncated bool
+	timings           *string
+	recording         *string
 }
 
 type store struct {
 	db *sql.DB
+
+	// Converts the placeholders of the query to the syntax of the database.
+	bind func(query string) string
+
+	// Encrypts the headers, which can contain secrets.
+	keyring encryption.Keyring
 }
 
 type Store interface {
-	LogRequest(requestId string, endpoint string, request HttpRequestStart) error
+	LogRequest(requestId string, endpoint string, request HttpRequestStart, mode RecordingMode) error
 
-	LogResponse(requestId string, requestSize int, response *HttpResponseStart, responseSize int, requestError error, status Status) error
+	// LogResponse completes the entry of the request.
+	LogResponse(requestId string, response ResponseLog) error
 
 	GetEntry(requestId string) (*StoreEntry, error)
 
 	GetEntries(etag int64) ([]StoreEntry, int64, error)
-}
 
-func NewStore(config *viper.Viper) (Store, error) {
-	folder := config.GetString("dataFolder")
+	// ReserveEndpoint returns ErrReserved when the endpoint is already owned by someone else.
+	ReserveEndpoint(endpoint string, owner string) error
 
-	err := os.MkdirAll(folder, 0755)
-	if err != nil {
-		return nil, err
-	}
+	GetEndpoint(endpoint string) (*EndpointEntry, error)
 
-	file := path.Join(folder, "data.db")
+	// GetEntriesToRotate returns the IDs of completed requests that have not been encrypted with the current key yet.
+	GetEntriesToRotate(limit int) ([]string, error)
 
-	db, err := sql.Open("sqlite3", file)
-	if err != nil {
-		return nil, err
-	}
+	// RotateEntry encrypts the data of the request with the current key.
+	RotateEntry(requestId string) error
 
-	if _, err := db.Exec(tableDefinition); err != nil {
-		return nil, err
+	// GetRecordingPolicies returns the recording policies that have been configured in the UI by endpoint.
+	GetRecordingPolicies() (map[string]RecordingPolicy, error)
+
+	// SetRecordingPolicy stores the recording policy of the endpoint. A nil policy deletes it.
+	SetRecordingPolicy(endpoint string, policy *RecordingPolicy) error
+
+	// Ping checks whether the database is reachable.
+	Ping(ctx context.Context) error
+
+	// Close releases the database connections.
+	Close() error
+}
+
+func NewStore(config *viper.Viper, keyring encryption.Keyring) (Store, error) {
+	storeType := config.GetString("store.type")
+
+	switch storeType {
+	case "sqlite":
+		return NewSqliteStore(config, keyring)
+	case "postgres":
+		return NewPostgresStore(config, keyring)
+	case "memory":
+		return NewMemoryStore(config), nil
 	}
 
-	return &store{db: db}, nil
+	return nil, fmt.Errorf("unknown store type '%s'", storeType)
 }
 
-func (l store) LogRequest(requestId string, endpoint string, request HttpRequestStart) error {
+func (l store) LogRequest(requestId string, endpoint string, request HttpRequestStart, mode RecordingMode) error {
 	const insert string = `
 		INSERT INTO requests(
 			requestId,
@@ -116,8 +171,10 @@ func (l store) LogRequest(requestId string, endpoint string, request HttpRequest
 			requestPath,
 			requestHeaders,
 			status,
-			etag
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			etag,
+			verification,
+			recording
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	encoded, err := json.Marshal(request.Headers)
@@ -125,9 +182,12 @@ func (l store) LogRequest(requestId string, endpoint string, request HttpRequest
 		return err
 	}
 
-	requestHeaders := string(encoded)
+	requestHeaders, err := encryption.EncryptString(l.keyring, string(encoded))
+	if err != nil {
+		return err
+	}
 
-	_, err = l.db.Exec(insert,
+	_, err = l.db.Exec(l.bind(insert),
 		requestId,
 		time.Now(),
 		endpoint,
@@ -135,12 +195,14 @@ func (l store) LogRequest(requestId string, endpoint string, request HttpRequest
 		request.Path,
 		requestHeaders,
 		StatusRequestStarted,
-		createEtag())
+		createEtag(),
+		request.Verification,
+		mode)
 
 	return err
 }
 
-func (l store) LogResponse(requestId string, requestSize int, response *HttpResponseStart, responseSize int, requestError error, status Status) error {
+func (l store) LogResponse(requestId string, log ResponseLog) error {
 	const update string = `
 		UPDATE requests 
 		SET
@@ -151,37 +213,59 @@ func (l store) LogResponse(requestId string, requestSize int, response *HttpResp
 			error = ?,
 			completed = ?,
 			status = ?,
-			etag = ?
+			etag = ?,
+			redacted = ?,
+			requestTruncated = ?,
+			responseTruncated = ?,
+			timings = ?
 		WHERE requestId = ?
 	`
 
 	responseStatus := 0
 	responseHeaders := ""
 
-	if response != nil {
-		encoded, err := json.Marshal(response.Headers)
+	if log.Response != nil {
+		encoded, err := json.Marshal(log.Response.Headers)
+		if err != nil {
+			return err
+		}
+
+		responseHeaders, err = encryption.EncryptString(l.keyring, string(encoded))
 		if err != nil {
 			return err
 		}
 
-		responseStatus = int(response.Status)
-		responseHeaders = string(encoded)
+		responseStatus = int(log.Response.Status)
 	}
 
 	errorText := ""
-	if requestError != nil {
-		errorText = requestError.Error()
+	if log.Error != nil {
+		errorText = log.Error.Error()
+	}
+
+	encodedRedacted, err := json.Marshal(log.Redacted)
+	if err != nil {
+		return err
+	}
+
+	encodedTimings, err := json.Marshal(log.Timings)
+	if err != nil {
+		return err
 	}
 
-	_, err := l.db.Exec(update,
-		requestSize,
+	_, err = l.db.Exec(l.bind(update),
+		log.RequestSize,
 		responseStatus,
 		responseHeaders,
-		responseSize,
+		log.ResponseSize,
 		errorText,
 		time.Now(),
-		status,
+		log.Status,
 		createEtag(),
+		string(encodedRedacted),
+		log.RequestTruncated,
+		log.ResponseTruncated,
+		string(encodedTimings),
 		requestId)
 
 	return err
@@ -203,18 +287,24 @@ func (l store) GetEntry(requestId string) (*StoreEntry, error) {
 			error,
 			completed,
 			status,
-			etag		
+			etag,
+			verification,
+			redacted,
+			requestTruncated,
+			responseTruncated,
+			timings,
+			recording
 		FROM requests WHERE requestId = ?
  	`
 
-	rows, err := l.db.Query(query, requestId)
+	rows, err := l.db.Query(l.bind(query), requestId)
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 	if rows.Next() {
-		r, _, err := mapRecord(rows)
+		r, _, err := l.mapRecord(rows)
 		if err != nil {
 			return nil, nil
 		}
@@ -243,11 +333,17 @@ func (l store) GetEntries(etag int64) ([]StoreEntry, int64, error) {
 			error,
 			completed,
 			status,
-			etag		
+			etag,
+			verification,
+			redacted,
+			requestTruncated,
+			responseTruncated,
+			timings,
+			recording
 		FROM requests WHERE etag > ? ORDER BY started DESC LIMIT 100
  	`
 
-	rows, err := l.db.Query(query, etag)
+	rows, err := l.db.Query(l.bind(query), etag)
 	if err != nil {
 		return result, 0, err
 	}
@@ -256,7 +352,7 @@ func (l store) GetEntries(etag int64) ([]StoreEntry, int64, error) {
 
 	defer rows.Close()
 	for rows.Next() {
-		r, etag, err := mapRecord(rows)
+		r, etag, err := l.mapRecord(rows)
 		if err != nil {
 			return result, 0, err
 		}
@@ -271,7 +367,151 @@ func (l store) GetEntries(etag int64) ([]StoreEntry, int64, error) {
 	return result, newEtag, nil
 }
 
-func mapRecord(rows *sql.Rows) (*StoreEntry, int64, error) {
+func (l store) ReserveEndpoint(endpoint string, owner string) error {
+	const insert string = `
+		INSERT INTO endpoints(
+			endpoint,
+			owner,
+			created
+		) VALUES (?, ?, ?)
+		ON CONFLICT(endpoint) DO NOTHING
+	`
+
+	_, err := l.db.Exec(l.bind(insert),
+		endpoint,
+		owner,
+		time.Now())
+	if err != nil {
+		return err
+	}
+
+	// Another API key might have reserved the endpoint concurrently, then the insert has no effect.
+	existing, err := l.GetEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil || existing.Owner != owner {
+		return ErrReserved
+	}
+
+	return nil
+}
+
+func (l store) GetEndpoint(endpoint string) (*EndpointEntry, error) {
+	const query string = `
+		SELECT
+			endpoint,
+			owner,
+			created
+		FROM endpoints WHERE endpoint = ?
+	`
+
+	rows, err := l.db.Query(l.bind(query), endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	if rows.Next() {
+		e := &EndpointEntry{}
+		if err := rows.Scan(&e.Endpoint, &e.Owner, &e.Created); err != nil {
+			return nil, err
+		}
+
+		return e, nil
+	}
+
+	return nil, nil
+}
+
+func (l store) GetEntriesToRotate(limit int) ([]string, error) {
+	result := make([]string, 0)
+
+	key := l.keyring.Current()
+	if key == nil {
+		return result, nil
+	}
+
+	const query string = `
+		SELECT
+			requestId
+		FROM requests WHERE completed IS NOT NULL AND requestHeaders NOT LIKE ? LIMIT ?
+	`
+
+	rows, err := l.db.Query(l.bind(query), encryption.ValuePrefix(key)+"%", limit)
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var requestId string
+		if err := rows.Scan(&requestId); err != nil {
+			return result, err
+		}
+
+		result = append(result, requestId)
+	}
+
+	return result, rows.Err()
+}
+
+func (l store) RotateEntry(requestId string) error {
+	const query string = `
+		SELECT
+			requestHeaders,
+			responseHeaders
+		FROM requests WHERE requestId = ?
+	`
+
+	var requestHeaders string
+	var responseHeaders *string
+	if err := l.db.QueryRow(l.bind(query), requestId).Scan(&requestHeaders, &responseHeaders); err != nil {
+		return err
+	}
+
+	requestHeaders, err := l.reencrypt(requestHeaders)
+	if err != nil {
+		return err
+	}
+
+	if responseHeaders != nil && *responseHeaders != "" {
+		value, err := l.reencrypt(*responseHeaders)
+		if err != nil {
+			return err
+		}
+
+		responseHeaders = &value
+	}
+
+	// Do not update the etag, because nothing has changed for the user.
+	const update string = `
+		UPDATE requests
+		SET
+			requestHeaders = ?,
+			responseHeaders = ?
+		WHERE requestId = ?
+	`
+
+	_, err = l.db.Exec(l.bind(update),
+		requestHeaders,
+		responseHeaders,
+		requestId)
+
+	return err
+}
+
+func (l store) reencrypt(value string) (string, error) {
+	plaintext, err := encryption.DecryptString(l.keyring, value)
+	if err != nil {
+		return "", err
+	}
+
+	return encryption.EncryptString(l.keyring, plaintext)
+}
+
+func (l store) mapRecord(rows *sql.Rows) (*StoreEntry, int64, error) {
 	r := &record{}
 	err := rows.Scan(
 		&r.requestId,
@@ -287,22 +527,38 @@ func mapRecord(rows *sql.Rows) (*StoreEntry, int64, error) {
 		&r.error,
 		&r.completed,
 		&r.status,
-		&r.etag)
+		&r.etag,
+		&r.verification,
+		&r.redacted,
+		&r.requestTruncated,
+		&r.responseTruncated,
+		&r.timings,
+		&r.recording)
+
+	if err != nil {
+		return nil, 0, err
+	}
 
+	requestHeadersJson, err := encryption.DecryptString(l.keyring, r.requestHeaders)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	requestHeaders := make(http.Header)
-	err = json.Unmarshal([]byte(r.requestHeaders), &requestHeaders)
+	err = json.Unmarshal([]byte(requestHeadersJson), &requestHeaders)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	var response *HttpResponseStart = nil
 	if r.responseStatus > 0 && r.responseHeaders != nil {
+		responseHeadersJson, err := encryption.DecryptString(l.keyring, *r.responseHeaders)
+		if err != nil {
+			return nil, 0, err
+		}
+
 		responseHeaders := make(http.Header)
-		err = json.Unmarshal([]byte(*r.responseHeaders), &responseHeaders)
+		err = json.Unmarshal([]byte(responseHeadersJson), &responseHeaders)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -310,16 +566,49 @@ func mapRecord(rows *sql.Rows) (*StoreEntry, int64, error) {
 		response = &HttpResponseStart{Status: r.responseStatus, Headers: responseHeaders}
 	}
 
+	var requestError error = nil
+	if r.error != nil && *r.error != "" {
+		requestError = errors.New(*r.error)
+	}
+
+	redacted := make([]Redaction, 0)
+	if r.redacted != nil && *r.redacted != "" {
+		err = json.Unmarshal([]byte(*r.redacted), &redacted)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	timings := Timings{}
+	if r.timings != nil && *r.timings != "" {
+		err = json.Unmarshal([]byte(*r.timings), &timings)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	recording := ""
+	if r.recording != nil {
+		recording = *r.recording
+	}
+
 	entry := StoreEntry{
 		RequestId:    r.requestId,
 		Started:      r.started,
 		Endpoint:     r.endpoint,
-		Request:      HttpRequestStart{Method: r.requestMethod, Path: r.requestPath, Headers: requestHeaders},
+		Request:      HttpRequestStart{Method: r.requestMethod, Path: r.requestPath, Headers: requestHeaders, Verification: r.verification},
 		RequestSize:  r.requestSize,
 		Response:     response,
 		ResponseSize: r.responseSize,
+		Error:        requestError,
 		Completed:    r.completed,
 		Status:       r.status,
+		Redacted:     redacted,
+
+		RequestTruncated:  r.requestTruncated,
+		ResponseTruncated: r.responseTruncated,
+		Timings:           timings,
+		Recording:         recording,
 	}
 
 	return &entry, r.etag, nil
@@ -328,3 +617,77 @@ func mapRecord(rows *sql.Rows) (*StoreEntry, int64, error) {
 func createEtag() int64 {
 	return time.Now().Unix()
 }
+
+func (l store) GetRecordingPolicies() (map[string]RecordingPolicy, error) {
+	result := make(map[string]RecordingPolicy)
+
+	const query string = `
+		SELECT
+			endpoint,
+			mode,
+			maxBodySize,
+			sampleRate
+		FROM recordingPolicies
+	`
+
+	rows, err := l.db.Query(l.bind(query))
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var endpoint string
+		var policy RecordingPolicy
+		if err := rows.Scan(&endpoint, &policy.Mode, &policy.MaxBodySize, &policy.SampleRate); err != nil {
+			return result, err
+		}
+
+		result[endpoint] = policy
+	}
+
+	return result, rows.Err()
+}
+
+func (l store) SetRecordingPolicy(endpoint string, policy *RecordingPolicy) error {
+	if policy == nil {
+		const remove string = `
+			DELETE FROM recordingPolicies WHERE endpoint = ?
+		`
+
+		_, err := l.db.Exec(l.bind(remove), endpoint)
+		return err
+	}
+
+	const upsert string = `
+		INSERT INTO recordingPolicies(
+			endpoint,
+			mode,
+			maxBodySize,
+			sampleRate,
+			updated
+		) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(endpoint) DO UPDATE SET
+			mode = excluded.mode,
+			maxBodySize = excluded.maxBodySize,
+			sampleRate = excluded.sampleRate,
+			updated = excluded.updated
+	`
+
+	_, err := l.db.Exec(l.bind(upsert),
+		endpoint,
+		policy.Mode,
+		policy.MaxBodySize,
+		policy.SampleRate,
+		time.Now())
+
+	return err
+}
+
+func (l store) Ping(ctx context.Context) error {
+	return l.db.PingContext(ctx)
+}
+
+func (l store) Close() error {
+	return l.db.Close()
+}
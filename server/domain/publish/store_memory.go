@@ -0,0 +1,207 @@
+package publish
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type memoryEntry struct {
+	entry StoreEntry
+	etag  int64
+}
+
+type memoryStore struct {
+	endpoints  map[string]EndpointEntry
+	entries    map[string]*memoryEntry
+	lock       sync.RWMutex
+	maxEntries int
+	order      []string
+	policies   map[string]RecordingPolicy
+}
+
+func NewMemoryStore(config *viper.Viper) Store {
+	maxEntries := config.GetInt("log.maxEntries")
+
+	return &memoryStore{
+		endpoints:  make(map[string]EndpointEntry),
+		entries:    make(map[string]*memoryEntry),
+		maxEntries: maxEntries,
+		order:      make([]string, 0),
+		policies:   make(map[string]RecordingPolicy),
+	}
+}
+
+func (m *memoryStore) LogRequest(requestId string, endpoint string, request HttpRequestStart, mode RecordingMode) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.entries[requestId]; !ok {
+		m.order = append(m.order, requestId)
+	}
+
+	m.entries[requestId] = &memoryEntry{
+		entry: StoreEntry{
+			RequestId: requestId,
+			Started:   time.Now(),
+			Endpoint:  endpoint,
+			Request:   request,
+			Status:    StatusRequestStarted,
+			Recording: mode,
+		},
+		etag: createEtag(),
+	}
+
+	// Remove the oldest entries, because there is no background cleanup.
+	for m.maxEntries > 0 && len(m.order) > m.maxEntries {
+		delete(m.entries, m.order[0])
+		m.order = m.order[1:]
+	}
+
+	return nil
+}
+
+func (m *memoryStore) LogResponse(requestId string, log ResponseLog) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.entries[requestId]
+	if !ok {
+		// Same behavior as an update statement without matching rows.
+		return nil
+	}
+
+	completed := time.Now()
+
+	e.entry.RequestSize = log.RequestSize
+	e.entry.Response = log.Response
+	e.entry.ResponseSize = log.ResponseSize
+	e.entry.Error = log.Error
+	e.entry.Completed = &completed
+	e.entry.Status = log.Status
+	e.entry.Redacted = log.Redacted
+	e.entry.RequestTruncated = log.RequestTruncated
+	e.entry.ResponseTruncated = log.ResponseTruncated
+	e.entry.Timings = log.Timings
+	e.etag = createEtag()
+
+	return nil
+}
+
+func (m *memoryStore) GetEntry(requestId string) (*StoreEntry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	e, ok := m.entries[requestId]
+	if !ok {
+		return nil, nil
+	}
+
+	entry := e.entry
+	return &entry, nil
+}
+
+func (m *memoryStore) GetEntries(etag int64) ([]StoreEntry, int64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	result := make([]StoreEntry, 0)
+
+	newEtag := etag
+	for _, e := range m.entries {
+		if e.etag <= etag {
+			continue
+		}
+
+		if e.etag > newEtag {
+			newEtag = e.etag
+		}
+
+		result = append(result, e.entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Started.After(result[j].Started)
+	})
+
+	// Use the same limit as the SQL store.
+	if len(result) > 100 {
+		result = result[:100]
+	}
+
+	return result, newEtag, nil
+}
+
+func (m *memoryStore) ReserveEndpoint(endpoint string, owner string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	existing, ok := m.endpoints[endpoint]
+	if !ok {
+		m.endpoints[endpoint] = EndpointEntry{Endpoint: endpoint, Owner: owner, Created: time.Now()}
+		return nil
+	}
+
+	if existing.Owner != owner {
+		return ErrReserved
+	}
+
+	return nil
+}
+
+func (m *memoryStore) GetEndpoint(endpoint string) (*EndpointEntry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	e, ok := m.endpoints[endpoint]
+	if !ok {
+		return nil, nil
+	}
+
+	return &e, nil
+}
+
+func (m *memoryStore) GetEntriesToRotate(limit int) ([]string, error) {
+	// The headers are never written to disk.
+	return make([]string, 0), nil
+}
+
+func (m *memoryStore) RotateEntry(requestId string) error {
+	return nil
+}
+
+func (m *memoryStore) GetRecordingPolicies() (map[string]RecordingPolicy, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	result := make(map[string]RecordingPolicy, len(m.policies))
+	for endpoint, policy := range m.policies {
+		result[endpoint] = policy
+	}
+
+	return result, nil
+}
+
+func (m *memoryStore) SetRecordingPolicy(endpoint string, policy *RecordingPolicy) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if policy == nil {
+		delete(m.policies, endpoint)
+	} else {
+		m.policies[endpoint] = *policy
+	}
+
+	return nil
+}
+
+func (m *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
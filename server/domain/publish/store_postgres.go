@@ -0,0 +1,104 @@
+package publish
+
+import (
+	"context"
+	"database/sql"
+	"wh/domain/encryption"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+// An arbitrary but fixed ID of the advisory lock for the migrations.
+const postgresMigrationLockId = 4_711_001
+
+var postgresMigrations = []migration{
+	{
+		version: 1,
+		up: execAll(`
+			CREATE TABLE requests (
+				requestId		TEXT NOT NULL PRIMARY KEY,
+				started			TIMESTAMPTZ NOT NULL,
+				endpoint		TEXT NOT NULL,
+				requestMethod	TEXT NOT NULL,
+				requestPath		TEXT NOT NULL,
+				requestHeaders	TEXT NOT NULL,
+				requestSize		INT NOT NULL DEFAULT 0,
+				responseStatus 	INT NOT NULL DEFAULT 0,
+				responseHeaders TEXT,
+				responseSize 	INT NOT NULL DEFAULT 0,
+				error			TEXT,
+				completed		TIMESTAMPTZ,
+				status			INT NOT NULL,
+				etag 			BIGINT NOT NULL,
+				verification	INT NOT NULL DEFAULT 0
+			)`, `
+			CREATE INDEX requests_etag ON requests (etag)`, `
+			CREATE TABLE endpoints (
+				endpoint		TEXT NOT NULL PRIMARY KEY,
+				owner			TEXT NOT NULL,
+				created			TIMESTAMPTZ NOT NULL
+			)`),
+	},
+	{
+		version: 2,
+		up: execAll(`
+			ALTER TABLE requests ADD COLUMN redacted TEXT`),
+	},
+	{
+		version: 3,
+		up: execAll(`
+			CREATE TABLE recordingPolicies (
+				endpoint		TEXT NOT NULL PRIMARY KEY,
+				mode			TEXT NOT NULL,
+				maxBodySize		INT NOT NULL DEFAULT 0,
+				sampleRate		INT NOT NULL DEFAULT 0,
+				updated			TIMESTAMPTZ NOT NULL
+			)`),
+	},
+	{
+		version: 4,
+		up: execAll(`
+			ALTER TABLE requests ADD COLUMN requestTruncated BOOLEAN NOT NULL DEFAULT FALSE`, `
+			ALTER TABLE requests ADD COLUMN responseTruncated BOOLEAN NOT NULL DEFAULT FALSE`),
+	},
+	{
+		version: 5,
+		up: execAll(`
+			ALTER TABLE requests ADD COLUMN timings TEXT`),
+	},
+	{
+		version: 6,
+		up: execAll(`
+			ALTER TABLE requests ADD COLUMN recording TEXT`),
+	},
+}
+
+func NewPostgresStore(config *viper.Viper, keyring encryption.Keyring) (Store, error) {
+	db, err := sql.Open("postgres", config.GetString("store.connectionString"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Open does not connect to the database, therefore we check the connection string early.
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db, bindNumbered, lockPostgresMigrations, postgresMigrations); err != nil {
+		return nil, err
+	}
+
+	return &store{db: db, bind: bindNumbered, keyring: keyring}, nil
+}
+
+// Instances that start at the same time wait until the first instance has applied the migrations.
+func lockPostgresMigrations(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", postgresMigrationLockId); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_, _ = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", postgresMigrationLockId)
+	}, nil
+}
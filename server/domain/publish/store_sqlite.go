@@ -0,0 +1,133 @@
+//go:build cgo
+
+package publish
+
+import (
+	"database/sql"
+	"os"
+	"path"
+	"wh/domain/encryption"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/viper"
+)
+
+// The first migrations use "IF NOT EXISTS", because databases that have been created before the migrations were introduced already contain the tables.
+var sqliteMigrations = []migration{
+	{
+		version: 1,
+		up: execAll(`
+			CREATE TABLE IF NOT EXISTS requests (
+				requestId		STRING NOT NULL PRIMARY KEY,
+				started			DATETIME NOT NULL,
+				endpoint		STRING NOT NULL,
+				requestMethod	STRING NOT NULL,
+				requestPath		STRING NOT NULL,
+				requestHeaders	STRING NOT NULL,
+				requestSize		INT NOT NULL DEFAULT 0,
+				responseStatus 	INT NOT NULL DEFAULT 0,
+				responseHeaders STRING,
+				responseSize 	INT NOT NULL DEFAULT 0,
+				error			STRING,
+				completed		DATETIME,
+				status			INT NOT NULL,
+				etag 			INT NOT NULL
+			)`),
+	},
+	{
+		version: 2,
+		up: execAll(`
+			CREATE TABLE IF NOT EXISTS endpoints (
+				endpoint		STRING NOT NULL PRIMARY KEY,
+				owner			STRING NOT NULL,
+				created			DATETIME NOT NULL
+			)`),
+	},
+	{
+		version: 3,
+		up: func(tx *sql.Tx) error {
+			return addSqliteColumn(tx, "requests", "verification", "INT NOT NULL DEFAULT 0")
+		},
+	},
+	{
+		version: 4,
+		up: execAll(`
+			ALTER TABLE requests ADD COLUMN redacted STRING`),
+	},
+	{
+		version: 5,
+		up: execAll(`
+			CREATE TABLE recordingPolicies (
+				endpoint		STRING NOT NULL PRIMARY KEY,
+				mode			STRING NOT NULL,
+				maxBodySize		INT NOT NULL DEFAULT 0,
+				sampleRate		INT NOT NULL DEFAULT 0,
+				updated			DATETIME NOT NULL
+			)`),
+	},
+	{
+		version: 6,
+		up: execAll(`
+			ALTER TABLE requests ADD COLUMN requestTruncated INT NOT NULL DEFAULT 0`, `
+			ALTER TABLE requests ADD COLUMN responseTruncated INT NOT NULL DEFAULT 0`),
+	},
+	{
+		version: 7,
+		up: execAll(`
+			ALTER TABLE requests ADD COLUMN timings STRING`),
+	},
+	{
+		version: 8,
+		up: execAll(`
+			ALTER TABLE requests ADD COLUMN recording STRING`),
+	},
+}
+
+func NewSqliteStore(config *viper.Viper, keyring encryption.Keyring) (Store, error) {
+	folder := config.GetString("dataFolder")
+
+	err := os.MkdirAll(folder, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file := path.Join(folder, "data.db")
+
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db, bindQuestion, nil, sqliteMigrations); err != nil {
+		return nil, err
+	}
+
+	return &store{db: db, bind: bindQuestion, keyring: keyring}, nil
+}
+
+// SQLite does not support "ADD COLUMN IF NOT EXISTS", therefore we have to check the existing columns first.
+func addSqliteColumn(tx *sql.Tx, table string, column string, definition string) error {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+
+		if name == column {
+			return nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
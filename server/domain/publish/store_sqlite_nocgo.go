@@ -0,0 +1,15 @@
+//go:build !cgo
+
+package publish
+
+import (
+	"errors"
+	"wh/domain/encryption"
+
+	"github.com/spf13/viper"
+)
+
+func NewSqliteStore(config *viper.Viper, keyring encryption.Keyring) (Store, error) {
+	// The SQLite driver is written in C, use the memory store when building without cgo.
+	return nil, errors.New("sqlite store is not available when building without cgo")
+}
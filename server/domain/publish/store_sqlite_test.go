@@ -0,0 +1,156 @@
+//go:build cgo
+
+package publish
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// testdata/baseline.db has the schema of the first release, which did not have migrations yet.
+func newBaselineConfig(t *testing.T) (*viper.Viper, string) {
+	folder := t.TempDir()
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "baseline.db"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	file := filepath.Join(folder, "data.db")
+	if err := os.WriteFile(file, fixture, 0644); err != nil {
+		t.Fatalf("failed to copy fixture: %v", err)
+	}
+
+	config := viper.New()
+	config.Set("dataFolder", folder)
+	config.Set("store.type", "sqlite")
+
+	return config, file
+}
+
+func getSqliteColumns(t *testing.T, file string, table string) map[string]bool {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	defer db.Close()
+
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		t.Fatalf("failed to read columns: %v", err)
+	}
+
+	defer rows.Close()
+
+	result := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("failed to read column: %v", err)
+		}
+
+		result[name] = true
+	}
+
+	return result
+}
+
+func TestSqliteUpgradeBaseline(t *testing.T) {
+	config, file := newBaselineConfig(t)
+
+	s, err := NewStore(config, newTestKeyring(t))
+	if err != nil {
+		t.Fatalf("failed to upgrade the database: %v", err)
+	}
+
+	defer s.Close()
+
+	columns := getSqliteColumns(t, file, "requests")
+	for _, column := range []string{"verification", "redacted", "requestTruncated", "responseTruncated", "timings", "recording"} {
+		if !columns[column] {
+			t.Errorf("expected column %s to be added", column)
+		}
+	}
+
+	for _, table := range []string{"endpoints", "recordingPolicies", "schema_version"} {
+		if len(getSqliteColumns(t, file, table)) == 0 {
+			t.Errorf("expected table %s to be created", table)
+		}
+	}
+
+	// The existing requests are still readable with the defaults of the new columns.
+	entry, err := s.GetEntry("completed-request")
+	if err != nil || entry == nil {
+		t.Fatalf("expected the existing entry, got %v, %v", entry, err)
+	}
+
+	if entry.Endpoint != "users" || entry.Request.Method != http.MethodPost || entry.Request.Path != "/users?id=1" || entry.Request.Headers.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected request %+v", entry)
+	}
+
+	if entry.Status != StatusCompleted || entry.RequestSize != 12 || entry.ResponseSize != 34 || entry.Response == nil || entry.Response.Status != 201 {
+		t.Errorf("unexpected response %+v", entry)
+	}
+
+	if !entry.Started.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) || entry.Completed == nil {
+		t.Errorf("unexpected times %v, %v", entry.Started, entry.Completed)
+	}
+
+	if entry.Request.Verification != VerificationNone || entry.Recording != "" || entry.RequestTruncated || entry.ResponseTruncated || len(entry.Redacted) != 0 {
+		t.Errorf("unexpected defaults %+v", entry)
+	}
+
+	entries, _, err := s.GetEntries(0)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("expected both existing entries, got %d, %v", len(entries), err)
+	}
+
+	// New requests can be recorded with all columns.
+	if err := s.LogRequest("new-request", "users", HttpRequestStart{Method: http.MethodGet, Path: "/", Headers: http.Header{}, Verification: VerificationValid}, RecordingFull); err != nil {
+		t.Fatalf("failed to log request: %v", err)
+	}
+
+	if err := s.LogResponse("new-request", ResponseLog{Status: StatusFailed, RequestTruncated: true}); err != nil {
+		t.Fatalf("failed to log response: %v", err)
+	}
+
+	if err := s.ReserveEndpoint("users", "owner"); err != nil {
+		t.Fatalf("failed to reserve endpoint: %v", err)
+	}
+}
+
+func TestSqliteUpgradeTwice(t *testing.T) {
+	config, file := newBaselineConfig(t)
+
+	for i := 0; i < 2; i++ {
+		s, err := NewStore(config, newTestKeyring(t))
+		if err != nil {
+			t.Fatalf("failed to open the database the %d. time: %v", i+1, err)
+		}
+
+		_ = s.Close()
+	}
+
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	defer db.Close()
+
+	var count, latest int
+	if err := db.QueryRow("SELECT COUNT(*), MAX(version) FROM schema_version").Scan(&count, &latest); err != nil {
+		t.Fatalf("failed to read versions: %v", err)
+	}
+
+	if count != len(sqliteMigrations) || latest != sqliteMigrations[len(sqliteMigrations)-1].version {
+		t.Errorf("expected every migration to be applied once, got %d rows up to version %d", count, latest)
+	}
+}
@@ -0,0 +1,156 @@
+package redaction
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A subset of JSONPath: $.name, $['name'], $[0], $[*], $.* and $..name for recursive descent.
+type segment struct {
+	index     int
+	name      string
+	recursive bool
+	wildcard  bool
+}
+
+type jsonPath struct {
+	source   string
+	segments []segment
+}
+
+func parseJsonPath(source string) (*jsonPath, error) {
+	if !strings.HasPrefix(source, "$") {
+		return nil, fmt.Errorf("invalid JSON path '%s', must start with '$'", source)
+	}
+
+	segments := make([]segment, 0)
+
+	rest := source[1:]
+	for len(rest) > 0 {
+		s := segment{index: -1}
+
+		if strings.HasPrefix(rest, "..") {
+			s.recursive = true
+			rest = rest[2:]
+		} else if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+		} else if !strings.HasPrefix(rest, "[") {
+			return nil, fmt.Errorf("invalid JSON path '%s', unexpected '%s'", source, rest)
+		}
+
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path '%s', missing ']'", source)
+			}
+
+			selector := rest[1:end]
+			rest = rest[end+1:]
+
+			if selector == "*" {
+				s.wildcard = true
+			} else if index, err := strconv.Atoi(selector); err == nil && index >= 0 {
+				s.index = index
+			} else if len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0] {
+				s.name = selector[1 : len(selector)-1]
+			} else {
+				return nil, fmt.Errorf("invalid JSON path '%s', unsupported selector '%s'", source, selector)
+			}
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+
+			name := rest[:end]
+			rest = rest[end:]
+
+			if name == "" {
+				return nil, fmt.Errorf("invalid JSON path '%s', missing name", source)
+			}
+
+			if name == "*" {
+				s.wildcard = true
+			} else {
+				s.name = name
+			}
+		}
+
+		segments = append(segments, s)
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("invalid JSON path '%s', the root cannot be redacted", source)
+	}
+
+	return &jsonPath{source: source, segments: segments}, nil
+}
+
+// Replaces all matching values and returns the paths of the replaced values.
+func (p *jsonPath) redact(root any, redacted *[]string) any {
+	return redactNode(root, p.segments, "$", redacted)
+}
+
+func redactNode(node any, segments []segment, current string, redacted *[]string) any {
+	if len(segments) == 0 {
+		*redacted = append(*redacted, current)
+		return Mask
+	}
+
+	s := segments[0]
+
+	if s.recursive {
+		// Search the descendants first, because the node itself might be replaced.
+		forEachChild(node, current, func(child any, childPath string) any {
+			return redactNode(child, segments, childPath, redacted)
+		})
+
+		s.recursive = false
+		return redactNode(node, append([]segment{s}, segments[1:]...), current, redacted)
+	}
+
+	rest := segments[1:]
+
+	switch value := node.(type) {
+	case map[string]any:
+		if s.wildcard {
+			forEachChild(node, current, func(child any, childPath string) any {
+				return redactNode(child, rest, childPath, redacted)
+			})
+		} else if child, ok := value[s.name]; ok && s.index < 0 {
+			value[s.name] = redactNode(child, rest, current+"."+s.name, redacted)
+		}
+	case []any:
+		if s.wildcard {
+			forEachChild(node, current, func(child any, childPath string) any {
+				return redactNode(child, rest, childPath, redacted)
+			})
+		} else if s.index >= 0 && s.index < len(value) {
+			value[s.index] = redactNode(value[s.index], rest, current+"["+strconv.Itoa(s.index)+"]", redacted)
+		}
+	}
+
+	return node
+}
+
+func forEachChild(node any, current string, update func(child any, childPath string) any) {
+	switch value := node.(type) {
+	case map[string]any:
+		// Sort the keys to get a stable order of the redacted paths.
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			value[key] = update(value[key], current+"."+key)
+		}
+	case []any:
+		for i, child := range value {
+			value[i] = update(child, current+"["+strconv.Itoa(i)+"]")
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package redaction
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseJsonPath(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected []segment
+	}{
+		{source: "$.name", expected: []segment{{index: -1, name: "name"}}},
+		{source: "$.card.number", expected: []segment{{index: -1, name: "card"}, {index: -1, name: "number"}}},
+		{source: "$['card']['number']", expected: []segment{{index: -1, name: "card"}, {index: -1, name: "number"}}},
+		{source: `$["with.dot"]`, expected: []segment{{index: -1, name: "with.dot"}}},
+		{source: "$[0]", expected: []segment{{index: 0}}},
+		{source: "$.items[12].id", expected: []segment{{index: -1, name: "items"}, {index: 12}, {index: -1, name: "id"}}},
+		{source: "$[*]", expected: []segment{{index: -1, wildcard: true}}},
+		{source: "$.*", expected: []segment{{index: -1, wildcard: true}}},
+		{source: "$.items[*].secret", expected: []segment{{index: -1, name: "items"}, {index: -1, wildcard: true}, {index: -1, name: "secret"}}},
+		{source: "$..password", expected: []segment{{index: -1, name: "password", recursive: true}}},
+		{source: "$..[0]", expected: []segment{{index: 0, recursive: true}}},
+		{source: "$.user..token", expected: []segment{{index: -1, name: "user"}, {index: -1, name: "token", recursive: true}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			path, err := parseJsonPath(test.source)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			if !reflect.DeepEqual(path.segments, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, path.segments)
+			}
+		})
+	}
+}
+
+func TestParseJsonPathErrors(t *testing.T) {
+	sources := []string{
+		"",
+		"name",
+		"$",
+		"$.",
+		"$..",
+		"$name",
+		"$[0",
+		"$[-1]",
+		"$[name]",
+		"$['name\"]",
+		"$[']",
+		"$.a[1:2]",
+		"$.a[?(@.b)]",
+		"$.a..",
+	}
+
+	for _, source := range sources {
+		t.Run(source, func(t *testing.T) {
+			if path, err := parseJsonPath(source); err == nil {
+				t.Errorf("expected an error, got %+v", path.segments)
+			}
+		})
+	}
+}
+
+func TestJsonPathRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		expected string
+		redacted []string
+	}{
+		{
+			name:     "nested field",
+			path:     "$.card.number",
+			body:     `{"card":{"number":"4242","exp":"12/30"},"number":"1"}`,
+			expected: `{"card":{"exp":"12/30","number":"[REDACTED]"},"number":"1"}`,
+			redacted: []string{"$.card.number"},
+		},
+		{
+			name:     "object value",
+			path:     "$.card",
+			body:     `{"card":{"number":"4242"}}`,
+			expected: `{"card":"[REDACTED]"}`,
+			redacted: []string{"$.card"},
+		},
+		{
+			name:     "missing field",
+			path:     "$.card.number",
+			body:     `{"card":"4242"}`,
+			expected: `{"card":"4242"}`,
+			redacted: []string{},
+		},
+		{
+			name:     "array index",
+			path:     "$.items[1]",
+			body:     `{"items":[1,2,3]}`,
+			expected: `{"items":[1,"[REDACTED]",3]}`,
+			redacted: []string{"$.items[1]"},
+		},
+		{
+			name:     "index out of range",
+			path:     "$.items[5]",
+			body:     `{"items":[1,2,3]}`,
+			expected: `{"items":[1,2,3]}`,
+			redacted: []string{},
+		},
+		{
+			name:     "index on object",
+			path:     "$[0]",
+			body:     `{"0":"value"}`,
+			expected: `{"0":"value"}`,
+			redacted: []string{},
+		},
+		{
+			name:     "array wildcard",
+			path:     "$.items[*].secret",
+			body:     `{"items":[{"secret":"a"},{"other":"b"},{"secret":"c"}]}`,
+			expected: `{"items":[{"secret":"[REDACTED]"},{"other":"b"},{"secret":"[REDACTED]"}]}`,
+			redacted: []string{"$.items[0].secret", "$.items[2].secret"},
+		},
+		{
+			name:     "object wildcard",
+			path:     "$.tokens.*",
+			body:     `{"tokens":{"b":"1","a":"2"}}`,
+			expected: `{"tokens":{"a":"[REDACTED]","b":"[REDACTED]"}}`,
+			redacted: []string{"$.tokens.a", "$.tokens.b"},
+		},
+		{
+			name:     "recursive",
+			path:     "$..password",
+			body:     `{"password":"a","user":{"password":"b","friends":[{"password":"c"}]}}`,
+			expected: `{"password":"[REDACTED]","user":{"friends":[{"password":"[REDACTED]"}],"password":"[REDACTED]"}}`,
+			redacted: []string{"$.user.friends[0].password", "$.user.password", "$.password"},
+		},
+		{
+			name:     "root array",
+			path:     "$[*].token",
+			body:     `[{"token":"a"},{"token":"b"}]`,
+			expected: `[{"token":"[REDACTED]"},{"token":"[REDACTED]"}]`,
+			redacted: []string{"$[0].token", "$[1].token"},
+		},
+		{
+			name:     "scalar root",
+			path:     "$.token",
+			body:     `"token"`,
+			expected: `"token"`,
+			redacted: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, err := parseJsonPath(test.path)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			var root any
+			if err := json.Unmarshal([]byte(test.body), &root); err != nil {
+				t.Fatalf("invalid body: %v", err)
+			}
+
+			redacted := make([]string, 0)
+			result, err := json.Marshal(path.redact(root, &redacted))
+			if err != nil {
+				t.Fatalf("failed to convert: %v", err)
+			}
+
+			if string(result) != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, result)
+			}
+
+			if !reflect.DeepEqual(redacted, test.redacted) {
+				t.Errorf("expected the paths %v, got %v", test.redacted, redacted)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package redaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Mask The value that replaces redacted header values and JSON fields.
+const Mask = "[REDACTED]"
+
+// ErrInvalidJson The body cannot be redacted, because it is not a single JSON value.
+var ErrInvalidJson = errors.New("InvalidJson")
+
+type Rules struct {
+	// The headers to remove.
+	DropHeaders []string
+
+	// The headers where the value is replaced.
+	MaskHeaders []string
+
+	// The maximum size of JSON bodies that are redacted. Larger bodies are not recorded.
+	MaxBodySize int
+
+	paths []*jsonPath
+}
+
+type redactor struct {
+	endpoints map[string]*Rules
+	global    *Rules
+}
+
+type Redactor interface {
+	// GetRules returns the rules for the endpoint or nil if nothing is redacted.
+	GetRules(endpoint string) *Rules
+}
+
+func NewRedactor(config *viper.Viper) (Redactor, error) {
+	maxBodySize := config.GetInt("request.maxSize")
+
+	global, err := readRules(config.Sub("redaction"), maxBodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string]*Rules)
+
+	for endpoint := range config.GetStringMap("endpoints") {
+		section := config.Sub("endpoints." + endpoint + ".redaction")
+		if section == nil {
+			continue
+		}
+
+		rules, err := readRules(section, maxBodySize)
+		if err != nil {
+			return nil, err
+		}
+
+		// The endpoint rules extend the global rules.
+		endpoints[endpoint] = &Rules{
+			DropHeaders: append(append([]string{}, global.DropHeaders...), rules.DropHeaders...),
+			MaskHeaders: append(append([]string{}, global.MaskHeaders...), rules.MaskHeaders...),
+			MaxBodySize: maxBodySize,
+			paths:       append(append([]*jsonPath{}, global.paths...), rules.paths...),
+		}
+	}
+
+	return &redactor{endpoints: endpoints, global: global}, nil
+}
+
+func (r redactor) GetRules(endpoint string) *Rules {
+	// Viper keys are not case sensitive.
+	rules, ok := r.endpoints[strings.ToLower(endpoint)]
+	if !ok {
+		rules = r.global
+	}
+
+	if rules.isEmpty() {
+		return nil
+	}
+
+	return rules
+}
+
+// RedactHeaders returns a copy of the headers without the secret values and the names of the redacted headers.
+func (r *Rules) RedactHeaders(headers http.Header) (http.Header, []string) {
+	redacted := make([]string, 0)
+	if r == nil || headers == nil {
+		return headers, redacted
+	}
+
+	result := headers.Clone()
+
+	for _, name := range r.DropHeaders {
+		if _, ok := result[http.CanonicalHeaderKey(name)]; ok {
+			result.Del(name)
+			redacted = append(redacted, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	for _, name := range r.MaskHeaders {
+		if _, ok := result[http.CanonicalHeaderKey(name)]; ok {
+			result.Set(name, Mask)
+			redacted = append(redacted, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	return result, redacted
+}
+
+// RedactsBody indicates whether the bodies must be buffered for redaction.
+// The content type is set by the sender, therefore all bodies are checked and not only the ones that are declared as JSON.
+func (r *Rules) RedactsBody() bool {
+	return r != nil && len(r.paths) > 0
+}
+
+// RedactBody replaces the matching JSON fields and returns the paths of the redacted fields.
+// Bodies that are not valid JSON return an error, because the secrets cannot be found in them.
+func (r *Rules) RedactBody(body []byte) ([]byte, []string, error) {
+	redacted := make([]string, 0)
+
+	// The decoder only reads the first value, but a body with multiple values, e.g. newline delimited JSON, must not be recorded.
+	if !json.Valid(body) {
+		return nil, nil, ErrInvalidJson
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// Keep the numbers as they are.
+	decoder.UseNumber()
+
+	var root any
+	if err := decoder.Decode(&root); err != nil {
+		return nil, nil, err
+	}
+
+	for _, path := range r.paths {
+		root = path.redact(root, &redacted)
+	}
+
+	if len(redacted) == 0 {
+		return body, redacted, nil
+	}
+
+	result, err := json.Marshal(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, redacted, nil
+}
+
+func (r *Rules) isEmpty() bool {
+	return len(r.DropHeaders) == 0 && len(r.MaskHeaders) == 0 && len(r.paths) == 0
+}
+
+func readRules(section *viper.Viper, maxBodySize int) (*Rules, error) {
+	rules := &Rules{MaxBodySize: maxBodySize}
+	if section == nil {
+		return rules, nil
+	}
+
+	rules.DropHeaders = section.GetStringSlice("headers.drop")
+	rules.MaskHeaders = section.GetStringSlice("headers.mask")
+
+	for _, source := range section.GetStringSlice("json") {
+		path, err := parseJsonPath(source)
+		if err != nil {
+			return nil, err
+		}
+
+		rules.paths = append(rules.paths, path)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,170 @@
+package redaction
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func newTestRedactor(t *testing.T) Redactor {
+	config := viper.New()
+	config.Set("request.maxSize", 1000)
+	config.Set("redaction.headers.drop", []string{"authorization"})
+	config.Set("redaction.headers.mask", []string{"X-Api-Key"})
+	config.Set("redaction.json", []string{"$.card.number"})
+	config.Set("endpoints.Stripe.redaction.headers.drop", []string{"Stripe-Signature"})
+	config.Set("endpoints.Stripe.redaction.json", []string{"$..password"})
+
+	redactor, err := NewRedactor(config)
+	if err != nil {
+		t.Fatalf("failed to create redactor: %v", err)
+	}
+
+	return redactor
+}
+
+func TestNewRedactor(t *testing.T) {
+	redactor := newTestRedactor(t)
+
+	global := redactor.GetRules("users")
+	if global == nil || len(global.paths) != 1 || global.MaxBodySize != 1000 {
+		t.Fatalf("expected the global rules, got %+v", global)
+	}
+
+	// The endpoint rules extend the global rules and the endpoint is not case sensitive.
+	stripe := redactor.GetRules("stripe")
+	if stripe == nil || len(stripe.paths) != 2 || !reflect.DeepEqual(stripe.DropHeaders, []string{"authorization", "Stripe-Signature"}) {
+		t.Fatalf("expected the merged rules, got %+v", stripe)
+	}
+
+	if len(global.DropHeaders) != 1 {
+		t.Errorf("expected the global rules to be unchanged, got %v", global.DropHeaders)
+	}
+}
+
+func TestNewRedactorWithoutRules(t *testing.T) {
+	redactor, err := NewRedactor(viper.New())
+	if err != nil {
+		t.Fatalf("failed to create redactor: %v", err)
+	}
+
+	if rules := redactor.GetRules("users"); rules != nil {
+		t.Errorf("expected no rules, got %+v", rules)
+	}
+
+	// Nothing is redacted without rules.
+	var rules *Rules
+	if headers, names := rules.RedactHeaders(http.Header{"Authorization": {"secret"}}); headers.Get("Authorization") != "secret" || len(names) != 0 {
+		t.Errorf("expected the headers to be unchanged, got %v", headers)
+	}
+
+	if rules.RedactsBody() {
+		t.Error("expected the body not to be redacted")
+	}
+}
+
+func TestNewRedactorInvalidPath(t *testing.T) {
+	config := viper.New()
+	config.Set("endpoints.users.redaction.json", []string{"card.number"})
+
+	if _, err := NewRedactor(config); err == nil {
+		t.Error("expected an error for an invalid path")
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	rules := newTestRedactor(t).GetRules("stripe")
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer secret")
+	headers.Set("x-api-key", "key")
+	headers.Set("Stripe-Signature", "t=1,v1=abc")
+	headers.Set("Content-Type", "application/json")
+
+	result, names := rules.RedactHeaders(headers)
+
+	if result.Get("Authorization") != "" || result.Get("Stripe-Signature") != "" {
+		t.Errorf("expected the headers to be dropped, got %v", result)
+	}
+
+	if result.Get("X-Api-Key") != Mask || result.Get("Content-Type") != "application/json" {
+		t.Errorf("expected only the key to be masked, got %v", result)
+	}
+
+	if expected := []string{"Authorization", "Stripe-Signature", "X-Api-Key"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the names %v, got %v", expected, names)
+	}
+
+	// The forwarded headers are not changed.
+	if headers.Get("Authorization") != "Bearer secret" || headers.Get("X-Api-Key") != "key" {
+		t.Errorf("expected the original headers to be unchanged, got %v", headers)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	rules := newTestRedactor(t).GetRules("stripe")
+
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+		redacted []string
+		err      error
+	}{
+		{
+			name:     "redacted",
+			body:     `{"card":{"number":"4242"},"user":{"password":"secret"},"amount":1.50}`,
+			expected: `{"amount":1.50,"card":{"number":"[REDACTED]"},"user":{"password":"[REDACTED]"}}`,
+			redacted: []string{"$.card.number", "$.user.password"},
+		},
+		{
+			name:     "unchanged body is not normalized",
+			body:     `{ "amount": 1.50 }`,
+			expected: `{ "amount": 1.50 }`,
+			redacted: []string{},
+		},
+		{
+			name: "form data",
+			body: `card[number]=4242`,
+			err:  ErrInvalidJson,
+		},
+		{
+			name: "truncated",
+			body: `{"card":{"number":"4242"`,
+			err:  ErrInvalidJson,
+		},
+		{
+			name: "newline delimited",
+			body: "{\"amount\":1}\n{\"card\":{\"number\":\"4242\"}}",
+			err:  ErrInvalidJson,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, redacted, err := rules.RedactBody([]byte(test.body))
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Errorf("expected %v, got %v", test.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed to redact: %v", err)
+			}
+
+			if string(result) != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, result)
+			}
+
+			if !reflect.DeepEqual(redacted, test.redacted) {
+				t.Errorf("expected the paths %v, got %v", test.redacted, redacted)
+			}
+		})
+	}
+}
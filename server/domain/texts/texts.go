@@ -101,3 +101,163 @@ func CommonBodyNotRendered(c context.Context) string {
 func CommonDuration(c context.Context) string {
 	return getText(c, "common.duration", "Duration")
 }
+
+func CommonSignatureValid(c context.Context) string {
+	return getText(c, "common.signatureValid", "Signature valid")
+}
+
+func CommonSignatureInvalid(c context.Context) string {
+	return getText(c, "common.signatureInvalid", "Signature invalid")
+}
+
+func CommonRequestRejected(c context.Context) string {
+	return getText(c, "common.requestRejected", "Request has been rejected")
+}
+
+func CommonRedacted(c context.Context) string {
+	return getText(c, "common.redacted", "Redacted")
+}
+
+func CommonRedactedHeaders(c context.Context) string {
+	return getText(c, "common.redactedHeaders", "Redacted headers")
+}
+
+func CommonRedactedFields(c context.Context) string {
+	return getText(c, "common.redactedFields", "Redacted body fields")
+}
+
+func CommonRecording(c context.Context) string {
+	return getText(c, "common.recording", "Recording")
+}
+
+func CommonRecordingDefault(c context.Context) string {
+	return getText(c, "common.recordingDefault", "Endpoints without a policy use the configured policy. A policy of a connected client wins over the policies below.")
+}
+
+func CommonRecordingEmpty(c context.Context) string {
+	return getText(c, "common.recordingEmpty", "No policies configured yet.")
+}
+
+func CommonRecordingInvalid(c context.Context) string {
+	return getText(c, "common.recordingInvalid", "Invalid recording policy")
+}
+
+func CommonRecordingModeFull(c context.Context) string {
+	return getText(c, "common.recordingModeFull", "Full")
+}
+
+func CommonRecordingModeMetadata(c context.Context) string {
+	return getText(c, "common.recordingModeMetadata", "Metadata only")
+}
+
+func CommonRecordingModeOff(c context.Context) string {
+	return getText(c, "common.recordingModeOff", "Off")
+}
+
+func CommonEndpoint(c context.Context) string {
+	return getText(c, "common.endpoint", "Endpoint")
+}
+
+func CommonMode(c context.Context) string {
+	return getText(c, "common.mode", "Mode")
+}
+
+func CommonMaxBodySize(c context.Context) string {
+	return getText(c, "common.maxBodySize", "Max body size (bytes, 0 = unlimited)")
+}
+
+func CommonSampleRate(c context.Context) string {
+	return getText(c, "common.sampleRate", "Record 1 in N requests")
+}
+
+func CommonSave(c context.Context) string {
+	return getText(c, "common.save", "Save")
+}
+
+func CommonReset(c context.Context) string {
+	return getText(c, "common.reset", "Reset")
+}
+
+func CommonBack(c context.Context) string {
+	return getText(c, "common.back", "Back")
+}
+
+func CommonPartial(c context.Context) string {
+	return getText(c, "common.partial", "Partial")
+}
+
+func CommonPartialBody(c context.Context) string {
+	return getText(c, "common.partialBody", "Partial body, recorded")
+}
+
+func CommonBodyNotRecorded(c context.Context) string {
+	return getText(c, "common.bodyNotRecorded", "Body not recorded, size")
+}
+
+func CommonBytes(c context.Context) string {
+	return getText(c, "common.bytes", "bytes")
+}
+
+func CommonTiming(c context.Context) string {
+	return getText(c, "common.timing", "Timing")
+}
+
+func CommonTimingForwarded(c context.Context) string {
+	return getText(c, "common.timingForwarded", "Request forwarded to client")
+}
+
+func CommonTimingWaiting(c context.Context) string {
+	return getText(c, "common.timingWaiting", "Waiting for response")
+}
+
+func CommonTimingLocal(c context.Context) string {
+	return getText(c, "common.timingLocal", "Local server")
+}
+
+func CommonTimingFirstByte(c context.Context) string {
+	return getText(c, "common.timingFirstByte", "First response byte")
+}
+
+func CommonTimingCompleted(c context.Context) string {
+	return getText(c, "common.timingCompleted", "Response completed")
+}
+
+func CommonTimingTunnel(c context.Context) string {
+	return getText(c, "common.timingTunnel", "Tunnel and server")
+}
+
+func CommonTunnels(c context.Context) string {
+	return getText(c, "common.tunnels", "Active tunnels")
+}
+
+func CommonTunnelsEmpty(c context.Context) string {
+	return getText(c, "common.tunnelsEmpty", "No clients connected.")
+}
+
+func CommonApiKeyName(c context.Context) string {
+	return getText(c, "common.apiKeyName", "API key")
+}
+
+func CommonClientAddress(c context.Context) string {
+	return getText(c, "common.clientAddress", "Client address")
+}
+
+func CommonClientVersion(c context.Context) string {
+	return getText(c, "common.clientVersion", "Client version")
+}
+
+func CommonConnected(c context.Context) string {
+	return getText(c, "common.connected", "Connected")
+}
+
+func CommonInFlight(c context.Context) string {
+	return getText(c, "common.inFlight", "In flight")
+}
+
+func CommonTotal(c context.Context) string {
+	return getText(c, "common.total", "Total")
+}
+
+func CommonDisconnect(c context.Context) string {
+	return getText(c, "common.disconnect", "Disconnect")
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "wh"
+
+type tracing struct {
+	propagator propagation.TextMapPropagator
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+}
+
+type Tracing interface {
+	// Start creates a span as child of the span in the context.
+	Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span)
+
+	// Inject returns the W3C trace context of the span in the context, for example the traceparent.
+	Inject(ctx context.Context) map[string]string
+
+	// Extract returns a context with the remote span from the W3C trace context of the carrier.
+	Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context
+
+	// Shutdown exports the pending spans.
+	Shutdown(ctx context.Context) error
+}
+
+func NewTracing(config *viper.Viper) (Tracing, error) {
+	// The trace context is also forwarded when tracing is disabled, so that the local server can continue the trace of the caller.
+	if !config.GetBool("tracing.enabled") {
+		return &tracing{
+			propagator: propagation.TraceContext{},
+			tracer:     noop.NewTracerProvider().Tracer(tracerName),
+		}, nil
+	}
+
+	exporter, err := newExporter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTracingWithExporter(exporter, config), nil
+}
+
+// NewTracingWithExporter creates the tracing with a custom exporter, for example an in-memory exporter.
+func NewTracingWithExporter(exporter sdktrace.SpanExporter, config *viper.Viper) Tracing {
+	serviceName := config.GetString("tracing.serviceName")
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+		// Follow the decision of the caller, if the webhook provider already traces the request.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.GetFloat64("tracing.sampleRatio")))),
+	)
+
+	return &tracing{
+		propagator: propagation.TraceContext{},
+		provider:   provider,
+		tracer:     provider.Tracer(tracerName),
+	}
+}
+
+func (t *tracing) Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, options...)
+}
+
+func (t *tracing) Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	t.propagator.Inject(ctx, carrier)
+
+	return carrier
+}
+
+func (t *tracing) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return t.propagator.Extract(ctx, carrier)
+}
+
+func (t *tracing) Shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+
+	return t.provider.Shutdown(ctx)
+}
+
+func newExporter(config *viper.Viper) (sdktrace.SpanExporter, error) {
+	exporter := strings.ToLower(config.GetString("tracing.exporter"))
+
+	switch exporter {
+	case "otlp":
+		options := make([]otlptracehttp.Option, 0, 1)
+
+		// Without an endpoint the standard environment variables are used, for example OTEL_EXPORTER_OTLP_ENDPOINT.
+		if endpoint := config.GetString("tracing.endpoint"); endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+		}
+
+		return otlptracehttp.New(context.Background(), options...)
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter '%s'", exporter)
+	}
+}
@@ -0,0 +1,147 @@
+package verification
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+func verifyGithub(p *Profile, headers http.Header, body []byte) error {
+	signature := headers.Get("X-Hub-Signature-256")
+	if signature == "" {
+		return ErrMissingSignature
+	}
+
+	expected := "sha256=" + hex.EncodeToString(sign(p.Secret, body))
+
+	return compare(signature, expected)
+}
+
+// https://docs.stripe.com/webhooks#verify-manually
+func verifyStripe(p *Profile, headers http.Header, body []byte, now time.Time) error {
+	header := headers.Get("Stripe-Signature")
+	if header == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp := ""
+	signatures := make([]string, 0, 1)
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrMissingSignature
+	}
+
+	if err := checkTimestamp(timestamp, p.Tolerance, now); err != nil {
+		return err
+	}
+
+	expected := hex.EncodeToString(sign(p.Secret, []byte(timestamp+"."), body))
+
+	// Stripe sends multiple signatures when the secret is rolled.
+	for _, signature := range signatures {
+		if compare(signature, expected) == nil {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func verifySlack(p *Profile, headers http.Header, body []byte, now time.Time) error {
+	signature := headers.Get("X-Slack-Signature")
+	timestamp := headers.Get("X-Slack-Request-Timestamp")
+	if signature == "" || timestamp == "" {
+		return ErrMissingSignature
+	}
+
+	if err := checkTimestamp(timestamp, p.Tolerance, now); err != nil {
+		return err
+	}
+
+	expected := "v0=" + hex.EncodeToString(sign(p.Secret, []byte("v0:"+timestamp+":"), body))
+
+	return compare(signature, expected)
+}
+
+// https://shopify.dev/docs/apps/build/webhooks/subscribe/https#step-5-verify-the-webhook
+func verifyShopify(p *Profile, headers http.Header, body []byte) error {
+	signature := headers.Get("X-Shopify-Hmac-Sha256")
+	if signature == "" {
+		return ErrMissingSignature
+	}
+
+	expected := base64.StdEncoding.EncodeToString(sign(p.Secret, body))
+
+	return compare(signature, expected)
+}
+
+func verifyHmac(p *Profile, headers http.Header, body []byte) error {
+	signature := headers.Get(p.Header)
+	if signature == "" {
+		return ErrMissingSignature
+	}
+
+	hash := sign(p.Secret, body)
+
+	expected := ""
+	if p.Encoding == "base64" {
+		expected = p.Prefix + base64.StdEncoding.EncodeToString(hash)
+	} else {
+		expected = p.Prefix + hex.EncodeToString(hash)
+	}
+
+	return compare(signature, expected)
+}
+
+func sign(secret string, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, part := range parts {
+		mac.Write(part)
+	}
+
+	return mac.Sum(nil)
+}
+
+func compare(actual string, expected string) error {
+	// Use a constant time comparison to prevent timing attacks.
+	if !hmac.Equal([]byte(actual), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func checkTimestamp(timestamp string, tolerance time.Duration, now time.Time) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if age < 0 {
+		age = -age
+	}
+
+	if tolerance > 0 && age > tolerance {
+		return ErrExpiredSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package verification
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testSecret = "secret"
+
+var testBody = []byte(`{"event":"test"}`)
+
+func hmacHex(secret string, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hmacBase64(secret string, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func header(values ...string) http.Header {
+	result := http.Header{}
+	for i := 0; i < len(values); i += 2 {
+		result.Set(values[i], values[i+1])
+	}
+
+	return result
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	expired := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10)
+
+	stripeSignature := func(ts string) string {
+		return hmacHex(testSecret, ts+"."+string(testBody))
+	}
+
+	slackSignature := func(ts string) string {
+		return "v0=" + hmacHex(testSecret, "v0:"+ts+":"+string(testBody))
+	}
+
+	tests := []struct {
+		name     string
+		profile  Profile
+		headers  http.Header
+		expected error
+	}{
+		{
+			name:    "github valid",
+			profile: Profile{Type: "github", Secret: testSecret},
+			headers: header("X-Hub-Signature-256", "sha256="+hmacHex(testSecret, string(testBody))),
+		},
+		{
+			name:     "github invalid",
+			profile:  Profile{Type: "github", Secret: testSecret},
+			headers:  header("X-Hub-Signature-256", "sha256="+hmacHex("other", string(testBody))),
+			expected: ErrInvalidSignature,
+		},
+		{
+			name:     "github missing",
+			profile:  Profile{Type: "github", Secret: testSecret},
+			headers:  header(),
+			expected: ErrMissingSignature,
+		},
+		{
+			name:    "stripe valid",
+			profile: Profile{Type: "stripe", Secret: testSecret, Tolerance: 5 * time.Minute},
+			headers: header("Stripe-Signature", "t="+timestamp+",v1="+stripeSignature(timestamp)),
+		},
+		{
+			name:    "stripe rolled secret",
+			profile: Profile{Type: "stripe", Secret: testSecret, Tolerance: 5 * time.Minute},
+			headers: header("Stripe-Signature", "t="+timestamp+",v1="+hmacHex("old", timestamp+"."+string(testBody))+",v1="+stripeSignature(timestamp)),
+		},
+		{
+			name:     "stripe invalid",
+			profile:  Profile{Type: "stripe", Secret: testSecret, Tolerance: 5 * time.Minute},
+			headers:  header("Stripe-Signature", "t="+timestamp+",v1="+hmacHex("other", timestamp+"."+string(testBody))),
+			expected: ErrInvalidSignature,
+		},
+		{
+			name:     "stripe expired",
+			profile:  Profile{Type: "stripe", Secret: testSecret, Tolerance: 5 * time.Minute},
+			headers:  header("Stripe-Signature", "t="+expired+",v1="+stripeSignature(expired)),
+			expected: ErrExpiredSignature,
+		},
+		{
+			name:     "stripe in the future",
+			profile:  Profile{Type: "stripe", Secret: testSecret, Tolerance: 5 * time.Minute},
+			headers:  header("Stripe-Signature", "t="+future+",v1="+stripeSignature(future)),
+			expected: ErrExpiredSignature,
+		},
+		{
+			name:    "stripe without tolerance",
+			profile: Profile{Type: "stripe", Secret: testSecret},
+			headers: header("Stripe-Signature", "t="+expired+",v1="+stripeSignature(expired)),
+		},
+		{
+			name:     "stripe missing timestamp",
+			profile:  Profile{Type: "stripe", Secret: testSecret, Tolerance: 5 * time.Minute},
+			headers:  header("Stripe-Signature", "v1="+stripeSignature(timestamp)),
+			expected: ErrMissingSignature,
+		},
+		{
+			name:    "slack valid",
+			profile: Profile{Type: "slack", Secret: testSecret, Tolerance: 5 * time.Minute},
+			headers: header("X-Slack-Signature", slackSignature(timestamp), "X-Slack-Request-Timestamp", timestamp),
+		},
+		{
+			name:     "slack expired",
+			profile:  Profile{Type: "slack", Secret: testSecret, Tolerance: 5 * time.Minute},
+			headers:  header("X-Slack-Signature", slackSignature(expired), "X-Slack-Request-Timestamp", expired),
+			expected: ErrExpiredSignature,
+		},
+		{
+			name:     "slack missing timestamp",
+			profile:  Profile{Type: "slack", Secret: testSecret, Tolerance: 5 * time.Minute},
+			headers:  header("X-Slack-Signature", slackSignature(timestamp)),
+			expected: ErrMissingSignature,
+		},
+		{
+			name:    "shopify valid",
+			profile: Profile{Type: "shopify", Secret: testSecret},
+			headers: header("X-Shopify-Hmac-Sha256", hmacBase64(testSecret, string(testBody))),
+		},
+		{
+			name:     "shopify hex is invalid",
+			profile:  Profile{Type: "shopify", Secret: testSecret},
+			headers:  header("X-Shopify-Hmac-Sha256", hmacHex(testSecret, string(testBody))),
+			expected: ErrInvalidSignature,
+		},
+		{
+			name:    "hmac hex with prefix",
+			profile: Profile{Type: "hmac", Secret: testSecret, Header: "X-Signature", Prefix: "sha256=", Encoding: "hex"},
+			headers: header("X-Signature", "sha256="+hmacHex(testSecret, string(testBody))),
+		},
+		{
+			name:    "hmac base64",
+			profile: Profile{Type: "hmac", Secret: testSecret, Header: "X-Signature", Encoding: "base64"},
+			headers: header("X-Signature", hmacBase64(testSecret, string(testBody))),
+		},
+		{
+			name:     "hmac missing prefix",
+			profile:  Profile{Type: "hmac", Secret: testSecret, Header: "X-Signature", Prefix: "sha256=", Encoding: "hex"},
+			headers:  header("X-Signature", hmacHex(testSecret, string(testBody))),
+			expected: ErrInvalidSignature,
+		},
+		{
+			name:     "hmac missing header",
+			profile:  Profile{Type: "hmac", Secret: testSecret, Header: "X-Signature", Encoding: "hex"},
+			headers:  header("X-Other", hmacHex(testSecret, string(testBody))),
+			expected: ErrMissingSignature,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.profile.Verify(test.headers, testBody, now)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestVerifyUnknownProfile(t *testing.T) {
+	p := Profile{Type: "unknown", Secret: testSecret}
+
+	if err := p.Verify(header(), testBody, time.Now()); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
@@ -0,0 +1,128 @@
+package verification
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ErrMissingSignature The request does not have a signature.
+var ErrMissingSignature = errors.New("MissingSignature")
+
+// ErrInvalidSignature The signature does not match to the body.
+var ErrInvalidSignature = errors.New("InvalidSignature")
+
+// ErrExpiredSignature The timestamp of the signature is out of the tolerance.
+var ErrExpiredSignature = errors.New("ExpiredSignature")
+
+type Profile struct {
+	// The type of the profile, e.g. github, stripe, slack, shopify or hmac.
+	Type string
+
+	// The secret to calculate the signature.
+	Secret string
+
+	// The header with the signature, only used by generic profiles.
+	Header string
+
+	// The prefix of the signature, e.g. sha256=, only used by generic profiles.
+	Prefix string
+
+	// The encoding of the signature (hex or base64), only used by generic profiles.
+	Encoding string
+
+	// The maximum age of the signature, if the provider sends a timestamp.
+	Tolerance time.Duration
+
+	// Indicates if invalid requests are rejected.
+	Reject bool
+}
+
+type verifier struct {
+	profiles map[string]*Profile
+}
+
+type Verifier interface {
+	GetProfile(endpoint string) *Profile
+}
+
+func NewVerifier(config *viper.Viper) (Verifier, error) {
+	profiles := make(map[string]*Profile)
+
+	for endpoint := range config.GetStringMap("endpoints") {
+		section := config.Sub("endpoints." + endpoint + ".verification")
+		if section == nil {
+			continue
+		}
+
+		section.SetDefault("encoding", "hex")
+		section.SetDefault("tolerance", 5*time.Minute)
+
+		profile := &Profile{
+			Type:      strings.ToLower(section.GetString("type")),
+			Secret:    section.GetString("secret"),
+			Header:    section.GetString("header"),
+			Prefix:    section.GetString("prefix"),
+			Encoding:  strings.ToLower(section.GetString("encoding")),
+			Tolerance: section.GetDuration("tolerance"),
+			Reject:    section.GetBool("reject"),
+		}
+
+		if err := profile.validate(); err != nil {
+			return nil, fmt.Errorf("invalid verification for endpoint '%s': %v", endpoint, err)
+		}
+
+		profiles[endpoint] = profile
+	}
+
+	return &verifier{profiles: profiles}, nil
+}
+
+func (v verifier) GetProfile(endpoint string) *Profile {
+	// Viper keys are not case sensitive.
+	return v.profiles[strings.ToLower(endpoint)]
+}
+
+// Invalid profiles are rejected at startup, otherwise every request would fail or anyone could sign requests with an empty secret.
+func (p *Profile) validate() error {
+	switch p.Type {
+	case "github", "stripe", "slack", "shopify":
+	case "hmac":
+		if p.Header == "" {
+			return errors.New("the type 'hmac' needs a header")
+		}
+
+		if p.Encoding != "hex" && p.Encoding != "base64" {
+			return fmt.Errorf("unknown encoding '%s'", p.Encoding)
+		}
+	default:
+		return fmt.Errorf("unknown type '%s'", p.Type)
+	}
+
+	if p.Secret == "" {
+		return errors.New("the secret is empty")
+	}
+
+	return nil
+}
+
+func (p *Profile) Verify(headers http.Header, body []byte, now time.Time) error {
+	switch p.Type {
+	case "github":
+		return verifyGithub(p, headers, body)
+	case "stripe":
+		return verifyStripe(p, headers, body, now)
+	case "slack":
+		return verifySlack(p, headers, body, now)
+	case "shopify":
+		return verifyShopify(p, headers, body)
+	case "hmac":
+		return verifyHmac(p, headers, body)
+	}
+
+	return errors.New("UnknownProfile")
+}
@@ -0,0 +1,61 @@
+package verification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestNewVerifier(t *testing.T) {
+	config := viper.New()
+	config.Set("endpoints.Stripe.verification.type", "Stripe")
+	config.Set("endpoints.Stripe.verification.secret", testSecret)
+	config.Set("endpoints.Stripe.verification.reject", true)
+	config.Set("endpoints.custom.verification.type", "hmac")
+	config.Set("endpoints.custom.verification.secret", testSecret)
+	config.Set("endpoints.custom.verification.header", "X-Signature")
+	config.Set("endpoints.users.log", true)
+
+	v, err := NewVerifier(config)
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	if p := v.GetProfile("stripe"); p == nil || p.Type != "stripe" || !p.Reject || p.Tolerance != 5*time.Minute {
+		t.Errorf("unexpected stripe profile %+v", p)
+	}
+
+	if p := v.GetProfile("custom"); p == nil || p.Encoding != "hex" {
+		t.Errorf("expected the hex encoding by default, got %+v", p)
+	}
+
+	if p := v.GetProfile("users"); p != nil {
+		t.Errorf("expected no profile, got %+v", p)
+	}
+}
+
+func TestNewVerifierInvalidProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile map[string]any
+	}{
+		{name: "missing secret", profile: map[string]any{"type": "github", "reject": true}},
+		{name: "empty secret", profile: map[string]any{"type": "stripe", "secret": ""}},
+		{name: "missing type", profile: map[string]any{"secret": testSecret}},
+		{name: "unknown type", profile: map[string]any{"type": "githup", "secret": testSecret}},
+		{name: "hmac without header", profile: map[string]any{"type": "hmac", "secret": testSecret}},
+		{name: "hmac with unknown encoding", profile: map[string]any{"type": "hmac", "secret": testSecret, "header": "X-Signature", "encoding": "base32"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := viper.New()
+			config.Set("endpoints.github.verification", test.profile)
+
+			if _, err := NewVerifier(config); err == nil {
+				t.Error("expected the profile to be rejected")
+			}
+		})
+	}
+}